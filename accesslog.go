@@ -0,0 +1,114 @@
+package sentiment
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// AccessLogFormat is an enum defining the format of HTTP access logs
+type AccessLogFormat int
+
+const (
+	// AccessLogStructured logs each request as structured zap fields
+	AccessLogStructured AccessLogFormat = iota
+	// AccessLogCombined logs each request as a line in the Apache Combined Log Format
+	AccessLogCombined
+)
+
+const combinedLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// statusRecorder wraps a ResponseWriter to capture the status code and the size of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.size += n
+	return n, err
+}
+
+// Flush implements the http.Flusher interface if the underlying ResponseWriter supports it
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func accessLogHandler(logger *zap.Logger, format AccessLogFormat, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		duration := time.Since(start)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		switch format {
+		case AccessLogCombined:
+			logger.Info(combinedLogLine(r, start, status, recorder.size))
+		default:
+			logger.Info("Access",
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", status),
+				zap.Int("size", recorder.size),
+				zap.Duration("duration", duration),
+			)
+		}
+	})
+}
+
+func combinedLogLine(r *http.Request, ts time.Time, status int, size int) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	}
+
+	respSize := "-"
+	if size > 0 {
+		respSize = fmt.Sprintf("%d", size)
+	}
+
+	return fmt.Sprintf("%s - %s [%s] %q %d %s %q %q",
+		host,
+		user,
+		ts.Format(combinedLogTimeFormat),
+		fmt.Sprintf("%s %s %s", r.Method, r.RequestURI, r.Proto),
+		status,
+		respSize,
+		orDash(r.Referer()),
+		orDash(r.UserAgent()),
+	)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
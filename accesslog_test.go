@@ -0,0 +1,72 @@
+package sentiment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestAccessLog(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "word1"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 3.0, Score: 0.8},
+			},
+		},
+	}
+
+	t.Run("structured", func(t *testing.T) {
+		core, logs := observer.New(zapcore.InfoLevel)
+
+		mockClient, svc := createMocks(t)
+		svc.conf.logger = zap.New(core)
+		svc.conf.accessLog = true
+		svc.conf.accessLogFormat = AccessLogStructured
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?limit=1", strings.NewReader(`{"content":"word1"}`))
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+		entries := logs.FilterMessage("Access").All()
+		assert.Len(t, entries, 1)
+
+		fields := entries[0].ContextMap()
+		assert.Equal(t, http.MethodPost, fields["method"])
+		assert.Equal(t, "/api", fields["path"])
+		assert.Equal(t, int64(http.StatusOK), fields["status"])
+		assert.Equal(t, int64(responseRecorder.Body.Len()), fields["size"])
+		assert.Contains(t, fields, "duration")
+	})
+
+	t.Run("combined", func(t *testing.T) {
+		core, logs := observer.New(zapcore.InfoLevel)
+
+		_, svc := createMocks(t)
+		svc.conf.logger = zap.New(core)
+		svc.conf.accessLog = true
+		svc.conf.accessLogFormat = AccessLogCombined
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/api?limit=1", nil)
+		request.Header.Set("User-Agent", "test-agent")
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+		entries := logs.FilterMessageSnippet("HTTP/1.1").All()
+		assert.Len(t, entries, 1)
+
+		line := entries[0].Message
+		assert.True(t, strings.HasPrefix(line, "192.0.2.1 - - ["))
+		assert.Contains(t, line, `"GET /api?limit=1 HTTP/1.1" 405 `)
+		assert.True(t, strings.HasSuffix(line, `"-" "test-agent"`))
+	})
+}
@@ -0,0 +1,170 @@
+package sentiment
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/allegro/bigcache"
+)
+
+const (
+	// defaultCacheKeysLimit is the number of cache keys listed per page unless the request asks for another number
+	defaultCacheKeysLimit = 100
+	// maxCacheKeysLimit is the largest page of cache keys that can be requested
+	maxCacheKeysLimit = 1000
+)
+
+// authorizeAdmin checks that the request presents the admin token as a bearer token. If it does not, an error
+// response is written and false is returned. Administrative endpoints are not found when no token is configured.
+func (svc *Service) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if svc.conf.adminToken == "" {
+		http.NotFound(w, r)
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(svc.conf.adminToken)) != 1 {
+		svc.logger.Warnw("Unauthorized request to administrative endpoint", "path", r.URL.Path)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// handleCacheRequest purges the cache on DELETE
+func (svc *Service) handleCacheRequest(w http.ResponseWriter, r *http.Request) {
+	if !svc.authorizeAdmin(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := svc.responses.reset(); err != nil {
+		svc.logger.Errorw("Failed to purge response cache", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := svc.cache.Reset(); err != nil {
+		svc.logger.Errorw("Failed to purge cache", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	svc.logger.Infow("Cache purged")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CacheKeyInfo describes an entry of the cache without revealing the input it was stored for
+type CacheKeyInfo struct {
+	// Hash is the hex encoded 64-bit hash of the cache key used by the cache to index the entry
+	Hash string `json:"hash"`
+	// Size is the size in bytes of the stored entry
+	Size int `json:"size"`
+}
+
+// cacheKeysPage is a page of the cache key listing. Next is the cursor of the following page, if there is one.
+type cacheKeysPage struct {
+	Keys []CacheKeyInfo `json:"keys"`
+	Next string         `json:"next,omitempty"`
+}
+
+// listCacheKeys returns the hashed keys of all the entries of the cache sorted by hash. Entries that expire or are
+// evicted while the cache is being iterated are skipped.
+func listCacheKeys(cache *bigcache.BigCache) []CacheKeyInfo {
+	keys := []CacheKeyInfo{}
+	it := cache.Iterator()
+	for it.SetNext() {
+		// the key itself is never read as it holds the input in clear text
+		entry, err := it.Value()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, CacheKeyInfo{Hash: fmt.Sprintf("%016x", entry.Hash()), Size: len(entry.Value())})
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Hash < keys[j].Hash })
+	return keys
+}
+
+// pageCacheKeys returns at most limit keys with a hash greater than the cursor. As the keys are sorted by hash, pages
+// remain consistent while entries are added and removed.
+func pageCacheKeys(keys []CacheKeyInfo, after string, limit int) cacheKeysPage {
+	start := sort.Search(len(keys), func(i int) bool { return keys[i].Hash > after })
+	keys = keys[start:]
+
+	page := cacheKeysPage{Keys: keys}
+	if len(keys) > limit {
+		page.Keys = keys[:limit]
+		page.Next = page.Keys[limit-1].Hash
+	}
+	return page
+}
+
+// handleCacheKeysRequest lists the hashed keys of the cached results one page at a time. It requires the admin token
+// and is only available for the default in-memory cache, as other caches cannot be iterated.
+func (svc *Service) handleCacheKeysRequest(w http.ResponseWriter, r *http.Request) {
+	if !svc.authorizeAdmin(w, r) {
+		return
+	}
+
+	cache, ok := svc.cache.(*bigcache.BigCache)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	limit := defaultCacheKeysLimit
+	if l := params.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > maxCacheKeysLimit {
+			svc.logger.Warnw("Invalid cache keys limit", "limit", l)
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxCacheKeysLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	svc.writeResponse(w, r, http.StatusOK, pageCacheKeys(listCacheKeys(cache), params.Get("after"), limit))
+}
+
+// methodOverrideHeader lets clients behind proxies that only allow GET and POST send other methods
+const methodOverrideHeader = "X-HTTP-Method-Override"
+
+// methodOverrideHandler replaces the method of POST requests with the method in the override header, if it is one
+// of the allowed methods. Requests asking for any other override are rejected.
+func methodOverrideHandler(allowed map[string]bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		override := strings.ToUpper(strings.TrimSpace(r.Header.Get(methodOverrideHeader)))
+		if override == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !allowed[override] {
+			http.Error(w, "Method override not allowed", http.StatusBadRequest)
+			return
+		}
+
+		overridden := new(http.Request)
+		*overridden = *r
+		overridden.Method = override
+		next.ServeHTTP(w, overridden)
+	})
+}
@@ -0,0 +1,210 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/allegro/bigcache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestCachePurgeHTTPRequest(t *testing.T) {
+	testCases := []struct {
+		name           string
+		overrides      map[string]bool
+		method         string
+		override       string
+		authorization  string
+		expectedStatus int
+		expectedPurged bool
+	}{
+		{
+			name:           "delete",
+			method:         http.MethodDelete,
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusNoContent,
+			expectedPurged: true,
+		},
+		{
+			name:           "delete_unauthorized",
+			method:         http.MethodDelete,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "post",
+			method:         http.MethodPost,
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "post_with_override",
+			overrides:      map[string]bool{http.MethodDelete: true},
+			method:         http.MethodPost,
+			override:       "delete",
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusNoContent,
+			expectedPurged: true,
+		},
+		{
+			name:           "post_with_override_disabled",
+			method:         http.MethodPost,
+			override:       http.MethodDelete,
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "post_with_disallowed_override",
+			overrides:      map[string]bool{http.MethodDelete: true},
+			method:         http.MethodPost,
+			override:       http.MethodPatch,
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "get_with_override",
+			overrides:      map[string]bool{http.MethodDelete: true},
+			method:         http.MethodGet,
+			override:       http.MethodDelete,
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.adminToken = "secret"
+			svc.conf.methodOverrides = tc.overrides
+			cache := svc.cache.(*bigcache.BigCache)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+			_, err := svc.Analyze(context.Background(), "Great.")
+			assert.NoError(t, err)
+			assert.Equal(t, 1, cache.Len())
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(tc.method, "/cache", nil)
+			if tc.override != "" {
+				request.Header.Set(methodOverrideHeader, tc.override)
+			}
+			if tc.authorization != "" {
+				request.Header.Set("Authorization", tc.authorization)
+			}
+			svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+			assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+			if tc.expectedPurged {
+				assert.Equal(t, 0, cache.Len())
+			} else {
+				assert.Equal(t, 1, cache.Len())
+			}
+		})
+	}
+}
+
+func TestCacheKeysHTTPRequest(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	svc.conf.adminToken = "secret"
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+	doRequest := func(target string, authorization string) (int, cacheKeysPage) {
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+		var page cacheKeysPage
+		if responseRecorder.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&page))
+		}
+		return responseRecorder.Code, page
+	}
+
+	status, page := doRequest("/cache/keys", "Bearer secret")
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotNil(t, page.Keys)
+	assert.Empty(t, page.Keys)
+
+	expected := make(map[string]int)
+	for i := 0; i < 5; i++ {
+		input := fmt.Sprintf("Review number %d.", i)
+		_, err := svc.Analyze(context.Background(), input)
+		assert.NoError(t, err)
+
+		key := cacheKey(context.Background(), input, svc.analysisParams())
+		entry, err := svc.cache.Get(key)
+		assert.NoError(t, err)
+		hasher := fnv.New64a()
+		hasher.Write([]byte(key))
+		expected[fmt.Sprintf("%016x", hasher.Sum64())] = len(entry)
+	}
+
+	t.Run("all", func(t *testing.T) {
+		status, page := doRequest("/cache/keys", "Bearer secret")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Empty(t, page.Next)
+
+		listed := make(map[string]int)
+		for _, info := range page.Keys {
+			listed[info.Hash] = info.Size
+		}
+		assert.Equal(t, expected, listed)
+	})
+
+	t.Run("paginated", func(t *testing.T) {
+		listed := make(map[string]int)
+		var hashes []string
+		target := "/cache/keys?limit=2"
+		for pages := 0; pages < 3; pages++ {
+			status, page := doRequest(target, "Bearer secret")
+			assert.Equal(t, http.StatusOK, status)
+			for _, info := range page.Keys {
+				listed[info.Hash] = info.Size
+				hashes = append(hashes, info.Hash)
+			}
+
+			if page.Next == "" {
+				assert.Equal(t, 2, pages)
+				break
+			}
+			target = "/cache/keys?limit=2&after=" + page.Next
+		}
+
+		assert.Equal(t, expected, listed)
+		assert.True(t, sort.StringsAreSorted(hashes))
+	})
+
+	t.Run("invalid_limit", func(t *testing.T) {
+		for _, limit := range []string{"0", "-1", "abc", "1001"} {
+			status, _ := doRequest("/cache/keys?limit="+limit, "Bearer secret")
+			assert.Equal(t, http.StatusBadRequest, status, limit)
+		}
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		status, _ := doRequest("/cache/keys", "Bearer wrong")
+		assert.Equal(t, http.StatusUnauthorized, status)
+	})
+
+	t.Run("unsupported_cache", func(t *testing.T) {
+		_, svc := createMocks(t)
+		svc.conf.adminToken = "secret"
+		svc.cache = &mapCache{entries: make(map[string][]byte)}
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/cache/keys", nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+		assert.Equal(t, http.StatusNotFound, responseRecorder.Code)
+	})
+}
@@ -0,0 +1,157 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// aggregateWeighted is the value of the aggregate parameter requesting the magnitude-weighted document score
+const aggregateWeighted = "weighted"
+
+// ScoreResponse is the output type when the sentences are aggregated into a single document score
+type ScoreResponse struct {
+	Score float32 `json:"score"`
+}
+
+// AggregatedResponse is the output type when duplicate sentences are aggregated
+type AggregatedResponse []AggregatedSentence
+
+// AggregatedSentence describes a group of sentences with identical text
+type AggregatedSentence struct {
+	Text  string  `json:"text"`
+	Score float32 `json:"score"`
+	Count int     `json:"count"`
+}
+
+// aggregateDuplicates returns a copy of the result where sentences with identical normalized text are replaced by
+// a single sentence carrying the average sentiment of the group, in the position of the first occurrence. The size
+// of each group is returned keyed by the replacement sentence.
+func aggregateDuplicates(result *languagepb.AnalyzeSentimentResponse) (*languagepb.AnalyzeSentimentResponse, map[*languagepb.Sentence]int, error) {
+	if result == nil {
+		return nil, nil, nil
+	}
+
+	var sentences []*languagepb.Sentence
+	counts := make(map[*languagepb.Sentence]int)
+	groups := make(map[string]*languagepb.Sentence)
+
+	for i, sentence := range result.Sentences {
+		if sentence.Text == nil || sentence.Sentiment == nil {
+			return nil, nil, fmt.Errorf("malformed sentence at index %d", i)
+		}
+
+		key := strings.ToLower(strings.TrimSpace(sentence.Text.Content))
+		group, ok := groups[key]
+		if !ok {
+			group = &languagepb.Sentence{
+				Text:      sentence.Text,
+				Sentiment: &languagepb.Sentiment{},
+			}
+			groups[key] = group
+			sentences = append(sentences, group)
+		}
+
+		// accumulate the totals and convert them to averages once all the sentences are seen
+		group.Sentiment.Score += sentence.Sentiment.Score
+		group.Sentiment.Magnitude += sentence.Sentiment.Magnitude
+		counts[group]++
+	}
+
+	for _, group := range sentences {
+		n := float32(counts[group])
+		group.Sentiment.Score /= n
+		group.Sentiment.Magnitude /= n
+	}
+
+	// the result may be shared with the cache so it must not be modified
+	aggregated := *result
+	aggregated.Sentences = sentences
+	return &aggregated, counts, nil
+}
+
+// processAPIResultAggregated sorts the aggregated sentences by their average score and produces an AggregatedResponse
+// containing at most limit groups
+func (svc *Service) processAPIResultAggregated(ctx context.Context, result *languagepb.AnalyzeSentimentResponse, counts map[*languagepb.Sentence]int, sortOrder SortOrder, limit int) (AggregatedResponse, error) {
+	if err := ctx.Err(); err != nil {
+		svc.logger.Errorw("Context cancelled", "error", err)
+		return nil, err
+	}
+
+	resp := AggregatedResponse{}
+	if result == nil {
+		return resp, nil
+	}
+
+	sentences := selectSentences(result.Sentences, sortOrder, limit)
+	resp = make(AggregatedResponse, len(sentences))
+	for i, sentence := range sentences {
+		if i > 0 && i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp[i] = AggregatedSentence{
+			Text:  svc.conf.truncateText(sentence.Text.Content),
+			Score: svc.conf.rescale(sentence.Sentiment.Score),
+			Count: counts[sentence],
+		}
+	}
+
+	return resp, nil
+}
+
+// weightedScore computes the average of the sentence scores weighted by their magnitudes and, if byLength is set,
+// also by their lengths. If every magnitude is zero, the magnitudes are ignored.
+func weightedScore(sentences []*languagepb.Sentence, byLength bool) float32 {
+	if len(sentences) == 0 {
+		return 0
+	}
+
+	var weightedSum, totalWeight, sum, total float32
+	for _, sentence := range sentences {
+		weight := float32(1)
+		if byLength {
+			weight = sentenceLength(sentence)
+		}
+
+		weightedSum += sentence.Sentiment.Score * sentence.Sentiment.Magnitude * weight
+		totalWeight += sentence.Sentiment.Magnitude * weight
+		sum += sentence.Sentiment.Score * weight
+		total += weight
+	}
+
+	if totalWeight != 0 {
+		return weightedSum / totalWeight
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return sum / total
+}
+
+// processAPIResultWeighted reduces all the sentences of the result to their magnitude-weighted average score,
+// additionally weighting them by length if byLength is set
+func (svc *Service) processAPIResultWeighted(ctx context.Context, result *languagepb.AnalyzeSentimentResponse, byLength bool) (*ScoreResponse, error) {
+	if err := ctx.Err(); err != nil {
+		svc.logger.Errorw("Context cancelled", "error", err)
+		return nil, err
+	}
+
+	if result == nil {
+		return &ScoreResponse{Score: svc.conf.rescale(0)}, nil
+	}
+
+	for i, sentence := range result.Sentences {
+		if sentence.Text == nil || sentence.Sentiment == nil {
+			return nil, fmt.Errorf("malformed sentence at index %d", i)
+		}
+	}
+
+	return &ScoreResponse{Score: svc.conf.rescale(weightedScore(result.Sentences, byLength))}, nil
+}
@@ -0,0 +1,287 @@
+package sentiment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestAggregateDuplicates(t *testing.T) {
+	apiResult := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great.", BeginOffset: 0},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.8, Score: 0.8},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Terrible.", BeginOffset: 7},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: -0.9},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "great.", BeginOffset: 17},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.4, Score: 0.4},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "It is fine.", BeginOffset: 24},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.1, Score: 0.1},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great.", BeginOffset: 36},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.3, Score: 0.3},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name             string
+		sortOrder        SortOrder
+		limit            int
+		expectedResponse AggregatedResponse
+	}{
+		{
+			name:      "descending",
+			sortOrder: Descending,
+			limit:     -1,
+			expectedResponse: AggregatedResponse{
+				{Text: "Great.", Score: 0.5, Count: 3},
+				{Text: "It is fine.", Score: 0.1, Count: 1},
+				{Text: "Terrible.", Score: -0.9, Count: 1},
+			},
+		},
+		{
+			name:      "ascending_with_limit",
+			sortOrder: Ascending,
+			limit:     2,
+			expectedResponse: AggregatedResponse{
+				{Text: "Terrible.", Score: -0.9, Count: 1},
+				{Text: "It is fine.", Score: 0.1, Count: 1},
+			},
+		},
+		{
+			name:      "document_order",
+			sortOrder: DocumentOrder,
+			limit:     -1,
+			expectedResponse: AggregatedResponse{
+				{Text: "Great.", Score: 0.5, Count: 3},
+				{Text: "Terrible.", Score: -0.9, Count: 1},
+				{Text: "It is fine.", Score: 0.1, Count: 1},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, svc := createMocks(t)
+			aggregated, counts, err := aggregateDuplicates(apiResult)
+			assert.NoError(t, err)
+
+			resp, err := svc.processAPIResultAggregated(context.Background(), aggregated, counts, tc.sortOrder, tc.limit)
+			assert.NoError(t, err)
+			assert.Len(t, resp, len(tc.expectedResponse))
+			for i, expected := range tc.expectedResponse {
+				assert.Equal(t, expected.Text, resp[i].Text)
+				assert.InDelta(t, expected.Score, resp[i].Score, 0.0001)
+				assert.Equal(t, expected.Count, resp[i].Count)
+			}
+		})
+	}
+
+	// the original result must be left untouched
+	assert.Len(t, apiResult.Sentences, 5)
+	assert.Equal(t, float32(0.8), apiResult.Sentences[0].Sentiment.Score)
+}
+
+func TestAggregateDuplicatesHTTPRequest(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great.", BeginOffset: 0},
+				Sentiment: &languagepb.Sentiment{Magnitude: 1, Score: 1},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Bad.", BeginOffset: 7},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: -0.5},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great.", BeginOffset: 12},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		target         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "aggregated",
+			target:         "/api?order=desc&aggregate_duplicates=true",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"text":"Great.","score":0.75,"count":2},{"text":"Bad.","score":-0.5,"count":1}]`,
+		},
+		{
+			name:           "aggregated_with_offset",
+			target:         "/api?order=desc&offset=1&aggregate_duplicates=true",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"text":"Bad.","score":-0.5,"count":1}]`,
+		},
+		{
+			name:           "not_aggregated",
+			target:         "/api?order=document&aggregate_duplicates=false",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"Great.":1},{"Bad.":-0.5},{"Great.":0.5}]`,
+		},
+		{
+			name:           "aggregated_v2",
+			target:         "/api?v=2&aggregate_duplicates=true",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "aggregated_grouped",
+			target:         "/api?group=polarity&aggregate_duplicates=true",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"Great. Bad. Great."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, tc.expectedStatus, result.StatusCode)
+			if tc.expectedStatus == http.StatusOK {
+				assert.JSONEq(t, tc.expectedBody, responseRecorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestWeightedScore(t *testing.T) {
+	sentence := func(score, magnitude float32) *languagepb.Sentence {
+		return &languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "sentence"},
+			Sentiment: &languagepb.Sentiment{Score: score, Magnitude: magnitude},
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		sentences []*languagepb.Sentence
+		expected  float32
+	}{
+		{
+			name:     "empty",
+			expected: 0,
+		},
+		{
+			name:      "single",
+			sentences: []*languagepb.Sentence{sentence(0.6, 0.6)},
+			expected:  0.6,
+		},
+		{
+			// (0.8 * 0.5 + -0.4 * 1.5) / (0.5 + 1.5) = -0.2 / 2
+			name:      "weighted",
+			sentences: []*languagepb.Sentence{sentence(0.8, 0.5), sentence(-0.4, 1.5)},
+			expected:  -0.1,
+		},
+		{
+			// (0.9 * 0.9 + 0.1 * 0.1 + -0.5 * 1.0) / (0.9 + 0.1 + 1.0) = 0.32 / 2
+			name:      "three_sentences",
+			sentences: []*languagepb.Sentence{sentence(0.9, 0.9), sentence(0.1, 0.1), sentence(-0.5, 1.0)},
+			expected:  0.16,
+		},
+		{
+			// (0.5 + -0.1 + 0.2) / 3
+			name:      "zero_magnitudes",
+			sentences: []*languagepb.Sentence{sentence(0.5, 0), sentence(-0.1, 0), sentence(0.2, 0)},
+			expected:  0.2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.InDelta(t, tc.expected, weightedScore(tc.sentences, false), 0.0001)
+		})
+	}
+}
+
+func TestWeightedScoreHTTPRequest(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 1, Score: 0.75},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Awful.", BeginOffset: 7},
+				Sentiment: &languagepb.Sentiment{Magnitude: 3, Score: -0.5},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		target         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			// (0.75 * 1 + -0.5 * 3) / (1 + 3)
+			name:           "weighted",
+			target:         "/api?aggregate=weighted",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"score":-0.1875}`,
+		},
+		{
+			name:           "weighted_ignores_limit",
+			target:         "/api?aggregate=weighted&order=desc&limit=1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"score":-0.1875}`,
+		},
+		{
+			name:           "unknown_aggregate",
+			target:         "/api?aggregate=median",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "weighted_v2",
+			target:         "/api?aggregate=weighted&v=2",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "weighted_grouped",
+			target:         "/api?aggregate=weighted&group=polarity",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"Great. Awful."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, tc.expectedStatus, result.StatusCode)
+			if tc.expectedStatus == http.StatusOK {
+				assert.JSONEq(t, tc.expectedBody, responseRecorder.Body.String())
+			}
+		})
+	}
+}
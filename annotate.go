@@ -0,0 +1,405 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// annotateCachePrefix separates the cached annotations of an input from its cached sentiment
+const annotateCachePrefix = "annotate:"
+
+// AnnotateFeature is an analysis that can be requested from the annotate endpoint
+type AnnotateFeature string
+
+const (
+	// FeatureSentiment reports the sentiment of the document and of each sentence
+	FeatureSentiment AnnotateFeature = "sentiment"
+	// FeatureEntities reports the entities mentioned in the document
+	FeatureEntities AnnotateFeature = "entities"
+	// FeatureEntitySentiment reports the entities mentioned in the document along with their sentiment
+	FeatureEntitySentiment AnnotateFeature = "entity_sentiment"
+	// FeatureSyntax reports the tokens of the document along with their syntactic information
+	FeatureSyntax AnnotateFeature = "syntax"
+	// FeatureCategories classifies the document into content categories
+	FeatureCategories AnnotateFeature = "categories"
+)
+
+// defaultAnnotateFeatures are the features requested when none are specified
+var defaultAnnotateFeatures = []AnnotateFeature{FeatureSentiment, FeatureEntities, FeatureSyntax}
+
+// AnnotateResponse is the combined output of the annotate endpoint. Only the parts corresponding to the requested
+// features are populated.
+type AnnotateResponse struct {
+	Language   string              `json:"language"`
+	Sentiment  *AnnotatedSentiment `json:"sentiment,omitempty"`
+	Sentences  []AnnotatedSentence `json:"sentences,omitempty"`
+	Entities   []AnnotatedEntity   `json:"entities,omitempty"`
+	Tokens     []AnnotatedToken    `json:"tokens,omitempty"`
+	Categories []AnnotatedCategory `json:"categories,omitempty"`
+}
+
+// AnnotatedSentiment is the sentiment of a document, sentence or entity
+type AnnotatedSentiment struct {
+	Score     float32 `json:"score"`
+	Magnitude float32 `json:"magnitude"`
+}
+
+// AnnotatedSentence is a sentence of the document along with its sentiment
+type AnnotatedSentence struct {
+	Text      string              `json:"text"`
+	Offset    int32               `json:"offset"`
+	Sentiment *AnnotatedSentiment `json:"sentiment,omitempty"`
+}
+
+// AnnotatedEntity is an entity mentioned in the document
+type AnnotatedEntity struct {
+	Name      string              `json:"name"`
+	Type      string              `json:"type"`
+	Salience  float32             `json:"salience"`
+	Metadata  map[string]string   `json:"metadata,omitempty"`
+	Sentiment *AnnotatedSentiment `json:"sentiment,omitempty"`
+}
+
+// score returns the sentiment score of the entity, which is neutral if its sentiment was not requested
+func (e AnnotatedEntity) score() float32 {
+	if e.Sentiment == nil {
+		return 0
+	}
+	return e.Sentiment.Score
+}
+
+// AnnotatedToken is a token of the document along with its syntactic information
+type AnnotatedToken struct {
+	Text         string `json:"text"`
+	Offset       int32  `json:"offset"`
+	PartOfSpeech string `json:"part_of_speech"`
+	Lemma        string `json:"lemma"`
+	HeadIndex    int32  `json:"head_index"`
+	Label        string `json:"label"`
+}
+
+// AnnotatedCategory is a content category the document belongs to
+type AnnotatedCategory struct {
+	Name       string  `json:"name"`
+	Confidence float32 `json:"confidence"`
+}
+
+// parseAnnotateFeatures validates a list of feature names, returning the default features if the list is empty
+func parseAnnotateFeatures(names []string) ([]AnnotateFeature, error) {
+	var features []AnnotateFeature
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		switch feature := AnnotateFeature(name); feature {
+		case FeatureSentiment, FeatureEntities, FeatureEntitySentiment, FeatureSyntax, FeatureCategories:
+			features = append(features, feature)
+		default:
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+	}
+
+	if len(features) == 0 {
+		return defaultAnnotateFeatures, nil
+	}
+
+	return features, nil
+}
+
+// annotateRequestFeatures converts the features to their representation in the Google API and returns a canonical
+// key identifying the combination for the cache
+func annotateRequestFeatures(features []AnnotateFeature) (*languagepb.AnnotateTextRequest_Features, string) {
+	reqFeatures := &languagepb.AnnotateTextRequest_Features{}
+	names := make([]string, 0, len(features))
+	seen := make(map[AnnotateFeature]bool, len(features))
+	for _, feature := range features {
+		if seen[feature] {
+			continue
+		}
+		seen[feature] = true
+		names = append(names, string(feature))
+
+		switch feature {
+		case FeatureSentiment:
+			reqFeatures.ExtractDocumentSentiment = true
+		case FeatureEntities:
+			reqFeatures.ExtractEntities = true
+		case FeatureEntitySentiment:
+			reqFeatures.ExtractEntitySentiment = true
+		case FeatureSyntax:
+			reqFeatures.ExtractSyntax = true
+		case FeatureCategories:
+			reqFeatures.ClassifyText = true
+		}
+	}
+
+	sort.Strings(names)
+	return reqFeatures, strings.Join(names, ",")
+}
+
+// Annotate runs the requested analyses of the input with a single call to the Google API, served from the cache
+// when possible. The default features are used if none are given.
+func (svc *Service) Annotate(ctx context.Context, input string, features ...AnnotateFeature) (*AnnotateResponse, error) {
+	if err := ctx.Err(); err != nil {
+		svc.logger.Warnw("Context cancelled", "error", err, "input", input)
+		return nil, err
+	}
+
+	input, err := svc.normalizeInput(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(features) == 0 {
+		features = defaultAnnotateFeatures
+	}
+	reqFeatures, featureKey := annotateRequestFeatures(features)
+
+	params := svc.analysisParams()
+	key := annotateCachePrefix + featureKey + "\x00" + cacheKey(ctx, input, params)
+	if !cacheBypassed(ctx) || svc.conf.cacheOnly {
+		if entry, err := svc.cache.Get(key); err == nil {
+			var resp languagepb.AnnotateTextResponse
+			err := proto.Unmarshal(entry, &resp)
+			if err == nil {
+				return newAnnotateResponse(&resp), nil
+			}
+			svc.logger.Warnw("Ignoring corrupted cache entry", "error", err)
+		}
+	}
+
+	if svc.conf.cacheOnly {
+		return nil, ErrCacheMiss
+	}
+
+	if svc.client == nil || svc.isClosed() {
+		return nil, ErrServiceClosed
+	}
+
+	if err := svc.quota.check(quotaKeyFromContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	// each feature is billed as a separate analysis of the document
+	units := billableUnits(input) * len(strings.Split(featureKey, ","))
+	resp, err := svc.callAnnotateAPI(ctx, units, &languagepb.AnnotateTextRequest{
+		Document:     params.request(input).GetDocument(),
+		Features:     reqFeatures,
+		EncodingType: params.encodingType,
+	})
+	if ctx.Err() == nil {
+		svc.health.record(err == nil)
+	}
+	if err != nil {
+		svc.logger.Errorw("Remote API call failure", "error", err, "input", input)
+		return nil, err
+	}
+
+	if entry, err := proto.Marshal(resp); err != nil {
+		svc.logger.Warnw("Failed to encode cache entry", "error", err)
+	} else {
+		svc.setCachedEntry(key, entry, input)
+	}
+
+	return newAnnotateResponse(resp), nil
+}
+
+func (svc *Service) callAnnotateAPI(ctx context.Context, units int, req *languagepb.AnnotateTextRequest) (*languagepb.AnnotateTextResponse, error) {
+	var resp *languagepb.AnnotateTextResponse
+	err := svc.callWithRetries(ctx, units, func(ctx context.Context) error {
+		var err error
+		resp, err = svc.client.AnnotateText(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func newAnnotateResponse(resp *languagepb.AnnotateTextResponse) *AnnotateResponse {
+	out := &AnnotateResponse{
+		Language:  resp.GetLanguage(),
+		Sentiment: newAnnotatedSentiment(resp.GetDocumentSentiment()),
+	}
+
+	for _, sentence := range resp.GetSentences() {
+		out.Sentences = append(out.Sentences, AnnotatedSentence{
+			Text:      sentence.GetText().GetContent(),
+			Offset:    sentence.GetText().GetBeginOffset(),
+			Sentiment: newAnnotatedSentiment(sentence.GetSentiment()),
+		})
+	}
+
+	for _, entity := range resp.GetEntities() {
+		out.Entities = append(out.Entities, AnnotatedEntity{
+			Name:      entity.GetName(),
+			Type:      entity.GetType().String(),
+			Salience:  entity.GetSalience(),
+			Metadata:  entity.GetMetadata(),
+			Sentiment: newAnnotatedSentiment(entity.GetSentiment()),
+		})
+	}
+
+	for _, token := range resp.GetTokens() {
+		out.Tokens = append(out.Tokens, AnnotatedToken{
+			Text:         token.GetText().GetContent(),
+			Offset:       token.GetText().GetBeginOffset(),
+			PartOfSpeech: token.GetPartOfSpeech().GetTag().String(),
+			Lemma:        token.GetLemma(),
+			HeadIndex:    token.GetDependencyEdge().GetHeadTokenIndex(),
+			Label:        token.GetDependencyEdge().GetLabel().String(),
+		})
+	}
+
+	for _, category := range resp.GetCategories() {
+		out.Categories = append(out.Categories, AnnotatedCategory{Name: category.GetName(), Confidence: category.GetConfidence()})
+	}
+
+	return out
+}
+
+func newAnnotatedSentiment(sentiment *languagepb.Sentiment) *AnnotatedSentiment {
+	if sentiment == nil {
+		return nil
+	}
+
+	return &AnnotatedSentiment{Score: sentiment.GetScore(), Magnitude: sentiment.GetMagnitude()}
+}
+
+// annotateInput is the request body of the annotate endpoint
+type annotateInput struct {
+	Content  string   `json:"content"`
+	Tenant   string   `json:"tenant,omitempty"`
+	Features []string `json:"features,omitempty"`
+}
+
+func (svc *Service) handleAnnotateRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer func() {
+			io.Copy(ioutil.Discard, r.Body)
+			r.Body.Close()
+		}()
+	}
+
+	if !svc.isAnalysisMethod(r.Method) {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var inp annotateInput
+	if !svc.decodeRequestBody(w, r, &inp) {
+		return
+	}
+
+	// the query parameter takes precedence over the body, as for the other parameters
+	names := inp.Features
+	if param := r.URL.Query().Get("features"); param != "" {
+		names = strings.Split(param, ",")
+	}
+
+	features, err := parseAnnotateFeatures(names)
+	if err != nil {
+		svc.logger.Warnw("Invalid features", "error", err)
+		http.Error(w, fmt.Sprintf("Invalid features: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	sortBy := strings.ToLower(r.URL.Query().Get("sort"))
+	if sortBy != "" && sortBy != entitySortSalience && sortBy != entitySortScore {
+		svc.logger.Warnw("Invalid sort parameter", "sort", sortBy)
+		http.Error(w, fmt.Sprintf("Invalid sort: %q", sortBy), http.StatusBadRequest)
+		return
+	}
+	sortOrder, _ := svc.parseSortAndLimit(r.URL.Query())
+
+	ctx := r.Context()
+	if tenant := requestTenant(r, inp.Tenant); tenant != "" {
+		ctx = WithTenant(ctx, tenant)
+	}
+	if requestsNoCache(r, r.URL.Query()) {
+		ctx = WithCacheBypass(ctx)
+	}
+	billing := &billingCounter{}
+	ctx = withBillingCounter(ctx, billing)
+
+	resp, err := svc.Annotate(ctx, inp.Content, features...)
+	if err != nil {
+		svc.logger.Errorw("Request failed", "error", err)
+		writeError(w, err)
+		return
+	}
+
+	if sortBy != "" {
+		sortEntities(resp.Entities, sortBy, sortOrder)
+	}
+
+	w.Header().Set(billableUnitsHeader, billing.String())
+	svc.writeResponse(w, r, http.StatusOK, resp)
+}
+
+const (
+	// entitySortSalience sorts entities by their salience in the document
+	entitySortSalience = "salience"
+	// entitySortScore sorts entities by their sentiment score
+	entitySortScore = "score"
+)
+
+// sortEntities sorts the entities by salience or sentiment score in the given order. Entities with equal values keep
+// the order returned by the Google API, and entities without sentiment are sorted as neutral.
+func sortEntities(entities []AnnotatedEntity, sortBy string, sortOrder SortOrder) {
+	switch {
+	case sortBy == entitySortSalience && sortOrder == Ascending:
+		sort.Stable(bySalienceAsc(entities))
+	case sortBy == entitySortSalience && sortOrder == Descending:
+		sort.Stable(bySalienceDesc(entities))
+	case sortBy == entitySortScore && sortOrder == Ascending:
+		sort.Stable(byEntityScoreAsc(entities))
+	case sortBy == entitySortScore && sortOrder == Descending:
+		sort.Stable(byEntityScoreDesc(entities))
+	}
+}
+
+// Sort interface implementation for sorting entities by ascending order of salience
+type bySalienceAsc []AnnotatedEntity
+
+func (b bySalienceAsc) Len() int { return len(b) }
+
+func (b bySalienceAsc) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b bySalienceAsc) Less(i, j int) bool { return b[i].Salience < b[j].Salience }
+
+// Sort interface implementation for sorting entities by descending order of salience
+type bySalienceDesc []AnnotatedEntity
+
+func (b bySalienceDesc) Len() int { return len(b) }
+
+func (b bySalienceDesc) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b bySalienceDesc) Less(i, j int) bool { return b[i].Salience > b[j].Salience }
+
+// Sort interface implementation for sorting entities by ascending order of sentiment score
+type byEntityScoreAsc []AnnotatedEntity
+
+func (b byEntityScoreAsc) Len() int { return len(b) }
+
+func (b byEntityScoreAsc) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b byEntityScoreAsc) Less(i, j int) bool { return b[i].score() < b[j].score() }
+
+// Sort interface implementation for sorting entities by descending order of sentiment score
+type byEntityScoreDesc []AnnotatedEntity
+
+func (b byEntityScoreDesc) Len() int { return len(b) }
+
+func (b byEntityScoreDesc) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b byEntityScoreDesc) Less(i, j int) bool { return b[i].score() > b[j].score() }
@@ -0,0 +1,313 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var annotateTextResponse = &languagepb.AnnotateTextResponse{
+	Language:          "en",
+	DocumentSentiment: &languagepb.Sentiment{Score: 0.8, Magnitude: 0.8},
+	Sentences: []*languagepb.Sentence{
+		{
+			Text:      &languagepb.TextSpan{Content: "I love Paris."},
+			Sentiment: &languagepb.Sentiment{Score: 0.8, Magnitude: 0.8},
+		},
+	},
+	Entities: []*languagepb.Entity{
+		{Name: "Paris", Type: languagepb.Entity_LOCATION, Salience: 1},
+	},
+}
+
+func matchFeatures(expected languagepb.AnnotateTextRequest_Features) interface{} {
+	return mock.MatchedBy(func(req *languagepb.AnnotateTextRequest) bool {
+		f := req.GetFeatures()
+		return f.GetExtractDocumentSentiment() == expected.ExtractDocumentSentiment &&
+			f.GetExtractEntities() == expected.ExtractEntities &&
+			f.GetExtractEntitySentiment() == expected.ExtractEntitySentiment &&
+			f.GetExtractSyntax() == expected.ExtractSyntax &&
+			f.GetClassifyText() == expected.ClassifyText
+	})
+}
+
+func TestAnnotate(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	mockClient.On("AnnotateText", mock.Anything, matchFeatures(languagepb.AnnotateTextRequest_Features{
+		ExtractDocumentSentiment: true,
+		ExtractEntities:          true,
+	}), mock.Anything).Return(annotateTextResponse, nil).Once()
+
+	resp, err := svc.Annotate(context.Background(), "I love Paris.", FeatureEntities, FeatureSentiment)
+	assert.NoError(t, err)
+	assert.Equal(t, "en", resp.Language)
+	assert.Equal(t, &AnnotatedSentiment{Score: 0.8, Magnitude: 0.8}, resp.Sentiment)
+	assert.Equal(t, []AnnotatedEntity{{Name: "Paris", Type: "LOCATION", Salience: 1}}, resp.Entities)
+
+	// the same features in a different order must be served from the cache
+	cached, err := svc.Annotate(context.Background(), "I love Paris.", FeatureSentiment, FeatureEntities)
+	assert.NoError(t, err)
+	assert.Equal(t, resp, cached)
+	mockClient.AssertExpectations(t)
+
+	// a different combination of features must not share the cache entry
+	mockClient.On("AnnotateText", mock.Anything, matchFeatures(languagepb.AnnotateTextRequest_Features{
+		ExtractDocumentSentiment: true,
+	}), mock.Anything).Return(&languagepb.AnnotateTextResponse{Language: "en"}, nil).Once()
+
+	resp, err = svc.Annotate(context.Background(), "I love Paris.", FeatureSentiment)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Entities)
+	mockClient.AssertExpectations(t)
+
+	// annotations must not be mistaken for cached sentiment results
+	assert.Nil(t, svc.getCachedResult(cacheKey(context.Background(), "I love Paris.", svc.analysisParams())))
+}
+
+func TestAnnotateAnalysisSteps(t *testing.T) {
+	matchContent := func(content string) interface{} {
+		return mock.MatchedBy(func(req *languagepb.AnnotateTextRequest) bool {
+			return req.GetDocument().GetContent() == content
+		})
+	}
+
+	t.Run("invalid_utf8", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+
+		_, err := svc.Annotate(context.Background(), "I love Paris\xff.")
+		assert.IsType(t, &InvalidUTF8Error{}, err)
+		mockClient.AssertNotCalled(t, "AnnotateText", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("preprocessed", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.stripHTML = true
+		svc.conf.preprocessor = strings.ToUpper
+		mockClient.On("AnnotateText", mock.Anything, matchContent("I LOVE PARIS."), mock.Anything).Return(annotateTextResponse, nil).Once()
+
+		_, err := svc.Annotate(context.Background(), "<p>I love <b>Paris</b>.</p>")
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("cache_bypassed", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnnotateText", mock.Anything, mock.Anything, mock.Anything).Return(annotateTextResponse, nil).Twice()
+
+		_, err := svc.Annotate(context.Background(), "I love Paris.")
+		assert.NoError(t, err)
+		_, err = svc.Annotate(WithCacheBypass(context.Background()), "I love Paris.")
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("retried", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithRetries(1)(svc.conf)
+		mockClient.On("AnnotateText", mock.Anything, mock.Anything, mock.Anything).Return(nil, status.Error(codes.Unavailable, "unavailable")).Once()
+		mockClient.On("AnnotateText", mock.Anything, mock.Anything, mock.Anything).Return(annotateTextResponse, nil).Once()
+
+		_, err := svc.Annotate(context.Background(), "I love Paris.")
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("billed_per_feature", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnnotateText", mock.Anything, mock.Anything, mock.Anything).Return(annotateTextResponse, nil).Once()
+		handler := svc.RESTHandler()
+
+		for _, expected := range []string{"2", "0"} {
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/annotate?features=sentiment,entities,sentiment", strings.NewReader(`{"content":"I love Paris."}`))
+			handler.ServeHTTP(responseRecorder, request)
+			assert.Equal(t, http.StatusOK, responseRecorder.Code)
+			assert.Equal(t, expected, responseRecorder.Header().Get(billableUnitsHeader))
+		}
+		mockClient.AssertExpectations(t)
+	})
+}
+
+func TestParseAnnotateFeatures(t *testing.T) {
+	features, err := parseAnnotateFeatures(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultAnnotateFeatures, features)
+
+	features, err = parseAnnotateFeatures([]string{" Syntax", "categories", ""})
+	assert.NoError(t, err)
+	assert.Equal(t, []AnnotateFeature{FeatureSyntax, FeatureCategories}, features)
+
+	_, err = parseAnnotateFeatures([]string{"sentiment", "translation"})
+	assert.Error(t, err)
+}
+
+func TestAnnotateHTTPRequest(t *testing.T) {
+	testCases := []struct {
+		name             string
+		method           string
+		query            string
+		body             string
+		expectedFeatures languagepb.AnnotateTextRequest_Features
+		apiError         error
+		expectedStatus   int
+	}{
+		{
+			name:   "default_features",
+			method: http.MethodPost,
+			body:   `{"content":"I love Paris."}`,
+			expectedFeatures: languagepb.AnnotateTextRequest_Features{
+				ExtractDocumentSentiment: true,
+				ExtractEntities:          true,
+				ExtractSyntax:            true,
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:             "query_features",
+			method:           http.MethodPost,
+			query:            "?features=entity_sentiment,categories",
+			body:             `{"content":"I love Paris."}`,
+			expectedFeatures: languagepb.AnnotateTextRequest_Features{ExtractEntitySentiment: true, ClassifyText: true},
+			expectedStatus:   http.StatusOK,
+		},
+		{
+			name:             "body_features",
+			method:           http.MethodPost,
+			body:             `{"content":"I love Paris.","features":["syntax"]}`,
+			expectedFeatures: languagepb.AnnotateTextRequest_Features{ExtractSyntax: true},
+			expectedStatus:   http.StatusOK,
+		},
+		{
+			name:             "query_overrides_body",
+			method:           http.MethodPost,
+			query:            "?features=entities",
+			body:             `{"content":"I love Paris.","features":["syntax"]}`,
+			expectedFeatures: languagepb.AnnotateTextRequest_Features{ExtractEntities: true},
+			expectedStatus:   http.StatusOK,
+		},
+		{
+			name:           "unknown_feature",
+			method:         http.MethodPost,
+			query:          "?features=sentiment,translation",
+			body:           `{"content":"I love Paris."}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:             "api_error",
+			method:           http.MethodPost,
+			body:             `{"content":"I love Paris."}`,
+			expectedFeatures: languagepb.AnnotateTextRequest_Features{ExtractDocumentSentiment: true, ExtractEntities: true, ExtractSyntax: true},
+			apiError:         errors.New("boom"),
+			expectedStatus:   http.StatusInternalServerError,
+		},
+		{
+			name:           "bad_method",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			if tc.expectedStatus != http.StatusBadRequest && tc.expectedStatus != http.StatusMethodNotAllowed {
+				var apiResponse *languagepb.AnnotateTextResponse
+				if tc.apiError == nil {
+					apiResponse = annotateTextResponse
+				}
+				mockClient.On("AnnotateText", mock.Anything, matchFeatures(tc.expectedFeatures), mock.Anything).Return(apiResponse, tc.apiError).Once()
+			}
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(tc.method, "/annotate"+tc.query, strings.NewReader(tc.body))
+			svc.RESTHandler().ServeHTTP(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, tc.expectedStatus, result.StatusCode)
+			mockClient.AssertExpectations(t)
+
+			if tc.expectedStatus == http.StatusOK {
+				var resp AnnotateResponse
+				assert.NoError(t, json.NewDecoder(result.Body).Decode(&resp))
+				assert.Equal(t, "en", resp.Language)
+				assert.Equal(t, "Paris", resp.Entities[0].Name)
+			}
+		})
+	}
+}
+
+func TestAnnotateEntitySort(t *testing.T) {
+	apiResponse := &languagepb.AnnotateTextResponse{
+		Language: "en",
+		Entities: []*languagepb.Entity{
+			{Name: "hotel", Type: languagepb.Entity_LOCATION, Salience: 0.2, Sentiment: &languagepb.Sentiment{Score: -0.6}},
+			{Name: "Paris", Type: languagepb.Entity_LOCATION, Salience: 0.7, Sentiment: &languagepb.Sentiment{Score: 0.8}},
+			{Name: "staff", Type: languagepb.Entity_PERSON, Salience: 0.1, Sentiment: &languagepb.Sentiment{Score: 0.3}},
+			{Name: "room", Type: languagepb.Entity_OTHER, Salience: 0.2},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedNames  []string
+	}{
+		{name: "api_order", query: "", expectedStatus: http.StatusOK, expectedNames: []string{"hotel", "Paris", "staff", "room"}},
+		{name: "salience_asc", query: "?sort=salience", expectedStatus: http.StatusOK, expectedNames: []string{"staff", "hotel", "room", "Paris"}},
+		{name: "salience_desc", query: "?sort=salience&order=desc", expectedStatus: http.StatusOK, expectedNames: []string{"Paris", "hotel", "room", "staff"}},
+		{name: "score_asc", query: "?sort=score", expectedStatus: http.StatusOK, expectedNames: []string{"hotel", "room", "staff", "Paris"}},
+		{name: "score_desc", query: "?sort=score&order=desc", expectedStatus: http.StatusOK, expectedNames: []string{"Paris", "staff", "room", "hotel"}},
+		{name: "document_order", query: "?sort=salience&order=none", expectedStatus: http.StatusOK, expectedNames: []string{"hotel", "Paris", "staff", "room"}},
+		{name: "unknown_sort", query: "?sort=mentions", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			if tc.expectedStatus == http.StatusOK {
+				mockClient.On("AnnotateText", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil).Once()
+			}
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/annotate"+tc.query, strings.NewReader(`{"content":"I love Paris.","features":["entity_sentiment"]}`))
+			svc.RESTHandler().ServeHTTP(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, tc.expectedStatus, result.StatusCode)
+			mockClient.AssertExpectations(t)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Entities []map[string]interface{} `json:"entities"`
+			}
+			assert.NoError(t, json.NewDecoder(result.Body).Decode(&resp))
+
+			var names []string
+			for _, entity := range resp.Entities {
+				names = append(names, entity["name"].(string))
+			}
+			assert.Equal(t, tc.expectedNames, names)
+
+			// salience must be serialized for every entity
+			for _, entity := range resp.Entities {
+				assert.Contains(t, entity, "salience")
+				if entity["name"] == "Paris" {
+					assert.InDelta(t, 0.7, entity["salience"], 1e-6)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+
+	languagev1beta2 "cloud.google.com/go/language/apiv1beta2"
+	"github.com/golang/protobuf/proto"
+	gax "github.com/googleapis/gax-go"
+	"google.golang.org/api/option"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+	languagepbv1beta2 "google.golang.org/genproto/googleapis/cloud/language/v1beta2"
+)
+
+// APIVersion is an enum defining the version of the Google language API to use
+type APIVersion int
+
+const (
+	// APIVersionV1 is the stable version of the API
+	APIVersionV1 APIVersion = iota
+	// APIVersionV1Beta2 is the beta version of the API which provides access to additional models and features
+	APIVersionV1Beta2
+)
+
+func (v APIVersion) String() string {
+	switch v {
+	case APIVersionV1:
+		return "v1"
+	case APIVersionV1Beta2:
+		return "v1beta2"
+	default:
+		return fmt.Sprintf("APIVersion(%d)", int(v))
+	}
+}
+
+type languageClientV1Beta2 interface {
+	AnalyzeSentiment(context.Context, *languagepbv1beta2.AnalyzeSentimentRequest, ...gax.CallOption) (*languagepbv1beta2.AnalyzeSentimentResponse, error)
+	AnnotateText(context.Context, *languagepbv1beta2.AnnotateTextRequest, ...gax.CallOption) (*languagepbv1beta2.AnnotateTextResponse, error)
+	Close() error
+}
+
+// v1Beta2Adapter implements the languageClient interface using a v1beta2 client. The messages used by the
+// service are wire compatible between the two versions so they are converted by re-encoding. This relies on the
+// golang/protobuf runtime the messages were generated with because it is the one that handles oneof fields.
+type v1Beta2Adapter struct {
+	client languageClientV1Beta2
+}
+
+func newV1Beta2Client(ctx context.Context, opts ...option.ClientOption) (languageClient, error) {
+	client, err := languagev1beta2.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1Beta2Adapter{client: client}, nil
+}
+
+func (a *v1Beta2Adapter) AnalyzeSentiment(ctx context.Context, req *languagepb.AnalyzeSentimentRequest, opts ...gax.CallOption) (*languagepb.AnalyzeSentimentResponse, error) {
+	var betaReq languagepbv1beta2.AnalyzeSentimentRequest
+	if err := convertMessage(req, &betaReq); err != nil {
+		return nil, fmt.Errorf("failed to convert request to v1beta2: %+v", err)
+	}
+
+	betaResp, err := a.client.AnalyzeSentiment(ctx, &betaReq, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp languagepb.AnalyzeSentimentResponse
+	if err := convertMessage(betaResp, &resp); err != nil {
+		return nil, fmt.Errorf("failed to convert response from v1beta2: %+v", err)
+	}
+
+	return &resp, nil
+}
+
+func (a *v1Beta2Adapter) AnnotateText(ctx context.Context, req *languagepb.AnnotateTextRequest, opts ...gax.CallOption) (*languagepb.AnnotateTextResponse, error) {
+	var betaReq languagepbv1beta2.AnnotateTextRequest
+	if err := convertMessage(req, &betaReq); err != nil {
+		return nil, fmt.Errorf("failed to convert request to v1beta2: %+v", err)
+	}
+
+	betaResp, err := a.client.AnnotateText(ctx, &betaReq, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp languagepb.AnnotateTextResponse
+	if err := convertMessage(betaResp, &resp); err != nil {
+		return nil, fmt.Errorf("failed to convert response from v1beta2: %+v", err)
+	}
+
+	return &resp, nil
+}
+
+func (a *v1Beta2Adapter) Close() error {
+	return a.client.Close()
+}
+
+func convertMessage(from proto.Message, to proto.Message) error {
+	b, err := proto.Marshal(from)
+	if err != nil {
+		return err
+	}
+
+	return proto.Unmarshal(b, to)
+}
@@ -0,0 +1,119 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	gax "github.com/googleapis/gax-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+	languagepbv1beta2 "google.golang.org/genproto/googleapis/cloud/language/v1beta2"
+)
+
+type mockLanguageClientV1Beta2 struct {
+	mock.Mock
+}
+
+func (m *mockLanguageClientV1Beta2) AnalyzeSentiment(ctx context.Context, req *languagepbv1beta2.AnalyzeSentimentRequest, opts ...gax.CallOption) (*languagepbv1beta2.AnalyzeSentimentResponse, error) {
+	args := m.MethodCalled("AnalyzeSentiment", ctx, req, opts)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*languagepbv1beta2.AnalyzeSentimentResponse), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+func (m *mockLanguageClientV1Beta2) AnnotateText(ctx context.Context, req *languagepbv1beta2.AnnotateTextRequest, opts ...gax.CallOption) (*languagepbv1beta2.AnnotateTextResponse, error) {
+	args := m.MethodCalled("AnnotateText", ctx, req, opts)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*languagepbv1beta2.AnnotateTextResponse), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+func (m *mockLanguageClientV1Beta2) Close() error {
+	args := m.MethodCalled("Close")
+	return args.Error(0)
+}
+
+func TestAPIVersions(t *testing.T) {
+	t.Run("v1", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+			Sentences: []*languagepb.Sentence{
+				&languagepb.Sentence{
+					Text:      &languagepb.TextSpan{Content: "word1"},
+					Sentiment: &languagepb.Sentiment{Magnitude: 3.0, Score: 0.8},
+				},
+			},
+		}, nil)
+
+		resp, err := svc.ProcessSentiment(context.Background(), "word1", Ascending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response([]map[string]float32{map[string]float32{"word1": 0.8}}), resp)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("v1beta2", func(t *testing.T) {
+		betaClient := &mockLanguageClientV1Beta2{}
+		_, svc := createMocks(t)
+		svc.client = &v1Beta2Adapter{client: betaClient}
+
+		expectedRequest := &languagepbv1beta2.AnalyzeSentimentRequest{
+			Document: &languagepbv1beta2.Document{
+				Source: &languagepbv1beta2.Document_Content{Content: "word1 word2"},
+				Type:   languagepbv1beta2.Document_PLAIN_TEXT,
+			},
+		}
+
+		betaClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(&languagepbv1beta2.AnalyzeSentimentResponse{
+			DocumentSentiment: &languagepbv1beta2.Sentiment{Magnitude: 1.0, Score: 0.1},
+			Language:          "en",
+			Sentences: []*languagepbv1beta2.Sentence{
+				&languagepbv1beta2.Sentence{
+					Text:      &languagepbv1beta2.TextSpan{Content: "word1", BeginOffset: 0},
+					Sentiment: &languagepbv1beta2.Sentiment{Magnitude: 3.0, Score: 0.8},
+				},
+				&languagepbv1beta2.Sentence{
+					Text:      &languagepbv1beta2.TextSpan{Content: "word2", BeginOffset: 6},
+					Sentiment: &languagepbv1beta2.Sentiment{Magnitude: 1.0, Score: -0.4},
+				},
+			},
+		}, nil)
+
+		result, err := svc.Analyze(context.Background(), "word1 word2")
+		assert.NoError(t, err)
+		assert.Equal(t, "en", result.Language)
+		assert.Equal(t, float32(0.1), result.DocumentSentiment.Score)
+		assert.Equal(t, int32(6), result.Sentences[1].Text.BeginOffset)
+
+		resp, err := svc.ProcessSentiment(context.Background(), "word1 word2", Descending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response([]map[string]float32{
+			map[string]float32{"word1": 0.8},
+			map[string]float32{"word2": -0.4},
+		}), resp)
+		betaClient.AssertExpectations(t)
+
+		betaClient.On("Close").Return(nil)
+		assert.NoError(t, svc.Close())
+	})
+
+	t.Run("v1beta2_error", func(t *testing.T) {
+		betaClient := &mockLanguageClientV1Beta2{}
+		_, svc := createMocks(t)
+		svc.client = &v1Beta2Adapter{client: betaClient}
+		betaClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, fmt.Errorf("error"))
+
+		_, err := svc.ProcessSentiment(context.Background(), "word1", Ascending, -1)
+		assert.Error(t, err)
+	})
+
+	t.Run("unsupported_version", func(t *testing.T) {
+		_, err := NewService(WithAPIVersion(APIVersion(42)))
+		assert.EqualError(t, err, "unsupported API version: APIVersion(42)")
+	})
+}
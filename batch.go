@@ -0,0 +1,377 @@
+package sentiment
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// batchInput is either a list of documents or a set of named fields, such as the title and body of a review
+type batchInput struct {
+	Documents []input           `json:"documents"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// batchOutput is the HTTP output of a batch: the outcome of each document, in the order of the documents or keyed by
+// field name, and how many of them were analyzed successfully
+type batchOutput struct {
+	Results   interface{} `json:"results"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+}
+
+// batchOutputElement is the outcome of a single document of a batch, which carries either a result or an error
+type batchOutputElement struct {
+	Index  int      `json:"index"`
+	Result Response `json:"result"`
+	Error  string   `json:"error"`
+	// keyed elements are identified by their field name instead of their index
+	keyed bool
+}
+
+// MarshalJSON writes the index of the element, unless it is keyed, followed by exactly one of the result and the error
+func (e batchOutputElement) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	if !e.keyed {
+		fmt.Fprintf(&buf, `"index":%d,`, e.Index)
+	}
+
+	var value []byte
+	var err error
+	if e.Error != "" {
+		buf.WriteString(`"error":`)
+		value, err = marshalJSONUnescaped(e.Error)
+	} else {
+		buf.WriteString(`"result":`)
+		value, err = marshalJSONUnescaped(e.Result)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buf.Write(value)
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// BatchResult holds the outcome of processing a single document of a batch
+type BatchResult struct {
+	Response Response
+	Err      error
+}
+
+// ProcessBatch processes each of the inputs with a bounded pool of workers and returns the results in the same
+// order as the inputs. If the context is cancelled, documents that have not been picked up by a worker are
+// abandoned and their results carry the context error.
+func (svc *Service) ProcessBatch(ctx context.Context, inputs []string, sort SortOrder, limit int) []BatchResult {
+	return svc.processBatch(ctx, inputs, sort, limit, nil)
+}
+
+// ProcessFields processes each of the named fields like ProcessBatch and returns the results keyed by field name
+func (svc *Service) ProcessFields(ctx context.Context, fields map[string]string, sort SortOrder, limit int) map[string]BatchResult {
+	names, inputs := splitFields(fields)
+	results := svc.ProcessBatch(ctx, inputs, sort, limit)
+
+	keyed := make(map[string]BatchResult, len(names))
+	for i, name := range names {
+		keyed[name] = results[i]
+	}
+	return keyed
+}
+
+// splitFields returns the names of the fields in a stable order and their contents in the same order
+func splitFields(fields map[string]string) ([]string, []string) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	inputs := make([]string, len(names))
+	for i, name := range names {
+		inputs[i] = fields[name]
+	}
+	return names, inputs
+}
+
+// isFatalBatchError reports whether the error would affect every document of a batch, such as the credentials of the
+// service being rejected, so that processing the remaining documents is pointless
+func isFatalBatchError(err error) bool {
+	if err == ErrServiceClosed {
+		return true
+	}
+
+	if _, ok := err.(*QuotaExceededError); ok {
+		return true
+	}
+
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unauthenticated, codes.PermissionDenied:
+			return true
+		}
+	}
+
+	return false
+}
+
+// processBatch implements ProcessBatch, calling onDone from the worker goroutines after each document is processed
+func (svc *Service) processBatch(ctx context.Context, inputs []string, sort SortOrder, limit int, onDone func()) []BatchResult {
+	results := make([]BatchResult, len(inputs))
+
+	// in fail fast mode, the first fatal error abandons the documents that have not been processed yet
+	cancelFunc := func() {}
+	if svc.conf.batchFailFast {
+		ctx, cancelFunc = context.WithCancel(ctx)
+	}
+	defer cancelFunc()
+
+	numWorkers := svc.conf.batchConcurrency
+	if numWorkers <= 0 {
+		numWorkers = 1
+	}
+	if numWorkers > len(inputs) {
+		numWorkers = len(inputs)
+	}
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range work {
+				// the context may have been cancelled while the document was being handed over
+				if err := ctx.Err(); err != nil {
+					results[idx] = BatchResult{Err: err}
+				} else {
+					resp, err := svc.ProcessSentiment(ctx, inputs[idx], sort, limit)
+					results[idx] = BatchResult{Response: resp, Err: err}
+					if err != nil && svc.conf.batchFailFast && isFatalBatchError(err) {
+						svc.logger.Warnw("Abandoning batch after fatal error", "error", err)
+						cancelFunc()
+					}
+				}
+				if onDone != nil {
+					onDone()
+				}
+			}
+		}()
+	}
+
+	next := 0
+feed:
+	for ; next < len(inputs); next++ {
+		select {
+		case work <- next:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	for ; next < len(inputs); next++ {
+		results[next] = BatchResult{Err: ctx.Err()}
+	}
+
+	return results
+}
+
+// batchRequest holds the parsed contents of a batch HTTP request
+type batchRequest struct {
+	ctx       context.Context
+	inputs    []string
+	fields    []string
+	sortOrder SortOrder
+	limit     int
+}
+
+// parseBatchRequest validates and decodes a batch HTTP request. If the request is invalid, an error response is
+// written and false is returned.
+func (svc *Service) parseBatchRequest(w http.ResponseWriter, r *http.Request) (*batchRequest, bool) {
+	if !svc.isAnalysisMethod(r.Method) {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	if !svc.checkQueryLength(w, r) {
+		return nil, false
+	}
+
+	var inp batchInput
+	if !svc.decodeRequestBody(w, r, &inp) {
+		return nil, false
+	}
+
+	if len(inp.Documents) > 0 && len(inp.Fields) > 0 {
+		svc.logger.Warnw("Batch request has both documents and fields")
+		http.Error(w, "Either documents or fields may be given, not both", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if size := len(inp.Documents) + len(inp.Fields); svc.conf.maxBatchSize > 0 && size > svc.conf.maxBatchSize {
+		svc.logger.Warnw("Batch request too large", "size", size, "max", svc.conf.maxBatchSize)
+		http.Error(w, fmt.Sprintf("Batch may not have more than %d documents", svc.conf.maxBatchSize), http.StatusBadRequest)
+		return nil, false
+	}
+
+	for i, doc := range inp.Documents {
+		if field := unsupportedDocumentField(doc); field != "" {
+			svc.logger.Warnw("Batch document has unsupported field", "index", i, "field", field)
+			http.Error(w, fmt.Sprintf("Document %d: %s is not supported in batches", i, field), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	params := r.URL.Query()
+	if !svc.checkRepeatedParams(w, params) {
+		return nil, false
+	}
+
+	req := &batchRequest{ctx: r.Context()}
+	req.sortOrder, req.limit = svc.parseSortAndLimit(params)
+	if len(inp.Fields) > 0 {
+		req.fields, req.inputs = splitFields(inp.Fields)
+	} else {
+		req.inputs = make([]string, len(inp.Documents))
+		for i, doc := range inp.Documents {
+			req.inputs[i] = doc.Content
+		}
+	}
+
+	if tenant := requestTenant(r, ""); tenant != "" {
+		req.ctx = WithTenant(req.ctx, tenant)
+	}
+
+	return req, true
+}
+
+// unsupportedDocumentField returns the name of the first field of a batch document, other than its content, that the
+// batch endpoints would otherwise ignore. The tenant and parameters apply to the whole batch and are given in the
+// header and the query.
+func unsupportedDocumentField(doc input) string {
+	switch {
+	case doc.URL != "":
+		return "url"
+	case doc.Tenant != "":
+		return "tenant"
+	case len(doc.LanguageHints) > 0:
+		return "language_hints"
+	case doc.Order != "":
+		return "order"
+	case doc.Limit != nil:
+		return "limit"
+	case doc.Offset != nil:
+		return "offset"
+	}
+	return ""
+}
+
+// batchErrorMessage describes why a document of a batch could not be analyzed without exposing the details of the
+// error, which may reveal the internals of the service
+func batchErrorMessage(err error) string {
+	switch err {
+	case context.DeadlineExceeded:
+		return "Analysis timed out"
+	case context.Canceled:
+		return "Analysis abandoned"
+	case ErrCacheMiss:
+		return "Result not cached"
+	case ErrServiceClosed:
+		return "Service is shutting down"
+	}
+
+	switch e := err.(type) {
+	case *InputTooShortError:
+		return "Document too short: " + e.Error()
+	case *InvalidUTF8Error:
+		return "Invalid document: " + e.Error()
+	case *QuotaExceededError:
+		return "Quota exceeded"
+	}
+
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.InvalidArgument:
+			return "Document rejected by the Google API, for example because its language is not supported"
+		case codes.DeadlineExceeded:
+			return "Analysis timed out"
+		case codes.ResourceExhausted:
+			return "Google API rate limit exceeded"
+		case codes.Unauthenticated, codes.PermissionDenied:
+			return "Service not authorized to use the Google API"
+		case codes.Unavailable:
+			return "Google API unavailable"
+		}
+	}
+
+	return "Failed to analyze document"
+}
+
+// output converts the results of the batch to the HTTP output format. The results of fields are keyed by field name.
+func (req *batchRequest) output(results []BatchResult) batchOutput {
+	var output batchOutput
+	elements := make([]batchOutputElement, len(results))
+	for i, result := range results {
+		elements[i] = batchOutputElement{Index: i, keyed: req.fields != nil}
+		if result.Err != nil {
+			elements[i].Error = batchErrorMessage(result.Err)
+			output.Failed++
+			continue
+		}
+
+		elements[i].Result = result.Response
+		if elements[i].Result == nil {
+			elements[i].Result = Response{}
+		}
+		output.Succeeded++
+	}
+
+	if req.fields == nil {
+		output.Results = elements
+		return output
+	}
+
+	keyed := make(map[string]batchOutputElement, len(req.fields))
+	for i, name := range req.fields {
+		keyed[name] = elements[i]
+	}
+	output.Results = keyed
+	return output
+}
+
+func (svc *Service) handleBatchRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer func() {
+			io.Copy(ioutil.Discard, r.Body)
+			r.Body.Close()
+		}()
+	}
+
+	req, ok := svc.parseBatchRequest(w, r)
+	if !ok {
+		return
+	}
+
+	billing := &billingCounter{}
+	results := svc.ProcessBatch(withBillingCounter(req.ctx, billing), req.inputs, req.sortOrder, req.limit)
+	if err := req.ctx.Err(); err != nil {
+		svc.logger.Errorw("Batch request failed", "error", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set(billableUnitsHeader, billing.String())
+	svc.writeResponse(w, r, http.StatusOK, req.output(results))
+}
@@ -0,0 +1,456 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// batchDocumentsOutput is the HTTP output of a batch of documents
+type batchDocumentsOutput struct {
+	Results   []batchOutputElement `json:"results"`
+	Succeeded int                  `json:"succeeded"`
+	Failed    int                  `json:"failed"`
+}
+
+func TestProcessBatch(t *testing.T) {
+	t.Run("bounded_concurrency_preserves_order", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.batchConcurrency = 3
+
+		var inFlight, maxInFlight int32
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil).Run(func(args mock.Arguments) {
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				m := atomic.LoadInt32(&maxInFlight)
+				if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+		})
+
+		inputs := make([]string, 50)
+		for i := range inputs {
+			inputs[i] = fmt.Sprintf("document %d", i)
+		}
+
+		results := svc.ProcessBatch(context.Background(), inputs, Ascending, -1)
+		assert.Len(t, results, len(inputs))
+		assert.True(t, atomic.LoadInt32(&maxInFlight) <= 3)
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", len(inputs))
+	})
+
+	t.Run("results_in_input_order", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.batchConcurrency = 4
+
+		inputs := make([]string, 20)
+		for i := range inputs {
+			inputs[i] = fmt.Sprintf("document %d", i)
+			req := &languagepb.AnalyzeSentimentRequest{
+				Document: &languagepb.Document{
+					Source: &languagepb.Document_Content{Content: inputs[i]},
+					Type:   languagepb.Document_PLAIN_TEXT,
+				},
+			}
+			resp := &languagepb.AnalyzeSentimentResponse{
+				Sentences: []*languagepb.Sentence{
+					&languagepb.Sentence{
+						Text:      &languagepb.TextSpan{Content: inputs[i]},
+						Sentiment: &languagepb.Sentiment{Score: float32(i) / 100},
+					},
+				},
+			}
+			// earlier documents take longer to complete
+			mockClient.On("AnalyzeSentiment", mock.Anything, req, mock.Anything).Return(resp, nil).After(time.Duration(len(inputs)-i) * time.Millisecond)
+		}
+
+		results := svc.ProcessBatch(context.Background(), inputs, Ascending, -1)
+		for i, result := range results {
+			assert.NoError(t, result.Err)
+			assert.Equal(t, Response([]map[string]float32{map[string]float32{inputs[i]: float32(i) / 100}}), result.Response)
+		}
+	})
+
+	t.Run("cancellation_abandons_remaining_work", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.batchConcurrency = 2
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		var once sync.Once
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil).Run(func(args mock.Arguments) {
+			once.Do(cancelFunc)
+		})
+
+		inputs := make([]string, 50)
+		for i := range inputs {
+			inputs[i] = fmt.Sprintf("document %d", i)
+		}
+
+		results := svc.ProcessBatch(ctx, inputs, Ascending, -1)
+		assert.Len(t, results, len(inputs))
+		assert.Equal(t, context.Canceled, results[len(results)-1].Err)
+
+		calls := 0
+		for _, call := range mockClient.Calls {
+			if call.Method == "AnalyzeSentiment" {
+				calls++
+			}
+		}
+		assert.True(t, calls <= 2)
+	})
+}
+
+func TestBatchFailFast(t *testing.T) {
+	withContent := func(content string) interface{} {
+		return mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+			return req.GetDocument().GetContent() == content
+		})
+	}
+
+	inputs := make([]string, 20)
+	for i := range inputs {
+		inputs[i] = fmt.Sprintf("document %d", i)
+	}
+
+	testCases := []struct {
+		name          string
+		failFast      bool
+		err           error
+		expectStopped bool
+	}{
+		{name: "fatal_error", failFast: true, err: status.Error(codes.Unauthenticated, "invalid credentials"), expectStopped: true},
+		{name: "permission_denied", failFast: true, err: status.Error(codes.PermissionDenied, "API disabled"), expectStopped: true},
+		{name: "document_error", failFast: true, err: status.Error(codes.InvalidArgument, "unsupported language")},
+		{name: "best_effort_by_default", err: status.Error(codes.Unauthenticated, "invalid credentials")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.batchConcurrency = 2
+			svc.conf.batchFailFast = tc.failFast
+			mockClient.On("AnalyzeSentiment", mock.Anything, withContent(inputs[0]), mock.Anything).Return(nil, tc.err)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).After(5 * time.Millisecond)
+
+			results := svc.ProcessBatch(context.Background(), inputs, Descending, -1)
+			assert.Len(t, results, len(inputs))
+			assert.Equal(t, tc.err, results[0].Err)
+
+			calls := 0
+			for _, call := range mockClient.Calls {
+				if call.Method == "AnalyzeSentiment" {
+					calls++
+				}
+			}
+
+			if tc.expectStopped {
+				// only the documents already handed to a worker are analyzed
+				assert.True(t, calls <= svc.conf.batchConcurrency)
+				assert.Equal(t, context.Canceled, results[len(results)-1].Err)
+				return
+			}
+
+			assert.Equal(t, len(inputs), calls)
+			for _, result := range results[1:] {
+				assert.NoError(t, result.Err)
+			}
+		})
+	}
+}
+
+func TestBatchHTTPRequest(t *testing.T) {
+	slowCall := func(args mock.Arguments) {
+		ctx := args.Get(0).(context.Context)
+		select {
+		case <-ctx.Done():
+		case <-time.After(1 * time.Second):
+		}
+	}
+
+	t.Run("success", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+			Sentences: []*languagepb.Sentence{
+				&languagepb.Sentence{
+					Text:      &languagepb.TextSpan{Content: "word1"},
+					Sentiment: &languagepb.Sentiment{Magnitude: 3.0, Score: 0.8},
+				},
+			},
+		}, nil)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`{"documents":[{"content":"word1"},{"content":"word1 again"}]}`))
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+		result := responseRecorder.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+
+		var output batchDocumentsOutput
+		assert.NoError(t, json.NewDecoder(result.Body).Decode(&output))
+		assert.Len(t, output.Results, 2)
+		for _, elem := range output.Results {
+			assert.Empty(t, elem.Error)
+			assert.Equal(t, Response([]map[string]float32{map[string]float32{"word1": 0.8}}), elem.Result)
+		}
+	})
+
+	t.Run("per_call_timeout", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.requestTimeout = 20 * time.Millisecond
+		svc.conf.handlerTimeout = 1 * time.Second
+
+		var deadlines []time.Duration
+		var mu sync.Mutex
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, context.DeadlineExceeded).Run(func(args mock.Arguments) {
+			deadline, ok := args.Get(0).(context.Context).Deadline()
+			assert.True(t, ok)
+			mu.Lock()
+			deadlines = append(deadlines, time.Until(deadline))
+			mu.Unlock()
+			slowCall(args)
+		})
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`{"documents":[{"content":"doc1"},{"content":"doc2"}]}`))
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+		result := responseRecorder.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+
+		var output batchDocumentsOutput
+		assert.NoError(t, json.NewDecoder(result.Body).Decode(&output))
+		assert.Len(t, output.Results, 2)
+		for _, elem := range output.Results {
+			assert.NotEmpty(t, elem.Error)
+		}
+
+		assert.Len(t, deadlines, 2)
+		for _, d := range deadlines {
+			assert.True(t, d <= 20*time.Millisecond)
+		}
+	})
+
+	t.Run("handler_timeout", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.requestTimeout = 20 * time.Millisecond
+		svc.conf.handlerTimeout = 50 * time.Millisecond
+		svc.conf.batchConcurrency = 1
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, context.DeadlineExceeded).Run(slowCall)
+
+		documents := make([]string, 20)
+		for i := range documents {
+			documents[i] = fmt.Sprintf(`{"content":"doc%d"}`, i)
+		}
+
+		start := time.Now()
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`{"documents":[`+strings.Join(documents, ",")+`]}`))
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+		assert.Equal(t, http.StatusGatewayTimeout, responseRecorder.Result().StatusCode)
+		assert.True(t, time.Since(start) < 20*20*time.Millisecond)
+		assert.True(t, len(mockClient.Calls) < len(documents))
+	})
+}
+
+func TestBatchErrorSummary(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Great."), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+		},
+	}, nil)
+	mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("..."), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+	mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Hola."), mock.Anything).Return(nil, status.Error(codes.InvalidArgument, "The language xx is not supported"))
+	mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Broken."), mock.Anything).Return(nil, status.Error(codes.Unavailable, "connection reset by 10.0.0.1"))
+
+	responseRecorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`{"documents":[{"content":"Great."},{"content":"Hola."},{"content":"..."},{"content":"Broken."}]}`))
+	svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.JSONEq(t, `{
+		"results": [
+			{"index": 0, "result": [{"Great.": 0.5}]},
+			{"index": 1, "error": "Document rejected by the Google API, for example because its language is not supported"},
+			{"index": 2, "result": []},
+			{"index": 3, "error": "Google API unavailable"}
+		],
+		"succeeded": 2,
+		"failed": 2
+	}`, responseRecorder.Body.String())
+}
+
+func TestBatchErrorMessage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "deadline", err: context.DeadlineExceeded, expected: "Analysis timed out"},
+		{name: "cancelled", err: context.Canceled, expected: "Analysis abandoned"},
+		{name: "cache_miss", err: ErrCacheMiss, expected: "Result not cached"},
+		{name: "closed", err: ErrServiceClosed, expected: "Service is shutting down"},
+		{name: "too_short", err: &InputTooShortError{Tokens: 1, MinTokens: 3}, expected: "Document too short: input has 1 tokens, at least 3 required"},
+		{name: "quota", err: &QuotaExceededError{RetryAfter: time.Minute}, expected: "Quota exceeded"},
+		{name: "rate_limited", err: status.Error(codes.ResourceExhausted, "quota of project 1234 exceeded"), expected: "Google API rate limit exceeded"},
+		{name: "unauthorized", err: status.Error(codes.PermissionDenied, "service account foo@bar denied"), expected: "Service not authorized to use the Google API"},
+		{name: "api_deadline", err: status.Error(codes.DeadlineExceeded, "deadline"), expected: "Analysis timed out"},
+		{name: "internal", err: status.Error(codes.Internal, "stack trace"), expected: "Failed to analyze document"},
+		{name: "other", err: assert.AnError, expected: "Failed to analyze document"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, batchErrorMessage(tc.err))
+		})
+	}
+}
+
+func TestBatchUnsupportedDocumentFields(t *testing.T) {
+	testCases := []struct {
+		name           string
+		document       string
+		expectedStatus int
+		expectedError  string
+	}{
+		{name: "content_only", document: `{"content":"a"}`, expectedStatus: http.StatusOK},
+		{name: "url", document: `{"url":"https://example.com/review.html"}`, expectedStatus: http.StatusBadRequest, expectedError: "Document 1: url is not supported in batches"},
+		{name: "tenant", document: `{"content":"b","tenant":"tenantA"}`, expectedStatus: http.StatusBadRequest, expectedError: "Document 1: tenant is not supported in batches"},
+		{name: "language_hints", document: `{"content":"b","language_hints":["fr"]}`, expectedStatus: http.StatusBadRequest, expectedError: "Document 1: language_hints is not supported in batches"},
+		{name: "limit", document: `{"content":"b","limit":1}`, expectedStatus: http.StatusBadRequest, expectedError: "Document 1: limit is not supported in batches"},
+	}
+
+	for _, tc := range testCases {
+		for _, target := range []string{"/batch", "/batch/sse"} {
+			t.Run(tc.name+target, func(t *testing.T) {
+				mockClient, svc := createMocks(t)
+				mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+				responseRecorder := httptest.NewRecorder()
+				request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"documents":[{"content":"a"},`+tc.document+`]}`))
+				svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+				assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+				if tc.expectedStatus != http.StatusOK {
+					assert.Equal(t, tc.expectedError, strings.TrimSpace(responseRecorder.Body.String()))
+					mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+				}
+			})
+		}
+	}
+}
+
+func TestMaxBatchSize(t *testing.T) {
+	testCases := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "documents_at_max", body: `{"documents":[{"content":"a"},{"content":"b"}]}`, expectedStatus: http.StatusOK},
+		{name: "documents_over_max", body: `{"documents":[{"content":"a"},{"content":"b"},{"content":"c"}]}`, expectedStatus: http.StatusBadRequest},
+		{name: "fields_over_max", body: `{"fields":{"title":"a","body":"b","summary":"c"}}`, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		for _, target := range []string{"/batch", "/batch/sse"} {
+			t.Run(tc.name+target, func(t *testing.T) {
+				mockClient, svc := createMocks(t)
+				WithMaxBatchSize(2)(svc.conf)
+				mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+				responseRecorder := httptest.NewRecorder()
+				request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(tc.body))
+				svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+				assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+				if tc.expectedStatus != http.StatusOK {
+					mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+				}
+			})
+		}
+	}
+}
+
+func TestBatchFields(t *testing.T) {
+	sentenceResponse := func(text string, score float32) *languagepb.AnalyzeSentimentResponse {
+		return &languagepb.AnalyzeSentimentResponse{
+			Sentences: []*languagepb.Sentence{
+				&languagepb.Sentence{
+					Text:      &languagepb.TextSpan{Content: text},
+					Sentiment: &languagepb.Sentiment{Score: score},
+				},
+			},
+		}
+	}
+
+	mockFields := func(mockClient *mockLanguageClient) {
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Great value"), mock.Anything).Return(sentenceResponse("Great value", 0.8), nil)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("It broke after a week."), mock.Anything).Return(sentenceResponse("It broke after a week.", -0.7), nil)
+	}
+
+	t.Run("process_fields", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockFields(mockClient)
+
+		results := svc.ProcessFields(context.Background(), map[string]string{"title": "Great value", "body": "It broke after a week."}, Ascending, -1)
+		assert.Len(t, results, 2)
+		assert.NoError(t, results["title"].Err)
+		assert.Equal(t, Response([]map[string]float32{{"Great value": 0.8}}), results["title"].Response)
+		assert.NoError(t, results["body"].Err)
+		assert.Equal(t, Response([]map[string]float32{{"It broke after a week.": -0.7}}), results["body"].Response)
+	})
+
+	t.Run("http_request", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockFields(mockClient)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`{"fields":{"title":"Great value","body":"It broke after a week."}}`))
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+		result := responseRecorder.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+
+		var output struct {
+			Results   map[string]batchOutputElement `json:"results"`
+			Succeeded int                           `json:"succeeded"`
+			Failed    int                           `json:"failed"`
+		}
+		assert.NoError(t, json.NewDecoder(result.Body).Decode(&output))
+		assert.Equal(t, map[string]batchOutputElement{
+			"title": {Result: Response([]map[string]float32{{"Great value": 0.8}})},
+			"body":  {Result: Response([]map[string]float32{{"It broke after a week.": -0.7}})},
+		}, output.Results)
+		assert.Equal(t, 2, output.Succeeded)
+		assert.Equal(t, 0, output.Failed)
+	})
+
+	t.Run("documents_and_fields", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`{"documents":[{"content":"doc"}],"fields":{"title":"Great value"}}`))
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+		assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
+		mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,49 @@
+package sentiment
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// billingUnitChars is the number of characters the Google API bills as one unit
+const billingUnitChars = 1000
+
+// billableUnitsHeader is set on HTTP responses to the number of units billed by the Google API to serve the request
+const billableUnitsHeader = "X-Billable-Units"
+
+// billableUnits returns the number of units billed by the Google API for analyzing the content. Each started block of
+// 1,000 Unicode characters, whitespace included, counts as a unit.
+func billableUnits(content string) int {
+	return (utf8.RuneCountInString(content) + billingUnitChars - 1) / billingUnitChars
+}
+
+// billingCounter accumulates the units billed for the calls made on behalf of a request
+type billingCounter struct {
+	units int64
+}
+
+func (bc *billingCounter) add(units int) {
+	atomic.AddInt64(&bc.units, int64(units))
+}
+
+func (bc *billingCounter) String() string {
+	return strconv.FormatInt(atomic.LoadInt64(&bc.units), 10)
+}
+
+type billingCounterKey struct{}
+
+// withBillingCounter returns a context for which the units of successful calls to the Google API are added to the
+// counter. Results served from the cache or by the fallback analyzer are not counted, and a call shared with other
+// requests is only counted for the request that made it.
+func withBillingCounter(ctx context.Context, bc *billingCounter) context.Context {
+	return context.WithValue(ctx, billingCounterKey{}, bc)
+}
+
+// recordBilling adds the units billed for a call to the counter of the context, if any
+func recordBilling(ctx context.Context, units int) {
+	if bc, ok := ctx.Value(billingCounterKey{}).(*billingCounter); ok {
+		bc.add(units)
+	}
+}
@@ -0,0 +1,82 @@
+package sentiment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestBillableUnits(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		expected int
+	}{
+		{name: "empty", content: "", expected: 0},
+		{name: "one_char", content: "a", expected: 1},
+		{name: "999_chars", content: strings.Repeat("a", 999), expected: 1},
+		{name: "1000_chars", content: strings.Repeat("a", 1000), expected: 1},
+		{name: "1001_chars", content: strings.Repeat("a", 1001), expected: 2},
+		{name: "2000_chars", content: strings.Repeat("a", 2000), expected: 2},
+		{name: "2001_chars", content: strings.Repeat("a", 2001), expected: 3},
+		{name: "whitespace_counts", content: strings.Repeat(" ", 1001), expected: 2},
+		// characters are counted rather than bytes
+		{name: "multibyte_1000_chars", content: strings.Repeat("é", 1000), expected: 1},
+		{name: "multibyte_1001_chars", content: strings.Repeat("é", 1001), expected: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, billableUnits(tc.content))
+		})
+	}
+}
+
+func TestBillableUnitsHeader(t *testing.T) {
+	doRequest := func(svc *Service, target string, body string) *http.Response {
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+		return responseRecorder.Result()
+	}
+
+	t.Run("single", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		body := `{"content":"` + strings.Repeat("a", 1001) + `"}`
+		result := doRequest(svc, "/api", body)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "2", result.Header.Get(billableUnitsHeader))
+
+		// cached results are not billed
+		result = doRequest(svc, "/api", body)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "0", result.Header.Get(billableUnitsHeader))
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+		body := `{"documents":[{"content":"` + strings.Repeat("a", 1000) + `"},{"content":"` + strings.Repeat("b", 2001) + `"}]}`
+		result := doRequest(svc, "/batch", body)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "4", result.Header.Get(billableUnitsHeader))
+	})
+
+	t.Run("api_error", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, assert.AnError)
+
+		result := doRequest(svc, "/batch", `{"documents":[{"content":"Great."}]}`)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "0", result.Header.Get(billableUnitsHeader))
+	})
+}
@@ -0,0 +1,330 @@
+package sentiment
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// ErrCacheMiss is returned in cache-only mode for inputs that are not in the cache
+var ErrCacheMiss = errors.New("result not cached")
+
+// Cache stores the results of the remote API keyed by input. Get returns an error for missing entries. The default is
+// an in-memory BigCache, which is used as is, so its size, TTL and hasher options have no effect on other caches.
+type Cache interface {
+	Get(key string) ([]byte, error)
+	Set(key string, entry []byte) error
+	Delete(key string) error
+	Reset() error
+}
+
+// CacheValidator is implemented by caches that can check that they are usable, for example by pinging a remote
+// server. NewService validates caches implementing it so that misconfigurations are reported at startup.
+type CacheValidator interface {
+	Validate() error
+}
+
+// errCacheTimeout is returned by caches wrapped with a timeout when an operation takes too long
+var errCacheTimeout = errors.New("cache operation timed out")
+
+// timeoutCache bounds the time spent waiting for each operation of a cache, such as a remote cache that may hang. An
+// operation that times out keeps running in the background and its outcome is discarded.
+type timeoutCache struct {
+	cache   Cache
+	timeout time.Duration
+	logger  *zap.SugaredLogger
+}
+
+// run waits at most the timeout for the operation to complete
+func (c *timeoutCache) run(op string, fn func() ([]byte, error)) ([]byte, error) {
+	type result struct {
+		entry []byte
+		err   error
+	}
+
+	// buffered so that an operation completing after the timeout does not block forever
+	done := make(chan result, 1)
+	go func() {
+		entry, err := fn()
+		done <- result{entry: entry, err: err}
+	}()
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.entry, r.err
+	case <-timer.C:
+		c.logger.Warnw("Cache operation timed out", "operation", op, "timeout", c.timeout)
+		return nil, errCacheTimeout
+	}
+}
+
+func (c *timeoutCache) Get(key string) ([]byte, error) {
+	return c.run("get", func() ([]byte, error) { return c.cache.Get(key) })
+}
+
+func (c *timeoutCache) Set(key string, entry []byte) error {
+	_, err := c.run("set", func() ([]byte, error) { return nil, c.cache.Set(key, entry) })
+	return err
+}
+
+func (c *timeoutCache) Delete(key string) error {
+	_, err := c.run("delete", func() ([]byte, error) { return nil, c.cache.Delete(key) })
+	return err
+}
+
+func (c *timeoutCache) Reset() error {
+	_, err := c.run("reset", func() ([]byte, error) { return nil, c.cache.Reset() })
+	return err
+}
+
+type cacheBypassKey struct{}
+
+// WithCacheBypass returns a context for which the cache lookup is skipped so that a fresh result is obtained from the
+// remote API. The fresh result is still stored in the cache.
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+// cacheBypassed reports whether the context requests a fresh result
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
+}
+
+// requestsNoCache reports whether the HTTP request asks for a fresh result using either the no-cache directive of
+// the Cache-Control header or the no_cache query parameter
+func requestsNoCache(r *http.Request, params url.Values) bool {
+	if noCache, _ := strconv.ParseBool(params.Get("no_cache")); noCache {
+		return true
+	}
+
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cacheFixtureEntry is a single line of the input to ImportCache
+type cacheFixtureEntry struct {
+	Input    string          `json:"input"`
+	Tenant   string          `json:"tenant,omitempty"`
+	Response json.RawMessage `json:"response"`
+}
+
+// ImportCache loads results into the cache from a stream of newline-delimited JSON objects, each holding an input,
+// an optional tenant and the response of the remote API for the input in its JSON form:
+//
+//	{"input": "I love the product.", "response": {"sentences": [...]}}
+//
+// It returns the number of entries imported.
+func (svc *Service) ImportCache(r io.Reader) (int, error) {
+	params := svc.analysisParams()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 2*maxDocumentBytes)
+
+	count := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var entry cacheFixtureEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return count, fmt.Errorf("invalid cache entry on line %d: %+v", line, err)
+		}
+
+		var resp languagepb.AnalyzeSentimentResponse
+		if err := jsonpb.Unmarshal(bytes.NewReader(entry.Response), &resp); err != nil {
+			return count, fmt.Errorf("invalid response on line %d: %+v", line, err)
+		}
+
+		input, err := svc.normalizeInput(entry.Input)
+		if err != nil {
+			return count, fmt.Errorf("invalid input on line %d: %+v", line, err)
+		}
+		input = svc.conf.stopwords.remove(input, params.language)
+
+		cacheEntry, err := svc.encodeCacheEntry(&resp)
+		if err != nil {
+			return count, fmt.Errorf("failed to encode response on line %d: %+v", line, err)
+		}
+
+		ctx := context.Background()
+		if entry.Tenant != "" {
+			ctx = WithTenant(ctx, entry.Tenant)
+		}
+
+		if err := svc.cache.Set(cacheKey(ctx, input, params), cacheEntry); err != nil {
+			return count, fmt.Errorf("failed to cache entry on line %d: %+v", line, err)
+		}
+		count++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("failed to read cache entries: %+v", err)
+	}
+
+	return count, nil
+}
+
+// analysisParams holds the parameters of a call to the remote API, other than the content, that affect its response
+type analysisParams struct {
+	apiVersion   APIVersion
+	language     string
+	documentType languagepb.Document_Type
+	encodingType languagepb.EncodingType
+}
+
+// analysisParams returns the parameters used for calls to the remote API
+func (svc *Service) analysisParams() analysisParams {
+	return analysisParams{
+		apiVersion:   svc.conf.apiVersion,
+		language:     svc.conf.defaultLanguage,
+		documentType: languagepb.Document_PLAIN_TEXT,
+	}
+}
+
+// request builds a request to analyze the content with the parameters
+func (p analysisParams) request(content string) *languagepb.AnalyzeSentimentRequest {
+	return &languagepb.AnalyzeSentimentRequest{
+		Document: &languagepb.Document{
+			Source: &languagepb.Document_Content{
+				Content: content,
+			},
+			Type:     p.documentType,
+			Language: p.language,
+		},
+		EncodingType: p.encodingType,
+	}
+}
+
+// cacheKey derives the cache key of an input from its normalized content, the tenant of the request and every
+// parameter that affects the response of the remote API. Parameters that only affect how the response is presented,
+// such as the sort order and the limit, are deliberately excluded so that such requests share cache entries.
+func cacheKey(ctx context.Context, input string, params analysisParams) string {
+	// the separator cannot appear in a tenant ID supplied via a header
+	return fmt.Sprintf("%s\x00%d\x00%s\x00%d\x00%d\x00%s",
+		tenantFromContext(ctx),
+		params.apiVersion,
+		params.language,
+		params.documentType,
+		params.encodingType,
+		strings.ToLower(strings.TrimSpace(input)),
+	)
+}
+
+// Cache entries start with a header byte describing the encoding of the rest of the entry. Neither value can begin
+// a valid protobuf message, so entries written without a header are still decoded as plain protobuf.
+const (
+	cacheEntryRaw byte = iota
+	cacheEntryGzip
+)
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	gzipReaders sync.Pool
+	decodeBufs  = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+)
+
+// encodeCacheEntry marshals the response for storage in the cache, compressing it if configured to do so.
+//
+// Concurrent misses for the same input each encode and store their result. The redundant work is accepted rather
+// than checking the cache again before writing, as the check costs a copy of the entry on every miss while
+// duplicate misses are rare and can be eliminated with WithBatchWindow.
+func (svc *Service) encodeCacheEntry(resp *languagepb.AnalyzeSentimentResponse) ([]byte, error) {
+	if !svc.conf.cacheCompression {
+		// marshal directly after the header to avoid copying the encoded message
+		buf := proto.NewBuffer([]byte{cacheEntryRaw})
+		if err := buf.Marshal(resp); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	respBytes, err := proto.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(cacheEntryGzip)
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(&buf)
+	if _, err := zw.Write(respBytes); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeCacheEntry unmarshals a response stored in the cache
+func decodeCacheEntry(entry []byte) (*languagepb.AnalyzeSentimentResponse, error) {
+	respBytes := entry
+	if len(entry) > 0 {
+		switch entry[0] {
+		case cacheEntryRaw:
+			respBytes = entry[1:]
+		case cacheEntryGzip:
+			buf := decodeBufs.Get().(*bytes.Buffer)
+			defer decodeBufs.Put(buf)
+			buf.Reset()
+			if err := gunzip(buf, entry[1:]); err != nil {
+				return nil, err
+			}
+			respBytes = buf.Bytes()
+		}
+	}
+
+	// unmarshalling copies the data out of respBytes so the buffer can be reused afterwards
+	var result languagepb.AnalyzeSentimentResponse
+	if err := proto.Unmarshal(respBytes, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// gunzip decompresses the data into the buffer using a pooled reader
+func gunzip(buf *bytes.Buffer, data []byte) error {
+	var zr *gzip.Reader
+	var err error
+	if pooled := gzipReaders.Get(); pooled != nil {
+		zr = pooled.(*gzip.Reader)
+		err = zr.Reset(bytes.NewReader(data))
+	} else {
+		zr, err = gzip.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		return err
+	}
+	defer gzipReaders.Put(zr)
+
+	_, err = buf.ReadFrom(zr)
+	return err
+}
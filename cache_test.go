@@ -0,0 +1,656 @@
+package sentiment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allegro/bigcache"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestCacheCompression(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	svc.conf.cacheCompression = true
+
+	apiResponse := syntheticResponse(10000)
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil).Once()
+
+	result, _, err := svc.analyze(context.Background(), "large document")
+	assert.NoError(t, err)
+	assert.Equal(t, apiResponse, result)
+
+	key := cacheKey(context.Background(), "large document", svc.analysisParams())
+	entry, err := svc.cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, cacheEntryGzip, entry[0])
+
+	rawBytes, err := proto.Marshal(apiResponse)
+	assert.NoError(t, err)
+	assert.True(t, len(entry) < len(rawBytes), "compressed entry of %d bytes is not smaller than %d bytes", len(entry), len(rawBytes))
+
+	// the second call must be served from the compressed cache entry
+	cachedResult, _, err := svc.analyze(context.Background(), "large document")
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(apiResponse, cachedResult))
+	mockClient.AssertExpectations(t)
+}
+
+func TestCorruptedCacheEntry(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	mockClient, svc := createMocks(t)
+	svc.logger = zap.New(core).Sugar()
+
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+		},
+	}
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil).Once()
+
+	key := cacheKey(context.Background(), "I love the product.", svc.analysisParams())
+	assert.NoError(t, svc.cache.Set(key, []byte{cacheEntryGzip, 0xde, 0xad}))
+
+	assert.Nil(t, svc.getCachedResult(key))
+	_, err := svc.cache.Get(key)
+	assert.Error(t, err, "corrupted entry was not removed")
+	assert.Equal(t, 1, logs.FilterMessage("Removing corrupted cache entry").Len())
+
+	// the next request repopulates the entry from the remote API
+	result, _, err := svc.analyze(context.Background(), "I love the product.")
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(apiResponse, result))
+	assert.True(t, proto.Equal(apiResponse, svc.getCachedResult(key)))
+	mockClient.AssertExpectations(t)
+}
+
+// constantHasher maps every key to the same hash to force collisions
+type constantHasher struct{}
+
+func (constantHasher) Sum64(string) uint64 { return 42 }
+
+// mapCache is a Cache backed by a map whose validation returns err
+type mapCache struct {
+	entries map[string][]byte
+	err     error
+}
+
+func (c *mapCache) Get(key string) ([]byte, error) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return entry, nil
+}
+
+func (c *mapCache) Set(key string, entry []byte) error {
+	c.entries[key] = entry
+	return nil
+}
+
+func (c *mapCache) Delete(key string) error {
+	delete(c.entries, key)
+	return nil
+}
+
+func (c *mapCache) Reset() error {
+	c.entries = make(map[string][]byte)
+	return nil
+}
+
+func (c *mapCache) Validate() error {
+	return c.err
+}
+
+func TestCustomCache(t *testing.T) {
+	t.Run("validation_failure", func(t *testing.T) {
+		cache := &mapCache{entries: make(map[string][]byte), err: errors.New("dial tcp 10.0.0.1:6379: connection refused")}
+		svc, err := NewService(WithCache(cache))
+		assert.Nil(t, svc)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "cache validation failed")
+			assert.Contains(t, err.Error(), "connection refused")
+		}
+	})
+
+	t.Run("stores_results", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		cache := &mapCache{entries: make(map[string][]byte)}
+		svc.cache = cache
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		for i := 0; i < 2; i++ {
+			_, err := svc.Analyze(context.Background(), "Great.")
+			assert.NoError(t, err)
+		}
+		mockClient.AssertExpectations(t)
+		assert.Len(t, cache.entries, 1)
+	})
+}
+
+// hangingCache is a Cache whose operations block until it is released
+type hangingCache struct {
+	release chan struct{}
+}
+
+func (c *hangingCache) Get(key string) ([]byte, error) {
+	<-c.release
+	return nil, errors.New("not found")
+}
+
+func (c *hangingCache) Set(key string, entry []byte) error {
+	<-c.release
+	return nil
+}
+
+func (c *hangingCache) Delete(key string) error {
+	<-c.release
+	return nil
+}
+
+func (c *hangingCache) Reset() error {
+	<-c.release
+	return nil
+}
+
+func TestCacheOpTimeout(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	mockClient, svc := createMocks(t)
+	svc.logger = zap.New(core).Sugar()
+	cache := &hangingCache{release: make(chan struct{})}
+	defer close(cache.release)
+	svc.cache = &timeoutCache{cache: cache, timeout: 20 * time.Millisecond, logger: svc.logger}
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+		},
+	}, nil).Once()
+
+	start := time.Now()
+	resp, err := svc.ProcessSentiment(context.Background(), "Great.", Descending, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, Response{{"Great.": 0.5}}, resp)
+	// the lookup and the store each wait for the timeout at most
+	assert.True(t, time.Since(start) < svc.conf.requestTimeout)
+	mockClient.AssertExpectations(t)
+
+	assert.Equal(t, 2, logs.FilterMessage("Cache operation timed out").Len())
+	assert.Equal(t, 1, logs.FilterMessage("Failed to cache result").Len())
+}
+
+func TestCacheAfterCancellation(t *testing.T) {
+	mockClient, svc := createMocks(t)
+
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+		},
+	}
+
+	// the request is cancelled as soon as the API call returns
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil).Once().Run(func(args mock.Arguments) {
+		cancelFunc()
+	})
+
+	_, err := svc.ProcessSentiment(ctx, "I love the product.", Descending, -1)
+	assert.Equal(t, context.Canceled, err)
+
+	key := cacheKey(context.Background(), "I love the product.", svc.analysisParams())
+	assert.True(t, proto.Equal(apiResponse, svc.getCachedResult(key)))
+
+	// a retry is served from the cache without calling the API again
+	resp, err := svc.ProcessSentiment(context.Background(), "I love the product.", Descending, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, Response([]map[string]float32{{"I love the product.": 0.9}}), resp)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCacheEntryTooLarge(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	mockClient, svc := createMocks(t)
+	svc.logger = zap.New(core).Sugar()
+
+	// each of the shards of a 1MB cache holds at most 1KB, once the small initial allocation is used up
+	cacheConf := bigcache.DefaultConfig(1 * time.Minute)
+	cacheConf.HardMaxCacheSize = 1
+	cacheConf.MaxEntriesInWindow = 10
+	cacheConf.MaxEntrySize = 16
+	cache, err := bigcache.NewBigCache(cacheConf)
+	assert.NoError(t, err)
+	svc.cache = cache
+
+	input := strings.Repeat("a long document. ", 200)
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(syntheticResponse(200), nil)
+
+	_, err = svc.ProcessSentiment(context.Background(), input, Descending, -1)
+	assert.NoError(t, err)
+	assert.Nil(t, svc.getCachedResult(cacheKey(context.Background(), input, svc.analysisParams())))
+
+	rejected := logs.FilterMessage("Failed to cache result").All()
+	assert.Len(t, rejected, 1)
+	assert.Equal(t, int64(len(input)), rejected[0].ContextMap()["input_length"])
+	assert.NotNil(t, rejected[0].ContextMap()["entry_size"])
+}
+
+func TestCacheHashCollision(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	cacheConf := bigcache.DefaultConfig(10 * time.Minute)
+	cacheConf.Hasher = constantHasher{}
+	cache, err := bigcache.NewBigCache(cacheConf)
+	assert.NoError(t, err)
+	svc.cache = cache
+
+	positive := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+		},
+	}
+	negative := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I hate the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: -0.9},
+			},
+		},
+	}
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+		return req.GetDocument().GetContent() == "I love the product."
+	}), mock.Anything).Return(positive, nil).Once()
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+		return req.GetDocument().GetContent() == "I hate the product."
+	}), mock.Anything).Return(negative, nil).Once()
+
+	result, _, err := svc.analyze(context.Background(), "I love the product.")
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(positive, result))
+
+	// the colliding key must be treated as a miss rather than returning the cached positive result
+	result, _, err = svc.analyze(context.Background(), "I hate the product.")
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(negative, result))
+	mockClient.AssertExpectations(t)
+}
+
+func TestDecodeCacheEntry(t *testing.T) {
+	resp := &languagepb.AnalyzeSentimentResponse{
+		Language: "en",
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+		},
+	}
+
+	rawBytes, err := proto.Marshal(resp)
+	assert.NoError(t, err)
+
+	_, svc := createMocks(t)
+	uncompressed, err := svc.encodeCacheEntry(resp)
+	assert.NoError(t, err)
+	assert.Equal(t, cacheEntryRaw, uncompressed[0])
+
+	svc.conf.cacheCompression = true
+	compressed, err := svc.encodeCacheEntry(resp)
+	assert.NoError(t, err)
+	assert.Equal(t, cacheEntryGzip, compressed[0])
+
+	emptyEntry, err := svc.encodeCacheEntry(&languagepb.AnalyzeSentimentResponse{})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		entry    []byte
+		expected *languagepb.AnalyzeSentimentResponse
+	}{
+		{name: "uncompressed", entry: uncompressed, expected: resp},
+		{name: "compressed", entry: compressed, expected: resp},
+		{name: "without_header", entry: rawBytes, expected: resp},
+		{name: "empty_response", entry: emptyEntry, expected: &languagepb.AnalyzeSentimentResponse{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := decodeCacheEntry(tc.entry)
+			assert.NoError(t, err)
+			assert.True(t, proto.Equal(tc.expected, result))
+		})
+	}
+
+	t.Run("corrupt_compressed", func(t *testing.T) {
+		_, err := decodeCacheEntry([]byte{cacheEntryGzip, 0x01, 0x02})
+		assert.Error(t, err)
+	})
+}
+
+func TestCacheKey(t *testing.T) {
+	ctx := context.Background()
+	base := analysisParams{apiVersion: APIVersionV1, documentType: languagepb.Document_PLAIN_TEXT}
+	baseKey := cacheKey(ctx, "I love the product.", base)
+
+	t.Run("response_affecting_params", func(t *testing.T) {
+		variations := map[string]func(p *analysisParams){
+			"api_version":   func(p *analysisParams) { p.apiVersion = APIVersionV1Beta2 },
+			"language":      func(p *analysisParams) { p.language = "en" },
+			"document_type": func(p *analysisParams) { p.documentType = languagepb.Document_HTML },
+			"encoding_type": func(p *analysisParams) { p.encodingType = languagepb.EncodingType_UTF8 },
+		}
+
+		keys := map[string]string{baseKey: "base"}
+		for name, vary := range variations {
+			params := base
+			vary(&params)
+			key := cacheKey(ctx, "I love the product.", params)
+			assert.NotContains(t, keys, key, "%s produces the same key as %s", name, keys[key])
+			keys[key] = name
+		}
+
+		assert.NotEqual(t, baseKey, cacheKey(WithTenant(ctx, "tenantA"), "I love the product.", base))
+		assert.NotEqual(t, baseKey, cacheKey(ctx, "I hate the product.", base))
+	})
+
+	t.Run("normalized_input", func(t *testing.T) {
+		assert.Equal(t, baseKey, cacheKey(ctx, "  i LOVE the product. ", base))
+	})
+
+	t.Run("presentation_params", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+			Sentences: []*languagepb.Sentence{
+				&languagepb.Sentence{
+					Text:      &languagepb.TextSpan{Content: "I love the product."},
+					Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+				},
+			},
+		}, nil).Once()
+
+		for _, target := range []string{"/api", "/api?order=desc", "/api?limit=1", "/api?order=document&offset=1", "/api?v=2", "/api?group=polarity"} {
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"content":"I love the product."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode, target)
+		}
+
+		// every request after the first must be served from the same cache entry
+		mockClient.AssertExpectations(t)
+	})
+}
+
+func TestImportCache(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	svc.conf.cacheOnly = true
+
+	fixture, err := os.Open("testdata/cache_fixture.jsonl")
+	assert.NoError(t, err)
+	defer fixture.Close()
+
+	count, err := svc.ImportCache(fixture)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	t.Run("cached", func(t *testing.T) {
+		resp, err := svc.ProcessSentiment(context.Background(), "I hate this site. But I love the product.", Descending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response([]map[string]float32{
+			map[string]float32{"But I love the product.": 0.9},
+			map[string]float32{"I hate this site.": -0.8},
+		}), resp)
+
+		resp, err = svc.ProcessSentiment(WithTenant(context.Background(), "tenantA"), "It is blue.", Descending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response([]map[string]float32{map[string]float32{"It is blue.": 0}}), resp)
+
+		resp, err = svc.ProcessSentiment(context.Background(), "?!", Descending, -1)
+		assert.NoError(t, err)
+		assert.Len(t, resp, 0)
+	})
+
+	t.Run("not_cached", func(t *testing.T) {
+		_, err := svc.ProcessSentiment(context.Background(), "It is blue.", Descending, -1)
+		assert.Equal(t, ErrCacheMiss, err)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"Not in the fixture."}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		assert.Equal(t, http.StatusNotFound, responseRecorder.Result().StatusCode)
+	})
+
+	t.Run("invalid_fixture", func(t *testing.T) {
+		_, svc := createMocks(t)
+		count, err := svc.ImportCache(strings.NewReader(`{"input": "a", "response": {}}` + "\n" + `{"input": "b", "response": {"sentences": 42}}`))
+		assert.Error(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("normalized_like_requests", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.cacheOnly = true
+		svc.conf.stripHTML = true
+		svc.conf.preprocessor = strings.ToUpper
+
+		count, err := svc.ImportCache(strings.NewReader(`{"input": "<p>It is <b>blue</b>.</p>", "response": {"sentences": [{"text": {"content": "IT IS BLUE."}, "sentiment": {}}]}}`))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		resp, err := svc.ProcessSentiment(context.Background(), "<div>It is blue.</div>", Descending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response([]map[string]float32{map[string]float32{"IT IS BLUE.": 0}}), resp)
+		mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// BenchmarkProcessSentimentCacheOnly measures the throughput of the service when every result is served from a
+// preloaded cache, isolating the cost of the reduction from the remote API
+func BenchmarkProcessSentimentCacheOnly(b *testing.B) {
+	for _, numSentences := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("sentences_%d", numSentences), func(b *testing.B) {
+			mockClient := &mockLanguageClient{}
+			conf := &config{logger: zap.NewNop(), cacheOnly: true}
+			cache, err := bigcache.NewBigCache(bigcache.DefaultConfig(10 * time.Minute))
+			if err != nil {
+				b.Fatal(err)
+			}
+			svc := &Service{conf: conf, client: mockClient, cache: cache, logger: conf.logger.Sugar()}
+
+			inputs := make([]string, 100)
+			var fixture bytes.Buffer
+			marshaler := &jsonpb.Marshaler{}
+			for i := range inputs {
+				inputs[i] = fmt.Sprintf("document %d", i)
+				resp, err := marshaler.MarshalToString(syntheticResponse(numSentences))
+				if err != nil {
+					b.Fatal(err)
+				}
+				fmt.Fprintf(&fixture, `{"input": %q, "response": %s}`+"\n", inputs[i], resp)
+			}
+
+			if _, err := svc.ImportCache(&fixture); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					if _, err := svc.ProcessSentiment(context.Background(), inputs[i%len(inputs)], Descending, 10); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+			b.StopTimer()
+
+			mockClient.AssertNotCalled(b, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func BenchmarkCacheEntry(b *testing.B) {
+	for _, compressed := range []bool{false, true} {
+		for _, numSentences := range []int{10, 1000} {
+			resp := syntheticResponse(numSentences)
+			svc := &Service{conf: &config{cacheCompression: compressed}}
+			entry, err := svc.encodeCacheEntry(resp)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			name := fmt.Sprintf("compressed_%t/sentences_%d", compressed, numSentences)
+			b.Run("encode/"+name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := svc.encodeCacheEntry(resp); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+
+			b.Run("decode/"+name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := decodeCacheEntry(entry); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestZeroSentenceResultCached(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+	for i := 0; i < 2; i++ {
+		resp, err := svc.ProcessSentiment(context.Background(), "?!...", Descending, -1)
+		assert.NoError(t, err)
+		assert.Empty(t, resp)
+	}
+
+	// the second request is served from the cache but still counted
+	mockClient.AssertExpectations(t)
+	assert.EqualValues(t, 2, svc.ZeroSentenceResults())
+
+	responseRecorder := httptest.NewRecorder()
+	svc.RESTHandler().ServeHTTP(responseRecorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.JSONEq(t, `{"status":"ok","error_rate":0,"zero_sentence_results":2}`, responseRecorder.Body.String())
+}
+
+func TestCacheBypass(t *testing.T) {
+	stale := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.1, Score: 0.1},
+			},
+		},
+	}
+	fresh := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name    string
+		request func(svc *Service) error
+	}{
+		{
+			name: "context",
+			request: func(svc *Service) error {
+				_, err := svc.ProcessSentiment(WithCacheBypass(context.Background()), "I love the product.", Descending, -1)
+				return err
+			},
+		},
+		{
+			name: "header",
+			request: func(svc *Service) error {
+				responseRecorder := httptest.NewRecorder()
+				request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"I love the product."}`))
+				request.Header.Set("Cache-Control", "max-age=0, no-cache")
+				svc.handleHTTPRequest(responseRecorder, request)
+				assert.JSONEq(t, `[{"I love the product.":0.9}]`, responseRecorder.Body.String())
+				return nil
+			},
+		},
+		{
+			name: "query",
+			request: func(svc *Service) error {
+				responseRecorder := httptest.NewRecorder()
+				request := httptest.NewRequest(http.MethodPost, "/api?no_cache=true", strings.NewReader(`{"content":"I love the product."}`))
+				svc.handleHTTPRequest(responseRecorder, request)
+				assert.JSONEq(t, `[{"I love the product.":0.9}]`, responseRecorder.Body.String())
+				return nil
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(fresh, nil).Once()
+
+			key := cacheKey(context.Background(), "I love the product.", svc.analysisParams())
+			entry, err := svc.encodeCacheEntry(stale)
+			assert.NoError(t, err)
+			assert.NoError(t, svc.cache.Set(key, entry))
+
+			assert.NoError(t, tc.request(svc))
+			mockClient.AssertExpectations(t)
+
+			// the fresh result replaces the cached one
+			assert.True(t, proto.Equal(fresh, svc.getCachedResult(key)))
+		})
+	}
+
+	t.Run("not_bypassed", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+
+		key := cacheKey(context.Background(), "I love the product.", svc.analysisParams())
+		entry, err := svc.encodeCacheEntry(stale)
+		assert.NoError(t, err)
+		assert.NoError(t, svc.cache.Set(key, entry))
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?no_cache=false", strings.NewReader(`{"content":"I love the product."}`))
+		request.Header.Set("Cache-Control", "max-age=0")
+		svc.handleHTTPRequest(responseRecorder, request)
+
+		assert.JSONEq(t, `[{"I love the product.":0.1}]`, responseRecorder.Body.String())
+		mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,109 @@
+package sentiment
+
+import (
+	"context"
+	"unicode"
+	"unicode/utf8"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// maxDocumentBytes is the maximum size of a document accepted by the Google API
+const maxDocumentBytes = 1000000
+
+// chunk is a contiguous part of a document
+type chunk struct {
+	text   string
+	offset int
+}
+
+// callAPIChunked analyzes each chunk of the input separately and merges the sentences into a single response
+func (svc *Service) callAPIChunked(ctx context.Context, input string, params analysisParams) (*languagepb.AnalyzeSentimentResponse, error) {
+	chunks := chunkText(input, svc.conf.maxChunkBytes)
+	merged := &languagepb.AnalyzeSentimentResponse{}
+
+	for _, c := range chunks {
+		resp, err := svc.callAPI(ctx, params.request(c.text))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if merged.Language == "" {
+			merged.Language = resp.Language
+		}
+
+		for _, sentence := range resp.Sentences {
+			// offsets are relative to the chunk unless the API did not compute them
+			if sentence.Text != nil && sentence.Text.BeginOffset >= 0 {
+				sentence.Text.BeginOffset += int32(c.offset)
+			}
+			merged.Sentences = append(merged.Sentences, sentence)
+		}
+	}
+
+	svc.logger.Debugw("Analyzed chunked document", "chunks", len(chunks), "sentences", len(merged.Sentences))
+	return merged, nil
+}
+
+// chunkText splits the text into contiguous chunks of at most maxBytes bytes. Chunks end at paragraph or sentence
+// boundaries where possible and sentences longer than maxBytes are split at rune boundaries. A rune is never split,
+// so a maxBytes smaller than utf8.UTFMax can yield chunks of a single rune exceeding it.
+func chunkText(text string, maxBytes int) []chunk {
+	var chunks []chunk
+	start := 0
+	end := 0
+	for _, segmentEnd := range segmentBoundaries(text) {
+		if segmentEnd-start <= maxBytes {
+			end = segmentEnd
+			continue
+		}
+
+		if end > start {
+			chunks = append(chunks, chunk{text: text[start:end], offset: start})
+			start = end
+		}
+
+		// a single segment that does not fit must be split arbitrarily
+		for segmentEnd-start > maxBytes {
+			split := start + maxBytes
+			for split > start && !utf8.RuneStart(text[split]) {
+				split--
+			}
+			// a rune larger than maxBytes gets a chunk of its own rather than never being consumed
+			if split == start {
+				_, size := utf8.DecodeRuneInString(text[start:])
+				split = start + size
+			}
+			chunks = append(chunks, chunk{text: text[start:split], offset: start})
+			start = split
+		}
+		end = segmentEnd
+	}
+
+	if end > start {
+		chunks = append(chunks, chunk{text: text[start:end], offset: start})
+	}
+
+	return chunks
+}
+
+// segmentBoundaries returns the end offsets of the paragraphs and sentences of the text. Trailing whitespace is
+// included in the preceding segment.
+func segmentBoundaries(text string) []int {
+	var boundaries []int
+	atBoundary := false
+	for i, r := range text {
+		if atBoundary && !unicode.IsSpace(r) {
+			boundaries = append(boundaries, i)
+			atBoundary = false
+		}
+
+		switch r {
+		case '.', '!', '?', '\n':
+			atBoundary = true
+		}
+	}
+
+	return append(boundaries, len(text))
+}
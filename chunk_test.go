@@ -0,0 +1,141 @@
+package sentiment
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestChunkText(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		maxBytes int
+		expected []chunk
+	}{
+		{
+			name:     "fits",
+			text:     "One. Two.",
+			maxBytes: 20,
+			expected: []chunk{{text: "One. Two.", offset: 0}},
+		},
+		{
+			name:     "sentence_boundaries",
+			text:     "One. Two! Three? Four.",
+			maxBytes: 10,
+			expected: []chunk{{text: "One. Two! ", offset: 0}, {text: "Three? ", offset: 10}, {text: "Four.", offset: 17}},
+		},
+		{
+			name:     "paragraph_boundaries",
+			text:     "First para\n\nSecond para",
+			maxBytes: 15,
+			expected: []chunk{{text: "First para\n\n", offset: 0}, {text: "Second para", offset: 12}},
+		},
+		{
+			name:     "long_sentence_split_on_rune_boundary",
+			text:     "ab😀cd. Ok.",
+			maxBytes: 4,
+			expected: []chunk{{text: "ab", offset: 0}, {text: "😀", offset: 2}, {text: "cd. ", offset: 6}, {text: "Ok.", offset: 10}},
+		},
+		{
+			name:     "rune_larger_than_limit",
+			text:     "a😀é",
+			maxBytes: 1,
+			expected: []chunk{{text: "a", offset: 0}, {text: "😀", offset: 1}, {text: "é", offset: 5}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chunks := chunkText(tc.text, tc.maxBytes)
+			assert.Equal(t, tc.expected, chunks)
+
+			var rebuilt []string
+			for _, c := range chunks {
+				assert.True(t, len(c.text) <= tc.maxBytes || utf8.RuneCountInString(c.text) == 1)
+				rebuilt = append(rebuilt, c.text)
+			}
+			assert.Equal(t, tc.text, strings.Join(rebuilt, ""))
+		})
+	}
+}
+
+func TestWithAutoChunk(t *testing.T) {
+	testCases := []struct {
+		name     string
+		maxBytes int
+		expected int
+	}{
+		{name: "default", maxBytes: 0, expected: maxDocumentBytes},
+		{name: "smaller_than_a_rune", maxBytes: 1, expected: utf8.UTFMax},
+		{name: "custom", maxBytes: 100, expected: 100},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &config{}
+			WithAutoChunk(tc.maxBytes)(c)
+			assert.True(t, c.autoChunk)
+			assert.Equal(t, tc.expected, c.maxChunkBytes)
+		})
+	}
+}
+
+func TestAutoChunk(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	svc.conf.autoChunk = true
+	svc.conf.maxChunkBytes = 24
+
+	chunkRequest := func(content string) *languagepb.AnalyzeSentimentRequest {
+		return &languagepb.AnalyzeSentimentRequest{
+			Document: &languagepb.Document{
+				Source: &languagepb.Document_Content{Content: content},
+				Type:   languagepb.Document_PLAIN_TEXT,
+			},
+		}
+	}
+
+	mockClient.On("AnalyzeSentiment", mock.Anything, chunkRequest("I hate this site. "), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+		DocumentSentiment: &languagepb.Sentiment{Magnitude: 0.8, Score: -0.8},
+		Language:          "en",
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I hate this site.", BeginOffset: 0},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.8, Score: -0.8},
+			},
+		},
+	}, nil).Once()
+
+	mockClient.On("AnalyzeSentiment", mock.Anything, chunkRequest("But I love the product."), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+		DocumentSentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+		Language:          "en",
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "But I love the product.", BeginOffset: 0},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+		},
+	}, nil).Once()
+
+	result, err := svc.Analyze(context.Background(), "I hate this site. But I love the product.")
+	assert.NoError(t, err)
+	assert.Nil(t, result.DocumentSentiment)
+	assert.Equal(t, "en", result.Language)
+	assert.Len(t, result.Sentences, 2)
+	assert.Equal(t, int32(0), result.Sentences[0].Text.BeginOffset)
+	assert.Equal(t, int32(18), result.Sentences[1].Text.BeginOffset)
+
+	resp, err := svc.ProcessSentiment(context.Background(), "I hate this site. But I love the product.", Descending, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, Response([]map[string]float32{
+		map[string]float32{"But I love the product.": 0.9},
+		map[string]float32{"I hate this site.": -0.8},
+	}), resp)
+
+	mockClient.AssertExpectations(t)
+}
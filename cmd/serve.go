@@ -2,38 +2,243 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charithe/sentiment"
 	isatty "github.com/mattn/go-isatty"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/netutil"
 )
 
 const httpTimeout = 10 * time.Second
 
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
 var (
+	accessLog      = flag.String("access_log", "", "Access log format [structured|combined]. Disabled if empty")
+	adminToken     = flag.String("admin_token", "", "Bearer token required by administrative endpoints. Administrative endpoints are disabled if empty")
+	apiVersion     = flag.String("api_version", "v1", "Google language API version [v1|v1beta2]")
+	autoChunk      = flag.Bool("auto_chunk", false, "Split documents exceeding the API size limit into multiple requests")
+	batchConc      = flag.Int("batch_concurrency", 4, "Maximum number of documents of a batch processed in parallel")
+	batchFailFast  = flag.Bool("batch_fail_fast", false, "Stop processing a batch after an error affecting every document, such as rejected credentials")
+	batchWindow    = flag.Duration("batch_window", 0, "Window within which identical requests share a single Google API call. Disabled if zero")
+	cacheCompress  = flag.Bool("cache_compression", false, "Compress cache entries to fit more results in the cache")
 	cacheEntryTTL  = flag.Duration("cache_entry_ttl", 10*time.Minute, "TTL of cache entries")
+	cacheImport    = flag.String("cache_import", "", "File of newline-delimited JSON results to load into the cache at startup")
 	cacheMaxSizeMB = flag.Int("cache_max_size_mb", 64, "Maximum size of the cache")
+	cacheOnly      = flag.Bool("cache_only", false, "Serve results exclusively from the cache without calling the Google API")
+	debugMode      = flag.Bool("debug_mode", false, "Allow clients to request the raw API response with the debug parameter")
+	defaultLang    = flag.String("default_language", "", "BCP 47 tag of the language of the inputs, such as en or pt-BR. Detected by the Google API if empty")
+	emptyNoContent = flag.Bool("empty_no_content", false, "Respond with 204 No Content when no sentences are found in the input")
+	fetchURLs      = flag.Bool("fetch_urls", false, "Allow clients to submit a URL to analyze instead of the content")
+	handlerTimeout = flag.Duration("handler_timeout", 0, "Overall timeout for HTTP requests. Disabled if zero")
+	idempotency    = flag.Duration("idempotency_window", 0, "Replay the response to a request repeating the Idempotency-Key header of a successful request within this window. Disabled if zero")
+	invalidUTF8    = flag.String("invalid_utf8", "reject", "Handling of inputs that are not valid UTF-8 [reject|sanitize]")
+	invertScores   = flag.Bool("invert_scores", false, "Reverse the sign of the scores so that negative sentiment has positive scores")
 	listenAddr     = flag.String("listen", ":8080", "Listen address")
 	logLevel       = flag.String("log_level", "INFO", "Log level")
-	requestTimeout = flag.Duration("timeout", 1*time.Second, "Timeout for requests")
+	maxBatchSize   = flag.Int("max_batch_size", 0, "Maximum number of documents in a batch request. Unlimited if zero")
+	maxConcurrent  = flag.Int("max_concurrent_requests", 0, "Maximum number of concurrent Google API calls across all requests. Unlimited if zero")
+	maxConnections = flag.Int("max_connections", 0, "Maximum number of concurrent HTTP connections. Further connections wait until one is closed. Unlimited if zero")
+	maxQueryLength = flag.Int("max_query_length", 0, "Maximum length of the query string of analysis requests. Unlimited if zero")
+	maxBodyBytes   = flag.Int64("max_request_bytes", 10<<20, "Maximum size of the JSON body of HTTP requests")
+	methodOverride = flag.String("method_override", "", "Comma separated list of methods POST requests may override with the X-HTTP-Method-Override header. Disabled if empty")
+	minTokens      = flag.Int("min_tokens", 0, "Reject inputs with fewer whitespace delimited tokens than this. Disabled if zero")
+	putAsPost      = flag.Bool("put_as_post", false, "Accept PUT as an alias for POST on the API endpoint")
+	quotaLimit     = flag.Int("quota", 0, "Maximum number of successful Google API calls per client within the quota window. Disabled if zero")
+	quotaKeyHeader = flag.String("quota_key_header", "", "Header set by a trusted proxy identifying the client for the quota. The client address is used if empty")
+	quotaProject   = flag.String("quota_project", "", "Google Cloud project to bill for API usage")
+	quotaWindow    = flag.Duration("quota_window", 24*time.Hour, "Window of the per client quota")
+	rawUTF8        = flag.Bool("raw_utf8_output", false, "Write <, > and & unescaped in JSON responses")
+	recentSize     = flag.Int("recent_buffer_size", 0, "Number of recent analyses served by the /recent endpoint. Disabled if zero")
+	rmStopwords    = flag.Bool("remove_stopwords", false, "Remove common English stopwords, or those of -default_language if supported, from inputs before analyzing them")
+	requestIDs     = flag.Bool("request_ids", false, "Propagate the X-Request-ID header, or a generated ID, to the Google API")
+	requestTimeout = flag.Duration("timeout", 1*time.Second, "Timeout for each Google API call")
+	respCacheMB    = flag.Int("response_cache_mb", 0, "Maximum size of the cache of serialized responses to repeated identical requests. Disabled if zero")
+	respCompress   = flag.Bool("response_compression", false, "Gzip responses larger than 1KiB for clients that accept it")
+	respVersion    = flag.Int("response_version", 1, "Response version returned when clients do not specify one [1|2]")
+	retries        = flag.Int("retries", 0, "Number of times a Google API call failing with a transient error is retried within the timeout")
+	selfTest       = flag.Bool("self_test", false, "Enable the /selftest endpoint that analyzes a sample through the Google API")
+	skipIncomplete = flag.Bool("skip_incomplete", false, "Omit sentences returned without a sentiment instead of failing the request")
+	slowThreshold  = flag.Duration("slow_request_threshold", 0, "Log a warning for analyses taking longer than this. Disabled if zero")
+	statusBody     = flag.Bool("status_body", false, "Respond to /status with a JSON body instead of an empty one")
+	strictParams   = flag.Bool("strict_params", false, "Reject requests with repeated order or limit query parameters instead of using the first value")
+	strictStatus   = flag.Bool("strict_status_methods", false, "Reject methods other than GET and HEAD on /status")
+	stripHTML      = flag.Bool("strip_html", false, "Treat inputs as HTML and analyze only their text content")
+	tlsCert        = flag.String("tls_cert", "", "TLS certificate file. Plain HTTP is served unless both the certificate and the key are set")
+	tlsKey         = flag.String("tls_key", "", "TLS private key file")
+	tlsMinVersion  = flag.String("tls_min_version", "1.2", "Minimum TLS version [1.0|1.1|1.2]")
+	urlAllowlist   = flag.String("url_allowlist", "", "Comma separated list of hosts that URLs may point to. Any host with a public address if empty")
+	writeTimeout   = flag.Duration("write_timeout", httpTimeout, "Timeout for writing HTTP/1 responses, except the /batch/sse stream. Disabled if zero")
 )
 
 func main() {
 	flag.Parse()
 	initLogging()
 
-	sentimentSvc, err := sentiment.NewService(
+	opts := []sentiment.Option{
 		sentiment.WithCacheEntryTTL(*cacheEntryTTL),
 		sentiment.WithCacheMaxSizeMB(*cacheMaxSizeMB),
+		sentiment.WithResponseCache(*respCacheMB),
 		sentiment.WithRequestTimeout(*requestTimeout),
-	)
+		sentiment.WithRetries(*retries),
+		sentiment.WithSlowRequestThreshold(*slowThreshold),
+		sentiment.WithHandlerTimeout(*handlerTimeout),
+		sentiment.WithIdempotencyWindow(*idempotency),
+		sentiment.WithBatchConcurrency(*batchConc),
+		sentiment.WithMaxBatchSize(*maxBatchSize),
+		sentiment.WithBatchWindow(*batchWindow),
+		sentiment.WithMaxConcurrentRequests(*maxConcurrent),
+		sentiment.WithMinTokens(*minTokens),
+		sentiment.WithMaxQueryLength(*maxQueryLength),
+		sentiment.WithMaxRequestBodyBytes(*maxBodyBytes),
+		sentiment.WithPerKeyQuota(*quotaLimit, *quotaWindow),
+		sentiment.WithQuotaKeyHeader(*quotaKeyHeader),
+		sentiment.WithRecentBufferSize(*recentSize),
+		sentiment.WithAdminToken(*adminToken),
+		sentiment.WithDefaultLanguage(*defaultLang),
+		sentiment.WithLogger(zap.L()),
+	}
+
+	if *cacheCompress {
+		opts = append(opts, sentiment.WithCacheCompression())
+	}
+
+	if *cacheOnly {
+		opts = append(opts, sentiment.WithCacheOnly())
+	}
+
+	if *autoChunk {
+		opts = append(opts, sentiment.WithAutoChunk(0))
+	}
+
+	if *batchFailFast {
+		opts = append(opts, sentiment.WithBatchFailFast())
+	}
+
+	if *quotaProject != "" {
+		opts = append(opts, sentiment.WithQuotaProject(*quotaProject))
+	}
+
+	if *debugMode {
+		opts = append(opts, sentiment.WithDebugMode())
+	}
+
+	if *emptyNoContent {
+		opts = append(opts, sentiment.WithEmptyResultStatus(http.StatusNoContent))
+	}
+
+	if *fetchURLs {
+		var hosts []string
+		if *urlAllowlist != "" {
+			hosts = strings.Split(*urlAllowlist, ",")
+		}
+		opts = append(opts, sentiment.WithURLFetching(hosts...))
+	}
+
+	if *invertScores {
+		opts = append(opts, sentiment.WithInvertScores())
+	}
+
+	if *methodOverride != "" {
+		opts = append(opts, sentiment.WithMethodOverride(strings.Split(*methodOverride, ",")...))
+	}
+
+	if *putAsPost {
+		opts = append(opts, sentiment.WithPutAsPost())
+	}
+
+	if *respCompress {
+		opts = append(opts, sentiment.WithResponseCompression())
+	}
+
+	if *rawUTF8 {
+		opts = append(opts, sentiment.WithRawUTF8Output())
+	}
+
+	if *selfTest {
+		opts = append(opts, sentiment.WithSelfTest())
+	}
+
+	if *skipIncomplete {
+		opts = append(opts, sentiment.WithSkipIncompleteSentences())
+	}
+
+	if *requestIDs {
+		opts = append(opts, sentiment.WithRequestIDPropagation())
+	}
+
+	if *rmStopwords {
+		opts = append(opts, sentiment.WithStopwordRemoval(nil))
+	}
+
+	if *stripHTML {
+		opts = append(opts, sentiment.WithHTMLStripper())
+	}
+
+	if *strictParams {
+		opts = append(opts, sentiment.WithStrictParams())
+	}
+
+	if *statusBody {
+		opts = append(opts, sentiment.WithStatusBody())
+	}
+
+	if *strictStatus {
+		opts = append(opts, sentiment.WithStrictStatusMethods())
+	}
+
+	switch strings.ToLower(*apiVersion) {
+	case "v1":
+		opts = append(opts, sentiment.WithAPIVersion(sentiment.APIVersionV1))
+	case "v1beta2":
+		opts = append(opts, sentiment.WithAPIVersion(sentiment.APIVersionV1Beta2))
+	default:
+		zap.S().Fatalw("Invalid API version", "version", *apiVersion)
+	}
+
+	switch *respVersion {
+	case 1:
+		opts = append(opts, sentiment.WithDefaultResponseVersion(sentiment.ResponseV1))
+	case 2:
+		opts = append(opts, sentiment.WithDefaultResponseVersion(sentiment.ResponseV2))
+	default:
+		zap.S().Fatalw("Invalid response version", "version", *respVersion)
+	}
+
+	switch strings.ToLower(*invalidUTF8) {
+	case "reject":
+		opts = append(opts, sentiment.WithInvalidUTF8Handling(sentiment.RejectInvalidUTF8))
+	case "sanitize":
+		opts = append(opts, sentiment.WithInvalidUTF8Handling(sentiment.SanitizeInvalidUTF8))
+	default:
+		zap.S().Fatalw("Invalid UTF-8 handling", "handling", *invalidUTF8)
+	}
+
+	switch strings.ToLower(*accessLog) {
+	case "":
+	case "structured":
+		opts = append(opts, sentiment.WithAccessLog(sentiment.AccessLogStructured))
+	case "combined":
+		opts = append(opts, sentiment.WithAccessLog(sentiment.AccessLogCombined))
+	default:
+		zap.S().Fatalw("Invalid access log format", "format", *accessLog)
+	}
+
+	sentimentSvc, err := sentiment.NewService(opts...)
 
 	if err != nil {
 		zap.S().Fatalw("Failed to initialize Sentiment service", "error", err)
@@ -41,6 +246,10 @@ func main() {
 
 	defer sentimentSvc.Close()
 
+	if *cacheImport != "" {
+		importCache(sentimentSvc, *cacheImport)
+	}
+
 	httpServer := startHTTPServer(sentimentSvc)
 
 	shutdownChan := make(chan os.Signal, 1)
@@ -53,6 +262,21 @@ func main() {
 	httpServer.Shutdown(ctx)
 }
 
+func importCache(sentimentSvc *sentiment.Service, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		zap.S().Fatalw("Failed to open cache import file", "path", path, "error", err)
+	}
+	defer f.Close()
+
+	count, err := sentimentSvc.ImportCache(f)
+	if err != nil {
+		zap.S().Fatalw("Failed to import cache", "path", path, "error", err)
+	}
+
+	zap.S().Infow("Imported cache entries", "path", path, "count", count)
+}
+
 func initLogging() {
 	var logger *zap.Logger
 	var err error
@@ -115,21 +339,104 @@ func initLogging() {
 }
 
 func startHTTPServer(sentimentSvc *sentiment.Service) *http.Server {
+	if (*tlsCert == "") != (*tlsKey == "") {
+		zap.S().Fatalw("Both the TLS certificate and key must be provided", "cert", *tlsCert, "key", *tlsKey)
+	}
+	useTLS := *tlsCert != ""
+
+	// the write timeout is applied to each request by writeDeadlineHandler rather than by the server, which would also
+	// cut the streamed responses of /batch/sse
+	listener, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		zap.S().Fatalw("Failed to listen", "address", *listenAddr, "error", err)
+	}
+	tracking := &trackingListener{Listener: listener}
+	listener = tracking
+
 	httpServer := &http.Server{
 		Addr:              *listenAddr,
-		Handler:           sentimentSvc.RESTHandler(),
+		Handler:           writeDeadlineHandler(tracking, *writeTimeout, sentimentSvc.RESTHandler()),
 		ErrorLog:          zap.NewStdLog(zap.L().Named("http")),
 		ReadHeaderTimeout: httpTimeout,
-		WriteTimeout:      httpTimeout,
 		IdleTimeout:       httpTimeout,
 	}
 
+	if useTLS {
+		minVersion, ok := tlsVersions[*tlsMinVersion]
+		if !ok {
+			zap.S().Fatalw("Invalid minimum TLS version", "version", *tlsMinVersion)
+		}
+		httpServer.TLSConfig = &tls.Config{MinVersion: minVersion}
+	}
+
+	// connections over the limit are not rejected but wait in the listen backlog until a slot is free. Idle
+	// keep-alive connections hold a slot until they are closed by the idle timeout.
+	if *maxConnections > 0 {
+		listener = netutil.LimitListener(listener, *maxConnections)
+	}
+
 	go func() {
-		zap.S().Infow("Starting HTTP server")
-		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		zap.S().Infow("Starting HTTP server", "tls", useTLS, "max_connections", *maxConnections)
+		var err error
+		if useTLS {
+			err = httpServer.ServeTLS(listener, *tlsCert, *tlsKey)
+		} else {
+			err = httpServer.Serve(listener)
+		}
+
+		if err != http.ErrServerClosed {
 			zap.S().Fatalw("Failed to start HTTP server", "error", err)
 		}
 	}()
 
 	return httpServer
 }
+
+// streamingPaths are the routes whose responses are streamed for as long as the request is processed, to which the
+// write timeout does not apply
+var streamingPaths = map[string]bool{"/batch/sse": true}
+
+// trackingListener keeps its open connections by remote address so that writeDeadlineHandler can set the write
+// deadline of the connection serving a request
+type trackingListener struct {
+	net.Listener
+	conns sync.Map
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	l.conns.Store(conn.RemoteAddr().String(), conn)
+	return &trackedConn{Conn: conn, listener: l}, nil
+}
+
+// trackedConn forgets the connection when it is closed
+type trackedConn struct {
+	net.Conn
+	listener *trackingListener
+}
+
+func (c *trackedConn) Close() error {
+	c.listener.conns.Delete(c.RemoteAddr().String())
+	return c.Conn.Close()
+}
+
+// writeDeadlineHandler limits the time taken to write the response to an HTTP/1 request, except on the streaming
+// routes. HTTP/2 connections are shared by concurrent requests, so their write deadline is left unset and their
+// requests are only limited by -handler_timeout.
+func writeDeadlineHandler(listener *trackingListener, timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, ok := listener.conns.Load(r.RemoteAddr); ok && r.ProtoMajor == 1 {
+			// a connection kept alive may still have the deadline of the previous request
+			var deadline time.Time
+			if timeout > 0 && !streamingPaths[r.URL.Path] {
+				deadline = time.Now().Add(timeout)
+			}
+			conn.(net.Conn).SetWriteDeadline(deadline)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
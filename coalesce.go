@@ -0,0 +1,110 @@
+package sentiment
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// coalescer merges concurrent calls for the same key so that only one of them reaches the remote API. The first
+// call for a key waits for the window to elapse before calling the API, giving identical requests arriving shortly
+// afterwards the chance to share its result.
+type coalescer struct {
+	window time.Duration
+	mu     sync.Mutex
+	calls  map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done    chan struct{}
+	resp    *languagepb.AnalyzeSentimentResponse
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newCoalescer(window time.Duration) *coalescer {
+	return &coalescer{window: window, calls: make(map[string]*coalescedCall)}
+}
+
+// do returns the result of fn for the key, sharing a single execution among all the callers that arrive before it
+// completes. The shared execution carries the values of the context of the first caller, such as its request ID and
+// billing counter, but not its deadline or cancellation, so that a caller giving up does not fail the others. Each
+// caller stops waiting when its own context is done and the execution is cancelled once no caller is left waiting.
+func (c *coalescer) do(ctx context.Context, key string, fn func(context.Context) (*languagepb.AnalyzeSentimentResponse, error)) (*languagepb.AnalyzeSentimentResponse, error) {
+	c.mu.Lock()
+	call, ok := c.calls[key]
+	if !ok {
+		callCtx, cancelFunc := context.WithCancel(detachedContext{parent: ctx})
+		call = &coalescedCall{done: make(chan struct{}), cancel: cancelFunc}
+		c.calls[key] = call
+		go c.execute(callCtx, key, call, fn)
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.resp, call.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// later callers start a new execution instead of joining the abandoned one
+			if c.calls[key] == call {
+				delete(c.calls, key)
+			}
+			call.cancel()
+		}
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *coalescer) execute(ctx context.Context, key string, call *coalescedCall, fn func(context.Context) (*languagepb.AnalyzeSentimentResponse, error)) {
+	defer call.cancel()
+
+	if c.window > 0 {
+		timer := time.NewTimer(c.window)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	// the call is not made at all if every caller gave up during the window
+	if call.err = ctx.Err(); call.err == nil {
+		call.resp, call.err = fn(ctx)
+	}
+
+	c.mu.Lock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// detachedContext carries the values of its parent without its deadline or cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,168 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestCoalescing(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+		},
+	}
+
+	t.Run("identical_requests", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.coalescer = newCoalescer(50 * time.Millisecond)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil).After(10 * time.Millisecond)
+
+		var wg sync.WaitGroup
+		results := make([]Response, 10)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				resp, err := svc.ProcessSentiment(context.Background(), "I love the product.", Descending, -1)
+				assert.NoError(t, err)
+				results[i] = resp
+			}(i)
+		}
+		wg.Wait()
+
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 1)
+		for _, resp := range results {
+			assert.Equal(t, Response([]map[string]float32{map[string]float32{"I love the product.": 0.9}}), resp)
+		}
+	})
+
+	t.Run("distinct_requests", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.coalescer = newCoalescer(20 * time.Millisecond)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := svc.ProcessSentiment(context.Background(), fmt.Sprintf("document %d", i%3), Descending, -1)
+				assert.NoError(t, err)
+			}(i)
+		}
+		wg.Wait()
+
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 3)
+	})
+
+	t.Run("caller_gives_up", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.coalescer = newCoalescer(50 * time.Millisecond)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancelFunc()
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := svc.ProcessSentiment(ctx, "I love the product.", Descending, -1)
+			assert.Equal(t, context.DeadlineExceeded, err)
+		}()
+		go func() {
+			defer wg.Done()
+			resp, err := svc.ProcessSentiment(context.Background(), "I love the product.", Descending, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, Response([]map[string]float32{map[string]float32{"I love the product.": 0.9}}), resp)
+		}()
+		wg.Wait()
+
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 1)
+	})
+	t.Run("leader_context_values", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.requestIDs = true
+		svc.coalescer = newCoalescer(50 * time.Millisecond)
+
+		var forwardedIDs []string
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil).Run(func(args mock.Arguments) {
+			md, _ := metadata.FromOutgoingContext(args.Get(0).(context.Context))
+			forwardedIDs = md.Get(requestIDMetadataKey)
+		})
+
+		send := func(requestID string) *httptest.ResponseRecorder {
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"I love the product."}`))
+			request.Header.Set(RequestIDHeader, requestID)
+			svc.RESTHandler().ServeHTTP(responseRecorder, request)
+			return responseRecorder
+		}
+
+		var wg sync.WaitGroup
+		results := make([]*httptest.ResponseRecorder, 2)
+		for i, requestID := range []string{"leader", "follower"} {
+			wg.Add(1)
+			go func(i int, requestID string) {
+				defer wg.Done()
+				results[i] = send(requestID)
+			}(i, requestID)
+			// let the leader start the shared call before the follower joins it
+			time.Sleep(10 * time.Millisecond)
+		}
+		wg.Wait()
+
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 1)
+		assert.Equal(t, []string{"leader"}, forwardedIDs)
+		assert.Equal(t, http.StatusOK, results[0].Code)
+		assert.Equal(t, "1", results[0].Header().Get(billableUnitsHeader))
+		assert.Equal(t, http.StatusOK, results[1].Code)
+		assert.Equal(t, "0", results[1].Header().Get(billableUnitsHeader))
+	})
+
+	t.Run("every_caller_gives_up", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.requestTimeout = 0
+		svc.coalescer = newCoalescer(0)
+
+		cancelled := make(chan struct{})
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, context.Canceled).Run(func(args mock.Arguments) {
+			select {
+			case <-args.Get(0).(context.Context).Done():
+				close(cancelled)
+			case <-time.After(5 * time.Second):
+			}
+		}).Once()
+
+		ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancelFunc()
+		_, err := svc.ProcessSentiment(ctx, "I love the product.", Descending, -1)
+		assert.Equal(t, context.DeadlineExceeded, err)
+
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("shared call was not cancelled")
+		}
+
+		// a later caller starts a new call instead of joining the abandoned one
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil).Once()
+		resp, err := svc.ProcessSentiment(context.Background(), "I love the product.", Descending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response([]map[string]float32{map[string]float32{"I love the product.": 0.9}}), resp)
+	})
+}
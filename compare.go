@@ -0,0 +1,112 @@
+package sentiment
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// compareInput is the body of a comparison request
+type compareInput struct {
+	A      string `json:"a"`
+	B      string `json:"b"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// CompareResponse is the output of the comparison endpoint. Delta is the score of B minus the score of A and is only
+// set if both texts were analyzed.
+type CompareResponse struct {
+	A     DocumentScore `json:"a"`
+	B     DocumentScore `json:"b"`
+	Delta *float32      `json:"delta,omitempty"`
+}
+
+// DocumentScore holds the sentiment of a whole document, or the reason it could not be analyzed
+type DocumentScore struct {
+	Score     float32 `json:"score"`
+	Magnitude float32 `json:"magnitude"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// documentScore converts the document sentiment of the result of an analysis to its output format
+func (c *config) documentScore(result *languagepb.AnalyzeSentimentResponse, err error) DocumentScore {
+	if err != nil {
+		return DocumentScore{Error: "Failed to analyze document"}
+	}
+
+	ds := c.transformScores(result).GetDocumentSentiment()
+	return DocumentScore{Score: c.rescale(ds.GetScore()), Magnitude: ds.GetMagnitude()}
+}
+
+// Compare analyzes both texts concurrently, through the cache, and returns their document sentiment along with the
+// difference of their scores. A text that fails to be analyzed is reported as such without failing the comparison,
+// unless both fail, in which case the error of the first text is returned.
+func (svc *Service) Compare(ctx context.Context, a, b string) (*CompareResponse, error) {
+	var resultA, resultB *languagepb.AnalyzeSentimentResponse
+	var errA, errB error
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resultA, errA = svc.Analyze(ctx, a)
+	}()
+	resultB, errB = svc.Analyze(ctx, b)
+	wg.Wait()
+
+	if errA != nil && errB != nil {
+		return nil, errA
+	}
+
+	resp := &CompareResponse{A: svc.conf.documentScore(resultA, errA), B: svc.conf.documentScore(resultB, errB)}
+	if errA == nil && errB == nil {
+		delta := resp.B.Score - resp.A.Score
+		resp.Delta = &delta
+	}
+
+	return resp, nil
+}
+
+func (svc *Service) handleCompareRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer func() {
+			io.Copy(ioutil.Discard, r.Body)
+			r.Body.Close()
+		}()
+	}
+
+	if !svc.isAnalysisMethod(r.Method) {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var inp compareInput
+	if !svc.decodeRequestBody(w, r, &inp) {
+		return
+	}
+
+	if inp.A == "" || inp.B == "" {
+		svc.logger.Warnw("Comparison request missing a text")
+		http.Error(w, "Both a and b must be given", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if tenant := requestTenant(r, inp.Tenant); tenant != "" {
+		ctx = WithTenant(ctx, tenant)
+	}
+
+	resp, err := svc.Compare(ctx, inp.A, inp.B)
+	if err != nil {
+		svc.logger.Errorw("Request failed", "error", err)
+		writeError(w, err)
+		return
+	}
+
+	svc.writeResponse(w, r, http.StatusOK, resp)
+}
@@ -0,0 +1,123 @@
+package sentiment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestCompare(t *testing.T) {
+	documentResponse := func(score, magnitude float32) *languagepb.AnalyzeSentimentResponse {
+		return &languagepb.AnalyzeSentimentResponse{DocumentSentiment: &languagepb.Sentiment{Score: score, Magnitude: magnitude}}
+	}
+
+	t.Run("delta", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Our product is fine."), mock.Anything).Return(documentResponse(0.25, 0.5), nil).Once()
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Our product is amazing!"), mock.Anything).Return(documentResponse(0.75, 1.5), nil).Once()
+
+		resp, err := svc.Compare(context.Background(), "Our product is fine.", "Our product is amazing!")
+		assert.NoError(t, err)
+		assert.Equal(t, DocumentScore{Score: 0.25, Magnitude: 0.5}, resp.A)
+		assert.Equal(t, DocumentScore{Score: 0.75, Magnitude: 1.5}, resp.B)
+		if assert.NotNil(t, resp.Delta) {
+			assert.Equal(t, float32(0.5), *resp.Delta)
+		}
+
+		// the reverse comparison is served from the cache and has the opposite delta
+		resp, err = svc.Compare(context.Background(), "Our product is amazing!", "Our product is fine.")
+		assert.NoError(t, err)
+		if assert.NotNil(t, resp.Delta) {
+			assert.Equal(t, float32(-0.5), *resp.Delta)
+		}
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("scaled_and_inverted", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.scoreTransform = func(score, magnitude float32) float32 { return -score }
+		svc.conf.scoreScaled, svc.conf.scoreMin, svc.conf.scoreMax = true, 0, 10
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("a"), mock.Anything).Return(documentResponse(-0.5, 0.5), nil)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("b"), mock.Anything).Return(documentResponse(0.5, 0.5), nil)
+
+		resp, err := svc.Compare(context.Background(), "a", "b")
+		assert.NoError(t, err)
+		assert.Equal(t, float32(7.5), resp.A.Score)
+		assert.Equal(t, float32(2.5), resp.B.Score)
+		if assert.NotNil(t, resp.Delta) {
+			assert.Equal(t, float32(-5), *resp.Delta)
+		}
+	})
+
+	t.Run("one_side_fails", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("a"), mock.Anything).Return(documentResponse(0.5, 0.5), nil)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("b"), mock.Anything).Return(nil, assert.AnError)
+
+		resp, err := svc.Compare(context.Background(), "a", "b")
+		assert.NoError(t, err)
+		assert.Equal(t, DocumentScore{Score: 0.5, Magnitude: 0.5}, resp.A)
+		assert.NotEmpty(t, resp.B.Error)
+		assert.Nil(t, resp.Delta)
+	})
+
+	t.Run("both_sides_fail", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, assert.AnError)
+
+		_, err := svc.Compare(context.Background(), "a", "b")
+		assert.Equal(t, assert.AnError, err)
+	})
+}
+
+func TestCompareHTTPRequest(t *testing.T) {
+	testCases := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "success",
+			body:           `{"a":"Our product is fine.","b":"Our product is amazing!"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"a":{"score":0.25,"magnitude":0.5},"b":{"score":0.75,"magnitude":1.5},"delta":0.5}`,
+		},
+		{
+			name:           "one_side_fails",
+			body:           `{"a":"Our product is fine.","b":"Our product is broken."}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"a":{"score":0.25,"magnitude":0.5},"b":{"score":0,"magnitude":0,"error":"Failed to analyze document"}}`,
+		},
+		{name: "missing_b", body: `{"a":"Our product is fine."}`, expectedStatus: http.StatusBadRequest},
+		{name: "invalid_body", body: `{"a":`, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Our product is fine."), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+				DocumentSentiment: &languagepb.Sentiment{Score: 0.25, Magnitude: 0.5},
+			}, nil)
+			mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Our product is amazing!"), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+				DocumentSentiment: &languagepb.Sentiment{Score: 0.75, Magnitude: 1.5},
+			}, nil)
+			mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Our product is broken."), mock.Anything).Return(nil, assert.AnError)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/compare", strings.NewReader(tc.body))
+			svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+			assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+			if tc.expectedStatus == http.StatusOK {
+				assert.JSONEq(t, tc.expectedBody, responseRecorder.Body.String())
+			}
+		})
+	}
+}
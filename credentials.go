@@ -0,0 +1,71 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	language "cloud.google.com/go/language/apiv1"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const quotaProjectHeader = "x-goog-user-project"
+
+// clientOptions builds the options used to create the Google language client
+func (c *config) clientOptions() []option.ClientOption {
+	var opts []option.ClientOption
+	if c.credentialsJSON != nil {
+		opts = append(opts, option.WithCredentialsJSON(c.credentialsJSON))
+	}
+
+	if c.quotaProject != "" {
+		quotaProject := c.quotaProject
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithUnaryInterceptor(
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				ctx = metadata.AppendToOutgoingContext(ctx, quotaProjectHeader, quotaProject)
+				return invoker(ctx, method, req, reply, cc, opts...)
+			})))
+	}
+
+	return opts
+}
+
+// newLanguageClient creates the Google language client, describing the source of the credentials on failure
+func newLanguageClient(ctx context.Context, conf *config) (languageClient, error) {
+	var client languageClient
+	var err error
+	switch conf.apiVersion {
+	case APIVersionV1:
+		client, err = language.NewClient(ctx, conf.clientOptions()...)
+	case APIVersionV1Beta2:
+		client, err = newV1Beta2Client(ctx, conf.clientOptions()...)
+	default:
+		return nil, fmt.Errorf("unsupported API version: %s", conf.apiVersion)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Google language %s client using %s: %+v", conf.apiVersion, describeCredentials(ctx, conf), err)
+	}
+
+	return client, nil
+}
+
+// describeCredentials returns a human readable description of the credentials the client would use
+func describeCredentials(ctx context.Context, conf *config) string {
+	if conf.credentialsJSON != nil {
+		return "explicitly provided credentials JSON"
+	}
+
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return fmt.Sprintf("credentials file %q from GOOGLE_APPLICATION_CREDENTIALS", path)
+	}
+
+	if _, err := google.FindDefaultCredentials(ctx, language.DefaultAuthScopes()...); err != nil {
+		return "application default credentials (none found; set GOOGLE_APPLICATION_CREDENTIALS or use WithCredentialsJSON)"
+	}
+
+	return "application default credentials"
+}
@@ -0,0 +1,38 @@
+package sentiment
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentials(t *testing.T) {
+	t.Run("bad_credentials_json", func(t *testing.T) {
+		svc, err := NewService(WithCredentialsJSON([]byte(`{"type": "service_account"`)))
+		assert.Nil(t, svc)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to create Google language v1 client using explicitly provided credentials JSON")
+	})
+
+	t.Run("describe_credentials_file", func(t *testing.T) {
+		prev, hadPrev := os.LookupEnv("GOOGLE_APPLICATION_CREDENTIALS")
+		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "/credentials/service_account.json")
+		defer func() {
+			if hadPrev {
+				os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", prev)
+			} else {
+				os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+			}
+		}()
+
+		desc := describeCredentials(context.Background(), &config{})
+		assert.Equal(t, `credentials file "/credentials/service_account.json" from GOOGLE_APPLICATION_CREDENTIALS`, desc)
+	})
+
+	t.Run("client_options", func(t *testing.T) {
+		assert.Len(t, (&config{}).clientOptions(), 0)
+		assert.Len(t, (&config{credentialsJSON: []byte("{}"), quotaProject: "my-project"}).clientOptions(), 2)
+	})
+}
@@ -0,0 +1,251 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentTypeCSV = "text/csv"
+	// csvChunkSize is the number of rows processed as a batch before their results are written and flushed
+	csvChunkSize = 100
+	// maxCSVColumnParamBytes limits the size of the column form field
+	maxCSVColumnParamBytes = 1024
+)
+
+// csvResultColumns are the columns appended to each row of an uploaded CSV file
+var csvResultColumns = []string{"sentiment_score", "sentiment_error"}
+
+// findCSVColumn returns the index of the column identified by either its name in the header or its zero-based index
+func findCSVColumn(header []string, column string) (int, bool) {
+	for i, name := range header {
+		if name == column {
+			return i, true
+		}
+	}
+
+	if idx, err := strconv.Atoi(column); err == nil && idx >= 0 && idx < len(header) {
+		return idx, true
+	}
+
+	return 0, false
+}
+
+// meanScore returns the average score of the sentences of the response and false if there are none
+func meanScore(resp Response) (float32, bool) {
+	var total float32
+	var count int
+	for _, sentence := range resp {
+		for _, score := range sentence {
+			total += score
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, false
+	}
+	return total / float32(count), true
+}
+
+// csvRow is a row of an uploaded CSV file along with the outcome of parsing it
+type csvRow struct {
+	fields []string
+	err    string
+}
+
+// processCSVChunk analyzes the text column of the rows with ProcessBatch and returns the rows with the result
+// columns appended. Rows that failed to parse or lack the text column are reported as such without being analyzed.
+func (svc *Service) processCSVChunk(ctx context.Context, rows []csvRow, column, width int) [][]string {
+	var inputs []string
+	var indexes []int
+	for i, row := range rows {
+		if row.err == "" && column < len(row.fields) && strings.TrimSpace(row.fields[column]) != "" {
+			inputs = append(inputs, row.fields[column])
+			indexes = append(indexes, i)
+		}
+	}
+
+	results := make([]BatchResult, len(rows))
+	for i, result := range svc.processBatch(ctx, inputs, DocumentOrder, -1, nil) {
+		results[indexes[i]] = result
+	}
+
+	records := make([][]string, len(rows))
+	for i, row := range rows {
+		// rows are padded to the width of the header so that the result columns line up
+		record := make([]string, width, width+len(csvResultColumns))
+		copy(record, row.fields)
+
+		score, errMsg := "", row.err
+		switch {
+		case errMsg != "":
+		case column >= len(row.fields):
+			errMsg = "Missing text column"
+		case results[i].Err != nil:
+			errMsg = batchErrorMessage(results[i].Err)
+		default:
+			if mean, ok := meanScore(results[i].Response); ok {
+				score = strconv.FormatFloat(float64(mean), 'f', -1, 32)
+			}
+		}
+
+		records[i] = append(record, score, errMsg)
+	}
+
+	return records
+}
+
+// writeCSV reads the uploaded CSV file, whose first row is the header, and writes it back with the sentiment of the
+// given column appended to each row. Rows are processed in chunks so that large files are streamed rather than held
+// in memory. An error response is written if the header cannot be read or the column does not exist.
+func (svc *Service) writeCSV(ctx context.Context, w http.ResponseWriter, file io.Reader, column string) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		svc.logger.Warnw("Failed to read CSV header", "error", err)
+		http.Error(w, "Failed to read CSV header", http.StatusBadRequest)
+		return
+	}
+
+	columnIdx, ok := findCSVColumn(header, column)
+	if !ok {
+		svc.logger.Warnw("Unknown CSV column", "column", column)
+		http.Error(w, fmt.Sprintf("Unknown column %q", column), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeCSV)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	writer := csv.NewWriter(w)
+	writer.Write(append(header, csvResultColumns...))
+
+	width := len(header)
+	rows := make([]csvRow, 0, csvChunkSize)
+	flush := func() bool {
+		if len(rows) > 0 {
+			writer.WriteAll(svc.processCSVChunk(ctx, rows, columnIdx, width))
+			rows = rows[:0]
+		}
+		writer.Flush()
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if err := writer.Error(); err != nil {
+			svc.logger.Warnw("Failed to write CSV", "error", err)
+			return false
+		}
+		if err := ctx.Err(); err != nil {
+			svc.logger.Errorw("CSV request failed", "error", err)
+			return false
+		}
+		return true
+	}
+
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				// the upload can no longer be read, so what has been processed so far is all the client gets
+				svc.logger.Warnw("Failed to read CSV", "error", err)
+				flush()
+				return
+			}
+			rows = append(rows, csvRow{err: fmt.Sprintf("Malformed row at line %d", parseErr.Line)})
+		} else {
+			rows = append(rows, csvRow{fields: fields})
+		}
+
+		if len(rows) == csvChunkSize && !flush() {
+			return
+		}
+	}
+
+	flush()
+}
+
+// handleCSVRequest analyzes a column of a CSV file uploaded as multipart/form-data. The column is identified by name
+// or zero-based index with the column form field, which must precede the file field, or the column query parameter.
+func (svc *Service) handleCSVRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer func() {
+			io.Copy(ioutil.Discard, r.Body)
+			r.Body.Close()
+		}()
+	}
+
+	if !svc.isAnalysisMethod(r.Method) {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxBytes := svc.conf.maxRequestBodyBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxRequestBodyBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		svc.logger.Warnw("Bad CSV upload", "error", err)
+		http.Error(w, "Expected multipart/form-data", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if tenant := requestTenant(r, ""); tenant != "" {
+		ctx = WithTenant(ctx, tenant)
+	}
+
+	column := r.URL.Query().Get("column")
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			svc.logger.Warnw("Bad CSV upload", "error", err)
+			status, msg := describeDecodeError(err)
+			http.Error(w, msg, status)
+			return
+		}
+
+		switch part.FormName() {
+		case "column":
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxCSVColumnParamBytes))
+			if err != nil {
+				svc.logger.Warnw("Bad CSV upload", "error", err)
+				http.Error(w, "Failed to read column", http.StatusBadRequest)
+				return
+			}
+			column = strings.TrimSpace(string(value))
+		case "file":
+			if column == "" {
+				svc.logger.Warnw("CSV upload missing column")
+				http.Error(w, "The column must be given before the file", http.StatusBadRequest)
+				return
+			}
+			svc.writeCSV(ctx, w, part, column)
+			return
+		}
+	}
+
+	svc.logger.Warnw("CSV upload missing file")
+	http.Error(w, "No file uploaded", http.StatusBadRequest)
+}
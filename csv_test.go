@@ -0,0 +1,128 @@
+package sentiment
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestCSVRequest(t *testing.T) {
+	sentimentFor := func(content string, score float32) *languagepb.AnalyzeSentimentResponse {
+		return &languagepb.AnalyzeSentimentResponse{
+			Sentences: []*languagepb.Sentence{
+				&languagepb.Sentence{
+					Text:      &languagepb.TextSpan{Content: content},
+					Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: score},
+				},
+			},
+		}
+	}
+
+	setup := func(t *testing.T) *Service {
+		mockClient, svc := createMocks(t)
+		svc.conf.batchConcurrency = 2
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Great product!"), mock.Anything).Return(sentimentFor("Great product!", 0.9), nil)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Terrible."), mock.Anything).Return(sentimentFor("Terrible.", -0.75), nil)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Unlucky."), mock.Anything).Return(nil, assert.AnError)
+		return svc
+	}
+
+	doRequest := func(svc *Service, target string, column string, file string) (int, string) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		if column != "" {
+			mw.WriteField("column", column)
+		}
+		if file != "" {
+			fw, _ := mw.CreateFormFile("file", "reviews.csv")
+			fw.Write([]byte(file))
+		}
+		mw.Close()
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, target, &body)
+		request.Header.Set("Content-Type", mw.FormDataContentType())
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+		result := responseRecorder.Result()
+		output, _ := ioutil.ReadAll(result.Body)
+		return result.StatusCode, string(output)
+	}
+
+	upload := "id,review\n1,Great product!\n2,Terrible.\n"
+
+	t.Run("column_name", func(t *testing.T) {
+		status, output := doRequest(setup(t), "/csv", "review", upload)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "id,review,sentiment_score,sentiment_error\n1,Great product!,0.9,\n2,Terrible.,-0.75,\n", output)
+	})
+
+	t.Run("column_index", func(t *testing.T) {
+		status, output := doRequest(setup(t), "/csv?column=1", "", upload)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "id,review,sentiment_score,sentiment_error\n1,Great product!,0.9,\n2,Terrible.,-0.75,\n", output)
+	})
+
+	t.Run("malformed_rows", func(t *testing.T) {
+		file := "id,review\n1,Great product!\n2\n3,\"Terrible.\"x\n4,Unlucky.\n5,\n6,Terrible.\n"
+		status, output := doRequest(setup(t), "/csv", "review", file)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "id,review,sentiment_score,sentiment_error\n"+
+			"1,Great product!,0.9,\n"+
+			"2,,,Missing text column\n"+
+			",,,Malformed row at line 4\n"+
+			"4,Unlucky.,,Failed to analyze document\n"+
+			"5,,,\n"+
+			"6,Terrible.,-0.75,\n", output)
+	})
+
+	t.Run("many_rows", func(t *testing.T) {
+		var file, expected bytes.Buffer
+		file.WriteString("review\n")
+		expected.WriteString("review,sentiment_score,sentiment_error\n")
+		for i := 0; i < csvChunkSize*2+1; i++ {
+			file.WriteString("Terrible.\n")
+			expected.WriteString("Terrible.,-0.75,\n")
+		}
+
+		status, output := doRequest(setup(t), "/csv", "0", file.String())
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, expected.String(), output)
+	})
+
+	t.Run("unknown_column", func(t *testing.T) {
+		status, _ := doRequest(setup(t), "/csv", "title", upload)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("missing_column", func(t *testing.T) {
+		status, _ := doRequest(setup(t), "/csv", "", upload)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("missing_file", func(t *testing.T) {
+		status, _ := doRequest(setup(t), "/csv", "review", "")
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("not_multipart", func(t *testing.T) {
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/csv", bytes.NewBufferString(upload))
+		setup(t).RESTHandler().ServeHTTP(responseRecorder, request)
+		assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
+	})
+
+	t.Run("bad_method", func(t *testing.T) {
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/csv", nil)
+		setup(t).RESTHandler().ServeHTTP(responseRecorder, request)
+		assert.Equal(t, http.StatusMethodNotAllowed, responseRecorder.Result().StatusCode)
+	})
+}
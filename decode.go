@@ -0,0 +1,113 @@
+package sentiment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	// defaultMaxRequestBodyBytes allows for documents larger than the Google API limit when auto chunking is enabled
+	defaultMaxRequestBodyBytes = 10 << 20
+	// maxJSONNesting is the maximum depth of nested objects and arrays accepted in a request body
+	maxJSONNesting = 32
+)
+
+// errJSONTooDeep is returned when a request body exceeds maxJSONNesting
+var errJSONTooDeep = fmt.Errorf("JSON nested deeper than %d levels", maxJSONNesting)
+
+// nestingLimitReader fails reads once the JSON passing through it is nested deeper than the limit, so that
+// pathological inputs are rejected while they are being streamed rather than after they have been read
+type nestingLimitReader struct {
+	r        io.Reader
+	limit    int
+	depth    int
+	inString bool
+	escaped  bool
+}
+
+func (nr *nestingLimitReader) Read(p []byte) (int, error) {
+	n, err := nr.r.Read(p)
+	for _, b := range p[:n] {
+		switch {
+		case nr.escaped:
+			nr.escaped = false
+		case nr.inString:
+			if b == '\\' {
+				nr.escaped = true
+			} else if b == '"' {
+				nr.inString = false
+			}
+		case b == '"':
+			nr.inString = true
+		case b == '{' || b == '[':
+			nr.depth++
+			if nr.depth > nr.limit {
+				return 0, errJSONTooDeep
+			}
+		case b == '}' || b == ']':
+			nr.depth--
+		}
+	}
+
+	return n, err
+}
+
+// decodeRequestBody decodes a single JSON value from the request body into v. The body is limited in size and
+// nesting depth and must not contain anything after the value. If the body is invalid, an error response describing
+// the problem is written and false is returned.
+func (svc *Service) decodeRequestBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Body == nil {
+		http.Error(w, "Request body is empty", http.StatusBadRequest)
+		return false
+	}
+
+	maxBytes := svc.conf.maxRequestBodyBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxRequestBodyBytes
+	}
+
+	dec := json.NewDecoder(&nestingLimitReader{r: http.MaxBytesReader(w, r.Body, maxBytes), limit: maxJSONNesting})
+	err := dec.Decode(v)
+	if err == nil {
+		// anything other than whitespace after the value is an error
+		if _, tokenErr := dec.Token(); tokenErr != io.EOF {
+			err = errors.New("unexpected data after JSON value")
+		}
+	}
+
+	if err == nil {
+		return true
+	}
+
+	svc.logger.Errorw("Failed to parse request body", "error", err)
+	status, msg := describeDecodeError(err)
+	http.Error(w, msg, status)
+	return false
+}
+
+// describeDecodeError maps an error from decoding a request body to an HTTP status and a message for the client
+func describeDecodeError(err error) (int, string) {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return http.StatusBadRequest, fmt.Sprintf("Malformed JSON at offset %d", e.Offset)
+	case *json.UnmarshalTypeError:
+		return http.StatusBadRequest, fmt.Sprintf("Invalid value for field %q", e.Field)
+	}
+
+	switch {
+	case err == io.EOF:
+		return http.StatusBadRequest, "Request body is empty"
+	case err == io.ErrUnexpectedEOF:
+		return http.StatusBadRequest, "Truncated JSON"
+	case err == errJSONTooDeep:
+		return http.StatusBadRequest, "JSON nested too deeply"
+	case err.Error() == "http: request body too large":
+		// http.MaxBytesReader does not export its error
+		return http.StatusRequestEntityTooLarge, "Request body too large"
+	}
+
+	return http.StatusBadRequest, "Bad request: " + err.Error()
+}
@@ -0,0 +1,110 @@
+package sentiment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestDecodeRequestBody(t *testing.T) {
+	testCases := []struct {
+		name           string
+		body           string
+		maxBytes       int64
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "valid",
+			body:           `{"content":"Great."}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "trailing_whitespace",
+			body:           "{\"content\":\"Great.\"}\n\t ",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "nested_within_limit",
+			body:           `{"content":"Great.","extra":` + strings.Repeat("[", maxJSONNesting-1) + strings.Repeat("]", maxJSONNesting-1) + `}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "brackets_in_string",
+			body:           `{"content":"` + strings.Repeat(`[{\"`, 100) + `"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "trailing_object",
+			body:           `{"content":"Great."}{"content":"Bad."}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "unexpected data after JSON value",
+		},
+		{
+			name:           "trailing_garbage",
+			body:           `{"content":"Great."} garbage`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "unexpected data after JSON value",
+		},
+		{
+			name:           "deeply_nested",
+			body:           `{"content":"Great.","extra":` + strings.Repeat("[", 10000) + strings.Repeat("]", 10000) + `}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "JSON nested too deeply",
+		},
+		{
+			name:           "truncated",
+			body:           `{"content":"Gre`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Truncated JSON",
+		},
+		{
+			name:           "malformed",
+			body:           `{"content" "Great."}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Malformed JSON at offset 12",
+		},
+		{
+			name:           "wrong_type",
+			body:           `{"content":42}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `Invalid value for field "content"`,
+		},
+		{
+			name:           "empty",
+			body:           ``,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Request body is empty",
+		},
+		{
+			name:           "too_large",
+			body:           `{"content":"` + strings.Repeat("a", 100) + `"}`,
+			maxBytes:       64,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedError:  "Request body too large",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.maxRequestBodyBytes = tc.maxBytes
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(tc.body))
+			svc.handleHTTPRequest(responseRecorder, request)
+
+			assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+			if tc.expectedError != "" {
+				assert.Contains(t, responseRecorder.Body.String(), tc.expectedError)
+				mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
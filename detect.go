@@ -0,0 +1,161 @@
+package sentiment
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// detectCachePrefix separates the cached language of an input from its cached sentiment
+const detectCachePrefix = "detect:"
+
+// LanguageResponse is the output of the language detection endpoint
+type LanguageResponse struct {
+	Language string `json:"language"`
+}
+
+// DetectLanguage returns the language of the input as detected by the Google API, served from the cache when
+// possible. The API does not report a confidence for the detected language.
+func (svc *Service) DetectLanguage(ctx context.Context, input string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		svc.logger.Warnw("Context cancelled", "error", err, "input", input)
+		return "", err
+	}
+
+	input, err := svc.normalizeInput(input)
+	if err != nil {
+		return "", err
+	}
+
+	language, _, err := svc.detectLanguage(ctx, input)
+	return language, err
+}
+
+// detectLanguage implements DetectLanguage for a normalized input. When the language is not cached, the response of
+// the call made to detect it, which analyzed the input without a language, is returned as well.
+func (svc *Service) detectLanguage(ctx context.Context, input string) (string, *languagepb.AnalyzeSentimentResponse, error) {
+	// the language must be left unset for the API to detect it
+	params := svc.analysisParams()
+	params.language = ""
+	key := detectCachePrefix + cacheKey(ctx, input, params)
+	if entry, err := svc.cache.Get(key); err == nil {
+		return string(entry), nil, nil
+	}
+
+	if svc.conf.cacheOnly {
+		return "", nil, ErrCacheMiss
+	}
+
+	if svc.client == nil || svc.isClosed() {
+		return "", nil, ErrServiceClosed
+	}
+
+	if err := svc.quota.check(quotaKeyFromContext(ctx)); err != nil {
+		return "", nil, err
+	}
+
+	// the sentiment API reports the language of the document so a single call is enough to detect it
+	resp, err := svc.callAPI(ctx, params.request(input))
+	if ctx.Err() == nil {
+		svc.health.record(err == nil)
+	}
+	if err != nil {
+		svc.logger.Errorw("Remote API call failure", "error", err, "input", input)
+		return "", nil, err
+	}
+
+	language := resp.GetLanguage()
+	svc.setCachedEntry(key, []byte(language), input)
+	return language, resp, nil
+}
+
+func (svc *Service) handleDetectRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer func() {
+			io.Copy(ioutil.Discard, r.Body)
+			r.Body.Close()
+		}()
+	}
+
+	if !svc.isAnalysisMethod(r.Method) {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var inp input
+	if !svc.decodeRequestBody(w, r, &inp) {
+		return
+	}
+
+	ctx := r.Context()
+	if tenant := requestTenant(r, inp.Tenant); tenant != "" {
+		ctx = WithTenant(ctx, tenant)
+	}
+
+	language, err := svc.DetectLanguage(ctx, inp.Content)
+	if err != nil {
+		svc.logger.Errorw("Request failed", "error", err)
+		writeError(w, err)
+		return
+	}
+
+	svc.writeResponse(w, r, http.StatusOK, LanguageResponse{Language: language})
+}
+
+// maxLanguageHints is the maximum number of candidate languages accepted in a request
+const maxLanguageHints = 10
+
+type languageHintsKey struct{}
+
+// WithLanguageHints returns a context for which the language of the input is detected before the analysis and the
+// matching candidate language is sent to the Google API. The first candidate is used if the detected language matches
+// none of them.
+func WithLanguageHints(ctx context.Context, languages ...string) context.Context {
+	return context.WithValue(ctx, languageHintsKey{}, languages)
+}
+
+// languageHintsFromContext returns the candidate languages associated with the context, if any
+func languageHintsFromContext(ctx context.Context) []string {
+	languages, _ := ctx.Value(languageHintsKey{}).([]string)
+	return languages
+}
+
+// chooseLanguage picks the candidate language matching the detected language of the normalized input. If the language
+// had to be detected and matches a candidate, the response of the detection call is returned too, as it is the
+// analysis of the input in that language and need not be requested again.
+func (svc *Service) chooseLanguage(ctx context.Context, input string, candidates []string) (string, *languagepb.AnalyzeSentimentResponse, error) {
+	detected, resp, err := svc.detectLanguage(ctx, input)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, candidate := range candidates {
+		if primaryLanguage(candidate) == primaryLanguage(detected) {
+			return candidate, resp, nil
+		}
+	}
+
+	return candidates[0], nil, nil
+}
+
+// primaryLanguage returns the primary subtag of a language code, such as "en" for "en-US"
+func primaryLanguage(language string) string {
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	return strings.ToLower(language)
+}
+
+// languageTagPattern matches language tags of the form described by BCP 47, such as "en", "zh-Hant" or "pt-BR"
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// validLanguageTag reports whether the language is a plausible BCP 47 language tag
+func validLanguageTag(language string) bool {
+	return languageTagPattern.MatchString(language)
+}
@@ -0,0 +1,267 @@
+package sentiment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{Language: "fr"}, nil).Once()
+
+	language, err := svc.DetectLanguage(context.Background(), "J'adore ce produit.")
+	assert.NoError(t, err)
+	assert.Equal(t, "fr", language)
+
+	// the second call must be served from the cache
+	language, err = svc.DetectLanguage(context.Background(), "J'adore ce produit.")
+	assert.NoError(t, err)
+	assert.Equal(t, "fr", language)
+	mockClient.AssertExpectations(t)
+
+	// detection results must not be mistaken for cached sentiment results
+	assert.Nil(t, svc.getCachedResult(cacheKey(context.Background(), "J'adore ce produit.", svc.analysisParams())))
+}
+
+func TestDetectLanguageNormalized(t *testing.T) {
+	t.Run("invalid_utf8", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+
+		_, err := svc.DetectLanguage(context.Background(), "J'adore ce produit g\xe9nial.")
+		assert.IsType(t, &InvalidUTF8Error{}, err)
+		mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("html_stripped", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.stripHTML = true
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("J'adore ce produit."), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{Language: "fr"}, nil).Once()
+
+		language, err := svc.DetectLanguage(context.Background(), `<html lang="en"><body><p>J'adore ce produit.</p></body></html>`)
+		assert.NoError(t, err)
+		assert.Equal(t, "fr", language)
+		mockClient.AssertExpectations(t)
+	})
+}
+
+func TestDetectLanguageHTTPRequest(t *testing.T) {
+	testCases := []struct {
+		name           string
+		method         string
+		body           string
+		apiResponse    *languagepb.AnalyzeSentimentResponse
+		apiError       error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "detected",
+			method:         http.MethodPost,
+			body:           `{"content":"I love the product."}`,
+			apiResponse:    &languagepb.AnalyzeSentimentResponse{Language: "en"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"language":"en"}`,
+		},
+		{
+			name:           "api_error",
+			method:         http.MethodPost,
+			body:           `{"content":"I love the product."}`,
+			apiError:       errors.New("boom"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "bad_body",
+			method:         http.MethodPost,
+			body:           `{`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "bad_method",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(tc.apiResponse, tc.apiError)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(tc.method, "/detect", strings.NewReader(tc.body))
+			svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+			assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+			if tc.expectedStatus == http.StatusOK {
+				assert.JSONEq(t, tc.expectedBody, responseRecorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestLanguageHints(t *testing.T) {
+	testCases := []struct {
+		name             string
+		detected         string
+		hints            []string
+		expectedLanguage string
+		expectedCalls    int
+	}{
+		{name: "exact_match", detected: "fr", hints: []string{"en", "fr"}, expectedLanguage: "fr", expectedCalls: 1},
+		{name: "region_match", detected: "en", hints: []string{"fr", "en-GB"}, expectedLanguage: "en-GB", expectedCalls: 1},
+		{name: "no_match", detected: "de", hints: []string{"es", "fr"}, expectedLanguage: "es", expectedCalls: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			withLanguage := func(language string) interface{} {
+				return mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+					return req.GetDocument().GetLanguage() == language
+				})
+			}
+			mockClient.On("AnalyzeSentiment", mock.Anything, withLanguage(""), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{Language: tc.detected}, nil).Once()
+			mockClient.On("AnalyzeSentiment", mock.Anything, withLanguage(tc.expectedLanguage), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{Language: tc.expectedLanguage}, nil).Once()
+
+			billing := &billingCounter{}
+			ctx := withBillingCounter(WithLanguageHints(context.Background(), tc.hints...), billing)
+			_, err := svc.ProcessSentiment(ctx, "Bonjour. Hello.", Descending, -1)
+			assert.NoError(t, err)
+
+			// the detection call is the analysis when it detects one of the hints
+			mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", tc.expectedCalls)
+			assert.Equal(t, strconv.Itoa(tc.expectedCalls), billing.String())
+
+			// both the detection and the analysis are cached, the latter under the chosen language
+			_, err = svc.ProcessSentiment(ctx, "Bonjour. Hello.", Descending, -1)
+			assert.NoError(t, err)
+			mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", tc.expectedCalls)
+
+			params := svc.analysisParams()
+			params.language = tc.expectedLanguage
+			assert.NotNil(t, svc.getCachedResult(cacheKey(context.Background(), "Bonjour. Hello.", params)))
+		})
+	}
+}
+
+func TestLanguageHintsWithStopwords(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	WithStopwordRemoval(nil)(svc.conf)
+	withContent := func(content string) interface{} {
+		return mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+			return req.GetDocument().GetContent() == content
+		})
+	}
+	mockClient.On("AnalyzeSentiment", mock.Anything, withContent("The product is great."), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{Language: "en"}, nil).Once()
+	mockClient.On("AnalyzeSentiment", mock.Anything, withContent("product great."), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{Language: "en"}, nil).Once()
+
+	// the detection call analyzed the text with its stopwords, which is not the analysis that was asked for
+	_, err := svc.ProcessSentiment(WithLanguageHints(context.Background(), "en"), "The product is great.", Descending, -1)
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestLanguageHintsHTTPRequest(t *testing.T) {
+	testCases := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "hints", body: `{"content":"Bonjour.","language_hints":["en","fr"]}`, expectedStatus: http.StatusOK},
+		{name: "empty_hint", body: `{"content":"Bonjour.","language_hints":["en",""]}`, expectedStatus: http.StatusBadRequest},
+		{name: "too_many_hints", body: `{"content":"Bonjour.","language_hints":["a","b","c","d","e","f","g","h","i","j","k"]}`, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+				return req.GetDocument().GetLanguage() == ""
+			}), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{Language: "fr"}, nil)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+				return req.GetDocument().GetLanguage() == "fr"
+			}), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(tc.body))
+			svc.handleHTTPRequest(responseRecorder, request)
+
+			assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+			if tc.expectedStatus == http.StatusOK {
+				// the detected language is one of the hints so the detection call is the analysis
+				mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 1)
+			} else {
+				mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestDefaultLanguage(t *testing.T) {
+	withLanguage := func(language string) interface{} {
+		return mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+			return req.GetDocument().GetLanguage() == language
+		})
+	}
+
+	t.Run("default", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.defaultLanguage = "pt-BR"
+		mockClient.On("AnalyzeSentiment", mock.Anything, withLanguage("pt-BR"), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{Language: "pt-BR"}, nil).Once()
+
+		_, err := svc.ProcessSentiment(context.Background(), "Eu adoro este produto.", Descending, -1)
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("hints_take_precedence", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.defaultLanguage = "pt-BR"
+		mockClient.On("AnalyzeSentiment", mock.Anything, withLanguage(""), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{Language: "fr"}, nil).Once()
+
+		ctx := WithLanguageHints(context.Background(), "en", "fr")
+		_, err := svc.ProcessSentiment(ctx, "J'adore ce produit.", Descending, -1)
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+
+		params := svc.analysisParams()
+		params.language = "fr"
+		assert.NotNil(t, svc.getCachedResult(cacheKey(context.Background(), "J'adore ce produit.", params)))
+	})
+
+	t.Run("detection_ignores_default", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.defaultLanguage = "en"
+		mockClient.On("AnalyzeSentiment", mock.Anything, withLanguage(""), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{Language: "fr"}, nil).Once()
+
+		language, err := svc.DetectLanguage(context.Background(), "J'adore ce produit.")
+		assert.NoError(t, err)
+		assert.Equal(t, "fr", language)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewService(WithDefaultLanguage("english"))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid default language "english"`)
+	})
+}
+
+func TestValidLanguageTag(t *testing.T) {
+	for _, language := range []string{"en", "EN", "fil", "en-US", "zh-Hant", "zh-Hant-TW", "es-419"} {
+		assert.True(t, validLanguageTag(language), language)
+	}
+
+	for _, language := range []string{"", "e", "english", "en_US", "en-", "-en", "en-toolongsubtag", "en US"} {
+		assert.False(t, validLanguageTag(language), language)
+	}
+}
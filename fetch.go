@@ -0,0 +1,271 @@
+package sentiment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	urlFetchTimeout  = 5 * time.Second
+	urlFetchMaxBytes = maxDocumentBytes
+)
+
+var (
+	errURLNotAllowed      = errors.New("URL is not allowed")
+	errUnsupportedContent = errors.New("unsupported content type")
+	errContentTooLarge    = errors.New("content too large")
+)
+
+// fetchURL downloads the document at the given URL and returns its text content. HTML documents are reduced to
+// their visible text.
+func (svc *Service) fetchURL(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !svc.conf.isURLAllowed(u) {
+		return "", errURLNotAllowed
+	}
+
+	client := svc.conf.urlFetchClient()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		if isURLNotAllowed(err) {
+			return "", errURLNotAllowed
+		}
+		return "", err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("unexpected status fetching %s: %d", u, resp.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/plain" && mediaType != "text/html") {
+		return "", errUnsupportedContent
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, urlFetchMaxBytes+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(body) > urlFetchMaxBytes {
+		return "", errContentTooLarge
+	}
+
+	if mediaType == "text/html" {
+		return extractText(string(body)), nil
+	}
+
+	return string(body), nil
+}
+
+// isURLNotAllowed reports whether a request failed because a redirect or the address it connected to was not allowed
+func isURLNotAllowed(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	return err == errURLNotAllowed
+}
+
+// urlFetchClient returns a copy of the client set with WithHTTPFetchClient, or of a default client which only
+// connects to public addresses, that refuses to follow redirects to URLs that are not allowed
+func (c *config) urlFetchClient() *http.Client {
+	client := &http.Client{
+		Timeout: urlFetchTimeout,
+		// connections are not reused so that every fetch is checked when dialing
+		Transport: &http.Transport{DialContext: c.dialPublic, DisableKeepAlives: true, TLSHandshakeTimeout: urlFetchTimeout},
+	}
+	if c.fetchClient != nil {
+		*client = *c.fetchClient
+	}
+
+	checkRedirect := client.CheckRedirect
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		// redirects must not be used to escape the allowlist
+		if len(via) >= 5 || !c.isURLAllowed(req.URL) {
+			return errURLNotAllowed
+		}
+
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		return nil
+	}
+
+	return client
+}
+
+// privateNetworks are the address ranges, besides loopback and link-local addresses, that are not reachable from the
+// internet and may belong to the infrastructure of the service
+var privateNetworks = parseCIDRs("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// isPublicIP reports whether the address is reachable from the internet
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublic connects to the address only if its host resolves to public addresses, so that clients cannot use the
+// service to reach loopback, private or cloud metadata addresses. Allowlisted hosts may resolve to any address.
+func (c *config) dialPublic(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: urlFetchTimeout}
+	if c.urlAllowedHosts[strings.ToLower(host)] {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ipAddr := range addrs {
+		if !isPublicIP(ipAddr.IP) {
+			return nil, errURLNotAllowed
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	// dial the checked address rather than the host, which could resolve differently the second time
+	return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+}
+
+// fetchContent replaces the URL of the input with the content downloaded from it. If the download fails, an error
+// response is written and false is returned.
+func (svc *Service) fetchContent(w http.ResponseWriter, r *http.Request, inp *input) bool {
+	if !svc.conf.urlFetching {
+		http.Error(w, "URL analysis is not enabled", http.StatusBadRequest)
+		return false
+	}
+
+	if inp.Content != "" {
+		http.Error(w, "Only one of content or url may be specified", http.StatusBadRequest)
+		return false
+	}
+
+	content, err := svc.fetchURL(r.Context(), inp.URL)
+	switch err {
+	case nil:
+		inp.Content = content
+		return true
+	case errURLNotAllowed:
+		svc.logger.Warnw("URL not allowed", "url", inp.URL)
+		http.Error(w, "URL not allowed", http.StatusForbidden)
+	case errUnsupportedContent:
+		svc.logger.Warnw("Unsupported content type", "url", inp.URL)
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+	case errContentTooLarge:
+		svc.logger.Warnw("Content too large", "url", inp.URL)
+		http.Error(w, "Content too large", http.StatusRequestEntityTooLarge)
+	default:
+		svc.logger.Errorw("Failed to fetch URL", "url", inp.URL, "error", err)
+		http.Error(w, "Failed to fetch URL", http.StatusBadGateway)
+	}
+
+	return false
+}
+
+// isURLAllowed checks that the URL uses HTTP(S) and, if an allowlist is configured, that the host is on it. The
+// addresses of hosts that are not on the allowlist are checked when connecting by dialPublic.
+func (c *config) isURLAllowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	if len(c.urlAllowedHosts) == 0 {
+		return true
+	}
+
+	return c.urlAllowedHosts[strings.ToLower(u.Hostname())]
+}
+
+// extractText returns the text content of an HTML document, ignoring scripts and stylesheets. Entities are decoded,
+// other elements are separated by a space unless they are inline, such as <b>, and whitespace is collapsed.
+func extractText(document string) string {
+	var b strings.Builder
+	skip := 0
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(b.String()), " ")
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			if isInvisibleTag(string(name)) {
+				if tokenType == html.StartTagToken {
+					skip++
+				} else if tokenType == html.EndTagToken && skip > 0 {
+					skip--
+				}
+			}
+			if !inlineTags[string(name)] {
+				b.WriteByte(' ')
+			}
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+func isInvisibleTag(name string) bool {
+	return name == "script" || name == "style" || name == "noscript"
+}
+
+// inlineTags are the elements that do not separate the text around them
+var inlineTags = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "cite": true, "code": true, "data": true, "dfn": true,
+	"em": true, "i": true, "kbd": true, "mark": true, "q": true, "s": true, "samp": true, "small": true, "span": true,
+	"strong": true, "sub": true, "sup": true, "time": true, "u": true, "var": true,
+}
@@ -0,0 +1,286 @@
+package sentiment
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestExtractText(t *testing.T) {
+	document := `<html><head><title>Review</title><style>p { color: red; }</style></head>
+<body><p>I love the product.</p><script>var x = "I hate this";</script><p>It is <b>great</b>.</p></body></html>`
+
+	assert.Equal(t, "Review I love the product. It is great.", extractText(document))
+}
+
+func TestURLFetching(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("I love the product."))
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><script>alert("hi")</script><p>I love the product.</p></body></html>`))
+	})
+	mux.HandleFunc("/latin1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=iso-8859-1")
+		w.Write([]byte("J'adore ce produit g\xe9nial."))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte{0x89, 0x50, 0x4e, 0x47})
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("a", urlFetchMaxBytes+1)))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://internal.invalid/secret", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		body           string
+		enabled        bool
+		allowedHosts   []string
+		expectedStatus int
+	}{
+		{
+			name:           "plain_text",
+			body:           `{"url":"` + server.URL + `/plain"}`,
+			enabled:        true,
+			allowedHosts:   []string{"127.0.0.1"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "html",
+			body:           `{"url":"` + server.URL + `/page"}`,
+			enabled:        true,
+			allowedHosts:   []string{"127.0.0.1"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "disabled",
+			body:           `{"url":"` + server.URL + `/plain"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "content_and_url",
+			body:           `{"content":"I love the product.","url":"` + server.URL + `/plain"}`,
+			enabled:        true,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "host_not_allowed",
+			body:           `{"url":"` + server.URL + `/plain"}`,
+			enabled:        true,
+			allowedHosts:   []string{"example.com"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "loopback_not_allowlisted",
+			body:           `{"url":"` + server.URL + `/plain"}`,
+			enabled:        true,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "localhost_not_allowlisted",
+			body:           `{"url":"` + strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + `/plain"}`,
+			enabled:        true,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "metadata_server",
+			body:           `{"url":"http://169.254.169.254/computeMetadata/v1/"}`,
+			enabled:        true,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "redirect_not_allowed",
+			body:           `{"url":"` + server.URL + `/redirect"}`,
+			enabled:        true,
+			allowedHosts:   []string{"127.0.0.1"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unsupported_scheme",
+			body:           `{"url":"file:///etc/passwd"}`,
+			enabled:        true,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unsupported_content_type",
+			body:           `{"url":"` + server.URL + `/image"}`,
+			enabled:        true,
+			allowedHosts:   []string{"127.0.0.1"},
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "too_large",
+			body:           `{"url":"` + server.URL + `/large"}`,
+			enabled:        true,
+			allowedHosts:   []string{"127.0.0.1"},
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "invalid_utf8",
+			body:           `{"url":"` + server.URL + `/latin1"}`,
+			enabled:        true,
+			allowedHosts:   []string{"127.0.0.1"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "not_found",
+			body:           `{"url":"` + server.URL + `/missing"}`,
+			enabled:        true,
+			allowedHosts:   []string{"127.0.0.1"},
+			expectedStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			if tc.enabled {
+				WithURLFetching(tc.allowedHosts...)(svc.conf)
+			}
+
+			isFetchedContent := mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+				return req.GetDocument().GetContent() == "I love the product."
+			})
+			mockClient.On("AnalyzeSentiment", mock.Anything, isFetchedContent, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(tc.body))
+			svc.handleHTTPRequest(responseRecorder, request)
+
+			assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+			if tc.expectedStatus == http.StatusOK {
+				assert.JSONEq(t, `[{"I love the product.":0.9}]`, responseRecorder.Body.String())
+				mockClient.AssertExpectations(t)
+			} else {
+				mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	testCases := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "8.8.8.8", expected: true},
+		{ip: "2001:4860:4860::8888", expected: true},
+		{ip: "127.0.0.1", expected: false},
+		{ip: "::1", expected: false},
+		{ip: "0.0.0.0", expected: false},
+		{ip: "10.1.2.3", expected: false},
+		{ip: "172.16.0.1", expected: false},
+		{ip: "172.32.0.1", expected: true},
+		{ip: "192.168.1.1", expected: false},
+		{ip: "100.64.0.1", expected: false},
+		{ip: "169.254.169.254", expected: false},
+		{ip: "fe80::1", expected: false},
+		{ip: "fd00::1", expected: false},
+		{ip: "::ffff:127.0.0.1", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ip, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isPublicIP(net.ParseIP(tc.ip)))
+		})
+	}
+}
+
+// roundTripperFunc adapts a function to the http.RoundTripper interface
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHTTPFetchClient(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+		},
+	}
+
+	respond := func(req *http.Request, status int, header http.Header, body string) *http.Response {
+		return &http.Response{
+			StatusCode: status,
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}
+	}
+
+	t.Run("injected_client", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithURLFetching()(svc.conf)
+
+		var fetched []string
+		WithHTTPFetchClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			fetched = append(fetched, req.URL.String())
+			return respond(req, http.StatusOK, http.Header{"Content-Type": {"text/plain"}}, "I love the product."), nil
+		})})(svc.conf)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("I love the product."), mock.Anything).Return(apiResponse, nil)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"url":"http://reviews.example.com/1"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+
+		assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+		assert.Equal(t, []string{"http://reviews.example.com/1"}, fetched)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("redirects_checked", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithURLFetching("reviews.example.com")(svc.conf)
+
+		var fetched []string
+		WithHTTPFetchClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			fetched = append(fetched, req.URL.String())
+			return respond(req, http.StatusFound, http.Header{"Location": {"http://internal.example.com/"}}, ""), nil
+		})})(svc.conf)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"url":"http://reviews.example.com/1"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+
+		assert.Equal(t, http.StatusForbidden, responseRecorder.Result().StatusCode)
+		assert.Equal(t, []string{"http://reviews.example.com/1"}, fetched)
+		mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("client_not_modified", func(t *testing.T) {
+		client := &http.Client{}
+		conf := &config{fetchClient: client}
+		assert.NotNil(t, conf.urlFetchClient().CheckRedirect)
+		assert.Nil(t, client.CheckRedirect)
+	})
+}
@@ -0,0 +1,125 @@
+package sentiment
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+const (
+	defaultHealthWindowSize         = 100
+	defaultHealthErrorRateThreshold = 0.5
+	// healthMinSamples is the number of outcomes required before the error rate is considered meaningful
+	healthMinSamples = 10
+)
+
+// healthTracker keeps a rolling window of the outcomes of the most recent calls to the Google API
+type healthTracker struct {
+	mu        sync.Mutex
+	outcomes  []bool
+	next      int
+	count     int
+	failures  int
+	threshold float64
+}
+
+func newHealthTracker(windowSize int, threshold float64) *healthTracker {
+	if windowSize <= 0 {
+		windowSize = defaultHealthWindowSize
+	}
+
+	return &healthTracker{outcomes: make([]bool, windowSize), threshold: threshold}
+}
+
+// record adds an outcome to the window, evicting the oldest one if the window is full
+func (ht *healthTracker) record(success bool) {
+	if ht == nil {
+		return
+	}
+
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	if ht.count == len(ht.outcomes) {
+		if !ht.outcomes[ht.next] {
+			ht.failures--
+		}
+	} else {
+		ht.count++
+	}
+
+	ht.outcomes[ht.next] = success
+	if !success {
+		ht.failures++
+	}
+	ht.next = (ht.next + 1) % len(ht.outcomes)
+}
+
+// status returns the error rate over the window and whether it is within the threshold
+func (ht *healthTracker) status() (float64, bool) {
+	if ht == nil {
+		return 0, true
+	}
+
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	if ht.count == 0 {
+		return 0, true
+	}
+
+	errorRate := float64(ht.failures) / float64(ht.count)
+	return errorRate, ht.count < healthMinSamples || errorRate <= ht.threshold
+}
+
+type healthStatus struct {
+	Status              string  `json:"status"`
+	ErrorRate           float64 `json:"error_rate"`
+	ZeroSentenceResults int64   `json:"zero_sentence_results"`
+}
+
+// handleHealthRequest reports whether the service is healthy based on the recent error rate of the Google API.
+// Unlike /status, it reflects the ability of the service to do useful work.
+func (svc *Service) handleHealthRequest(w http.ResponseWriter, r *http.Request) {
+	errorRate, healthy := svc.health.status()
+
+	resp := healthStatus{Status: "ok", ErrorRate: errorRate, ZeroSentenceResults: svc.ZeroSentenceResults()}
+	status := http.StatusOK
+	if !healthy {
+		resp.Status = "unhealthy"
+		status = http.StatusServiceUnavailable
+	}
+
+	svc.writeResponse(w, r, status, resp)
+}
+
+// liveStatus is the body of the liveness endpoint when enabled with WithStatusBody
+type liveStatus struct {
+	Status string `json:"status"`
+}
+
+// handleStatusRequest answers liveness probes. It never depends on the Google API so that a failing API does not get
+// the service restarted.
+func (svc *Service) handleStatusRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer func() {
+			io.Copy(ioutil.Discard, r.Body)
+			r.Body.Close()
+		}()
+	}
+
+	if svc.conf.strictStatusMethods && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !svc.conf.statusBody {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	svc.writeResponse(w, r, http.StatusOK, liveStatus{Status: "ok"})
+}
@@ -0,0 +1,137 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHealthTracker(t *testing.T) {
+	ht := newHealthTracker(20, 0.5)
+
+	// too few samples to be meaningful
+	for i := 0; i < healthMinSamples-1; i++ {
+		ht.record(false)
+	}
+	errorRate, healthy := ht.status()
+	assert.Equal(t, 1.0, errorRate)
+	assert.True(t, healthy)
+
+	ht.record(false)
+	_, healthy = ht.status()
+	assert.False(t, healthy)
+
+	// successes push the failures out of the window
+	for i := 0; i < 10; i++ {
+		ht.record(true)
+	}
+	errorRate, healthy = ht.status()
+	assert.Equal(t, 0.5, errorRate)
+	assert.True(t, healthy)
+
+	for i := 0; i < 10; i++ {
+		ht.record(true)
+	}
+	errorRate, healthy = ht.status()
+	assert.Equal(t, 0.0, errorRate)
+	assert.True(t, healthy)
+
+	for i := 0; i < 11; i++ {
+		ht.record(false)
+	}
+	errorRate, healthy = ht.status()
+	assert.Equal(t, 0.55, errorRate)
+	assert.False(t, healthy)
+}
+
+func TestHealthEndpoint(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	svc.health = newHealthTracker(10, 0.3)
+
+	failing := mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, fmt.Errorf("error"))
+
+	checkHealth := func() int {
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/health", nil)
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+		return responseRecorder.Result().StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, checkHealth())
+
+	for i := 0; i < 10; i++ {
+		_, err := svc.ProcessSentiment(context.Background(), fmt.Sprintf("input %d", i), Ascending, -1)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, checkHealth())
+
+	// liveness is unaffected
+	responseRecorder := httptest.NewRecorder()
+	svc.RESTHandler().ServeHTTP(responseRecorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+
+	failing.Return(nil, nil)
+	for i := 0; i < 7; i++ {
+		_, err := svc.ProcessSentiment(context.Background(), fmt.Sprintf("other input %d", i), Ascending, -1)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, http.StatusOK, checkHealth())
+}
+
+func TestStatusHTTPRequest(t *testing.T) {
+	testCases := []struct {
+		name           string
+		method         string
+		statusBody     bool
+		strictMethods  bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "get", method: http.MethodGet, expectedStatus: http.StatusOK},
+		{name: "head", method: http.MethodHead, expectedStatus: http.StatusOK},
+		{name: "post_lenient", method: http.MethodPost, expectedStatus: http.StatusOK},
+		{name: "get_body", method: http.MethodGet, statusBody: true, expectedStatus: http.StatusOK, expectedBody: `{"status":"ok"}`},
+		{name: "head_body", method: http.MethodHead, statusBody: true, strictMethods: true, expectedStatus: http.StatusOK},
+		{name: "get_strict", method: http.MethodGet, strictMethods: true, expectedStatus: http.StatusOK},
+		{name: "post_strict", method: http.MethodPost, strictMethods: true, expectedStatus: http.StatusMethodNotAllowed},
+		{name: "delete_strict", method: http.MethodDelete, statusBody: true, strictMethods: true, expectedStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// liveness must not depend on the Google API so no calls are expected
+			mockClient, svc := createMocks(t)
+			svc.conf.statusBody = tc.statusBody
+			svc.conf.strictStatusMethods = tc.strictMethods
+
+			server := httptest.NewServer(svc.RESTHandler())
+			defer server.Close()
+
+			req, err := http.NewRequest(tc.method, server.URL+"/status", nil)
+			assert.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedStatus == http.StatusMethodNotAllowed {
+				assert.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))
+			} else {
+				assert.Equal(t, tc.expectedBody, strings.TrimSpace(string(body)))
+			}
+			if tc.statusBody && tc.expectedStatus == http.StatusOK {
+				assert.Equal(t, contentTypeJSON, resp.Header.Get("Content-Type"))
+			}
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
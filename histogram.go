@@ -0,0 +1,91 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+const (
+	// formatHistogram is the value of the format parameter requesting a histogram of the sentence scores
+	formatHistogram = "histogram"
+	// defaultHistogramBuckets is the number of buckets of a histogram when the request does not specify one
+	defaultHistogramBuckets = 10
+	// maxHistogramBuckets is the maximum number of buckets a request may ask for
+	maxHistogramBuckets = 100
+)
+
+// HistogramResponse is the output type when the sentences are reduced to a histogram of their scores
+type HistogramResponse []HistogramBucket
+
+// HistogramBucket holds the number of sentences with a score within a range. The range includes its lower bound and
+// excludes its upper bound, except for the last bucket which includes both.
+type HistogramBucket struct {
+	Range [2]float32 `json:"range"`
+	Count int        `json:"count"`
+}
+
+// parseHistogramBuckets validates the number of buckets requested for a histogram
+func parseHistogramBuckets(value string) (int, error) {
+	if value == "" {
+		return defaultHistogramBuckets, nil
+	}
+
+	buckets, err := strconv.Atoi(value)
+	if err != nil || buckets < 1 || buckets > maxHistogramBuckets {
+		return 0, fmt.Errorf("buckets must be an integer between 1 and %d", maxHistogramBuckets)
+	}
+
+	return buckets, nil
+}
+
+// scoreHistogram counts the sentences falling into each of n equal ranges of the native score range. Scores outside
+// of the native range are counted in the first or the last bucket.
+func scoreHistogram(sentences []*languagepb.Sentence, n int) HistogramResponse {
+	// the bounds are computed at full precision so that they are exactly the values reported to the client
+	bounds := make([]float32, n+1)
+	for i := range bounds {
+		bounds[i] = float32(float64(nativeScoreMin) + float64(nativeScoreMax-nativeScoreMin)*float64(i)/float64(n))
+	}
+
+	hist := make(HistogramResponse, n)
+	for i := range hist {
+		hist[i].Range = [2]float32{bounds[i], bounds[i+1]}
+	}
+
+	for _, sentence := range sentences {
+		score := sentence.Sentiment.Score
+		i := sort.Search(n, func(i int) bool { return bounds[i+1] > score })
+		if i == n {
+			i = n - 1
+		}
+		hist[i].Count++
+	}
+
+	return hist
+}
+
+// processAPIResultHistogram reduces all the sentences of the result to a histogram of their scores with the given
+// number of buckets
+func (svc *Service) processAPIResultHistogram(ctx context.Context, result *languagepb.AnalyzeSentimentResponse, buckets int) (HistogramResponse, error) {
+	if err := ctx.Err(); err != nil {
+		svc.logger.Errorw("Context cancelled", "error", err)
+		return nil, err
+	}
+
+	for i, sentence := range result.GetSentences() {
+		if sentence.Text == nil || sentence.Sentiment == nil {
+			return nil, fmt.Errorf("malformed sentence at index %d", i)
+		}
+	}
+
+	hist := scoreHistogram(result.GetSentences(), buckets)
+	for i := range hist {
+		hist[i].Range = [2]float32{svc.conf.rescale(hist[i].Range[0]), svc.conf.rescale(hist[i].Range[1])}
+	}
+
+	return hist, nil
+}
@@ -0,0 +1,145 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+var histogramFixture = &languagepb.AnalyzeSentimentResponse{
+	Sentences: []*languagepb.Sentence{
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word1"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 3.0, Score: 0.8},
+		},
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word2"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: 0.8},
+		},
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word3"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 2.2, Score: 0.2},
+		},
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word4"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: -0.8},
+		},
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word5"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: 0.0},
+		},
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word6"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: -1.0},
+		},
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word7"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: 1.0},
+		},
+	},
+}
+
+func TestScoreHistogram(t *testing.T) {
+	hist := scoreHistogram(histogramFixture.Sentences, 10)
+	assert.Len(t, hist, 10)
+	assert.Equal(t, [2]float32{-1, -0.8}, hist[0].Range)
+	assert.Equal(t, [2]float32{0.8, 1}, hist[9].Range)
+
+	// bounds belong to the bucket they start, and the maximum score to the last bucket
+	counts := make([]int, len(hist))
+	total := 0
+	for i, bucket := range hist {
+		counts[i] = bucket.Count
+		total += bucket.Count
+	}
+	assert.Equal(t, []int{1, 1, 0, 0, 0, 1, 1, 0, 0, 3}, counts)
+	assert.Equal(t, len(histogramFixture.Sentences), total)
+
+	hist = scoreHistogram(histogramFixture.Sentences, 1)
+	assert.Equal(t, HistogramResponse{{Range: [2]float32{-1, 1}, Count: len(histogramFixture.Sentences)}}, hist)
+
+	// transformed scores outside of the native range are counted in the outermost buckets
+	hist = scoreHistogram([]*languagepb.Sentence{{Sentiment: &languagepb.Sentiment{Score: 1.5}}, {Sentiment: &languagepb.Sentiment{Score: -2}}}, 4)
+	assert.Equal(t, 1, hist[0].Count)
+	assert.Equal(t, 1, hist[3].Count)
+}
+
+func TestProcessAPIResultHistogram(t *testing.T) {
+	t.Run("scaled", func(t *testing.T) {
+		svc := &Service{conf: &config{scoreScaled: true, scoreMin: 0, scoreMax: 10}, logger: zap.NewNop().Sugar()}
+		hist, err := svc.processAPIResultHistogram(context.Background(), histogramFixture, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, HistogramResponse{{Range: [2]float32{0, 5}, Count: 2}, {Range: [2]float32{5, 10}, Count: 5}}, hist)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		svc := &Service{logger: zap.NewNop().Sugar()}
+		hist, err := svc.processAPIResultHistogram(context.Background(), nil, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, HistogramResponse{{Range: [2]float32{-1, 0}}, {Range: [2]float32{0, 1}}}, hist)
+	})
+
+	t.Run("malformed_sentence", func(t *testing.T) {
+		svc := &Service{logger: zap.NewNop().Sugar()}
+		_, err := svc.processAPIResultHistogram(context.Background(), &languagepb.AnalyzeSentimentResponse{
+			Sentences: []*languagepb.Sentence{{Text: &languagepb.TextSpan{Content: "word1"}}},
+		}, 2)
+		assert.Error(t, err)
+	})
+}
+
+func TestHistogramHTTPRequest(t *testing.T) {
+	testCases := []struct {
+		name            string
+		target          string
+		expectedStatus  int
+		expectedBuckets int
+	}{
+		{name: "default_buckets", target: "/api?format=histogram", expectedStatus: http.StatusOK, expectedBuckets: defaultHistogramBuckets},
+		{name: "buckets", target: "/api?format=histogram&buckets=4", expectedStatus: http.StatusOK, expectedBuckets: 4},
+		{name: "ignores_limit", target: "/api?format=histogram&buckets=5&limit=1&offset=2", expectedStatus: http.StatusOK, expectedBuckets: 5},
+		{name: "zero_buckets", target: "/api?format=histogram&buckets=0", expectedStatus: http.StatusBadRequest},
+		{name: "too_many_buckets", target: "/api?format=histogram&buckets=101", expectedStatus: http.StatusBadRequest},
+		{name: "invalid_buckets", target: "/api?format=histogram&buckets=ten", expectedStatus: http.StatusBadRequest},
+		{name: "invalid_format", target: "/api?format=csv", expectedStatus: http.StatusBadRequest},
+		{name: "with_v2", target: "/api?format=histogram&v=2", expectedStatus: http.StatusBadRequest},
+		{name: "with_aggregate", target: "/api?format=histogram&aggregate=weighted", expectedStatus: http.StatusBadRequest},
+		{name: "with_stream", target: "/api?format=histogram&stream=true", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(histogramFixture, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"word1. word2. word3. word4. word5. word6. word7."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, tc.expectedStatus, result.StatusCode)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var hist HistogramResponse
+			assert.NoError(t, json.NewDecoder(result.Body).Decode(&hist))
+			assert.Len(t, hist, tc.expectedBuckets)
+
+			total := 0
+			for _, bucket := range hist {
+				total += bucket.Count
+			}
+			assert.Equal(t, len(histogramFixture.Sentences), total)
+			assert.Equal(t, "7", result.Header.Get(totalCountHeader))
+		})
+	}
+}
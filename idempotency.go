@@ -0,0 +1,265 @@
+package sentiment
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/allegro/bigcache"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header identifying repeated submissions of the same request
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayHeader is set on responses replayed for a repeated idempotency key
+	idempotentReplayHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength is the longest idempotency key accepted
+	maxIdempotencyKeyLength = 255
+	// idempotencyCacheMB is the size of the cache holding the responses to requests with an idempotency key
+	idempotencyCacheMB = 16
+)
+
+// idempotentResponseHeaders are the headers of a response that are stored for replaying it. The billed units are
+// replayed as zero.
+var idempotentResponseHeaders = append([]string{billableUnitsHeader}, cachedResponseHeaders...)
+
+// idempotencyStore remembers the responses to requests carrying an idempotency key for the configured window. A
+// repeated request waits for the first one with the same key to complete if it is still in progress.
+type idempotencyStore struct {
+	cache   Cache
+	mu      sync.Mutex
+	pending map[string]chan struct{}
+}
+
+// storedResponse is the header line of an idempotency cache entry, which is followed by the uncompressed body of the
+// response. Only the headers describing the content are stored; the rest, such as the encoding and the billed units,
+// belong to the original exchange.
+type storedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+}
+
+func newIdempotencyStore(window time.Duration) (*idempotencyStore, error) {
+	cacheConf := bigcache.DefaultConfig(window)
+	cacheConf.HardMaxCacheSize = idempotencyCacheMB
+	cache, err := bigcache.NewBigCache(cacheConf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create idempotency cache: %+v", err)
+	}
+
+	return &idempotencyStore{cache: cache, pending: make(map[string]chan struct{})}, nil
+}
+
+// begin returns the stored response for the key if there is one. Otherwise it marks the key as in progress and
+// returns a function to call once the response is complete, waiting first for any request already in progress with
+// the same key. An error is returned if the context is done while waiting.
+func (s *idempotencyStore) begin(ctx context.Context, key string) (*storedResponse, []byte, func(), error) {
+	for {
+		if resp, body, ok := s.get(key); ok {
+			return resp, body, nil, nil
+		}
+
+		s.mu.Lock()
+		done, inProgress := s.pending[key]
+		if !inProgress {
+			done = make(chan struct{})
+			s.pending[key] = done
+			s.mu.Unlock()
+
+			return nil, nil, func() {
+				s.mu.Lock()
+				delete(s.pending, key)
+				s.mu.Unlock()
+				close(done)
+			}, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-done:
+			// the first request either stored its response or failed, in which case this one takes over
+		case <-ctx.Done():
+			return nil, nil, nil, ctx.Err()
+		}
+	}
+}
+
+func (s *idempotencyStore) get(key string) (*storedResponse, []byte, bool) {
+	entry, err := s.cache.Get(key)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	// entries are the status and headers encoded as a line of JSON followed by the body
+	i := bytes.IndexByte(entry, '\n')
+	if i < 0 {
+		return nil, nil, false
+	}
+
+	var resp storedResponse
+	if err := json.Unmarshal(entry[:i], &resp); err != nil {
+		return nil, nil, false
+	}
+
+	return &resp, entry[i+1:], true
+}
+
+func (s *idempotencyStore) set(key string, resp *storedResponse, body []byte) error {
+	encoded, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	entry := make([]byte, 0, len(encoded)+1+len(body))
+	entry = append(append(append(entry, encoded...), '\n'), body...)
+	return s.cache.Set(key, entry)
+}
+
+// recordingResponseWriter passes the response through to the client while keeping a copy of it
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingResponseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingResponseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// idempotent wraps an analysis handler so that requests repeating the idempotency key of a successful request made
+// within the window set with WithIdempotencyWindow are answered with the same response, whatever their content,
+// without analyzing anything. Requests without the header are handled as usual.
+func (svc *Service) idempotent(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+		if svc.idempotency == nil || idempotencyKey == "" {
+			handler(w, r)
+			return
+		}
+
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			svc.logger.Warnw("Idempotency key too long", "length", len(idempotencyKey))
+			http.Error(w, fmt.Sprintf("%s may not be longer than %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		// keys are scoped to the tenant and the endpoint so that unrelated clients cannot collide
+		r = svc.withBodyTenant(r)
+		key := tenantFromContext(r.Context()) + "\x00" + r.URL.Path + "\x00" + idempotencyKey
+		stored, body, done, err := svc.idempotency.begin(r.Context(), key)
+		if err != nil {
+			svc.logger.Warnw("Gave up waiting for request with the same idempotency key", "error", err)
+			writeError(w, err)
+			return
+		}
+
+		if stored != nil {
+			for name, values := range stored.Header {
+				w.Header()[name] = values
+			}
+			if _, ok := stored.Header[billableUnitsHeader]; ok {
+				// a replay does not call the Google API
+				w.Header().Set(billableUnitsHeader, "0")
+			}
+			w.Header().Set(idempotentReplayHeader, "true")
+			body = svc.compressResponse(w, r, body)
+			w.WriteHeader(stored.Status)
+			w.Write(body)
+			return
+		}
+		defer done()
+
+		rw := &recordingResponseWriter{ResponseWriter: w}
+		handler(rw, r)
+
+		// only successful responses are replayed so that a failed request can be retried with the same key
+		if rw.status < 200 || rw.status >= 300 {
+			return
+		}
+
+		body, err = decodedBody(w.Header().Get("Content-Encoding"), rw.body.Bytes())
+		if err != nil {
+			svc.logger.Warnw("Failed to decode idempotent response", "error", err)
+			return
+		}
+
+		header := make(http.Header, len(idempotentResponseHeaders))
+		for _, name := range idempotentResponseHeaders {
+			if values, ok := w.Header()[name]; ok {
+				header[name] = values
+			}
+		}
+		if err := svc.idempotency.set(key, &storedResponse{Status: rw.status, Header: header}, body); err != nil {
+			svc.logger.Warnw("Failed to store idempotent response", "error", err)
+		}
+	}
+}
+
+// withBodyTenant returns the request with its tenant in the context, taken from the header or, failing that, from the
+// tenant field of the JSON body. The body is left intact for the handler, which reports any error in it.
+func (svc *Service) withBodyTenant(r *http.Request) *http.Request {
+	tenant := r.Header.Get(TenantHeader)
+	if tenant == "" && r.Body != nil {
+		maxBytes := svc.conf.maxRequestBodyBytes
+		if maxBytes <= 0 {
+			maxBytes = defaultMaxRequestBodyBytes
+		}
+
+		// bodies over the limit are rejected by the handler so reading one byte more than the limit is enough
+		peeked, _ := ioutil.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(peeked), r.Body), r.Body}
+
+		var inp struct {
+			Tenant string `json:"tenant"`
+		}
+		if json.Unmarshal(peeked, &inp) == nil {
+			tenant = inp.Tenant
+		}
+	}
+
+	if tenant == "" {
+		return r
+	}
+	return r.WithContext(WithTenant(r.Context(), tenant))
+}
+
+// decodedBody returns the body of a response without its content encoding
+func decodedBody(encoding string, body []byte) ([]byte, error) {
+	if encoding != "gzip" {
+		return body, nil
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
+}
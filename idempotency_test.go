@@ -0,0 +1,190 @@
+package sentiment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+		},
+	}
+
+	setup := func(t *testing.T) (*mockLanguageClient, *Service) {
+		mockClient, svc := createMocks(t)
+		var err error
+		svc.idempotency, err = newIdempotencyStore(time.Minute)
+		assert.NoError(t, err)
+		return mockClient, svc
+	}
+
+	doRequest := func(svc *Service, target string, key string, body string) *httptest.ResponseRecorder {
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		if key != "" {
+			request.Header.Set(IdempotencyKeyHeader, key)
+		}
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+		return responseRecorder
+	}
+
+	t.Run("repeated_key", func(t *testing.T) {
+		mockClient, svc := setup(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Great."), mock.Anything).Return(apiResponse, nil).Once()
+
+		first := doRequest(svc, "/api", "abc", `{"content":"Great."}`)
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Empty(t, first.Header().Get(idempotentReplayHeader))
+
+		// the content of the repeated request is ignored
+		second := doRequest(svc, "/api", "abc", `{"content":"Something else entirely."}`)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, "true", second.Header().Get(idempotentReplayHeader))
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, first.Header().Get("Content-Type"), second.Header().Get("Content-Type"))
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("trailing_slash", func(t *testing.T) {
+		mockClient, svc := setup(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Great."), mock.Anything).Return(apiResponse, nil).Once()
+
+		first := doRequest(svc, "/api/?no_cache=true", "abc", `{"content":"Great."}`)
+		assert.Equal(t, http.StatusOK, first.Code)
+
+		second := doRequest(svc, "/api/?no_cache=true", "abc", `{"content":"Great."}`)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, "true", second.Header().Get(idempotentReplayHeader))
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("body_tenants_isolated", func(t *testing.T) {
+		mockClient, svc := setup(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		first := doRequest(svc, "/api", "abc", `{"content":"Great.","tenant":"tenantA"}`)
+		assert.Equal(t, http.StatusOK, first.Code)
+
+		// the same key sent by another tenant is a different request
+		second := doRequest(svc, "/api", "abc", `{"content":"Great.","tenant":"tenantB"}`)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Empty(t, second.Header().Get(idempotentReplayHeader))
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 2)
+
+		// the header and the body identify the same tenant
+		third := doRequest(svc, "/api", "abc", `{"content":"Great.","tenant":"tenantA"}`)
+		assert.Equal(t, "true", third.Header().Get(idempotentReplayHeader))
+	})
+
+	t.Run("replayed_headers", func(t *testing.T) {
+		mockClient, svc := setup(t)
+		WithResponseCompression()(svc.conf)
+		content := strings.Repeat("Great", 2000)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+			Sentences: []*languagepb.Sentence{
+				&languagepb.Sentence{
+					Text:      &languagepb.TextSpan{Content: content},
+					Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+				},
+			},
+		}, nil).Once()
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"`+content+`"}`))
+		request.Header.Set(IdempotencyKeyHeader, "abc")
+		request.Header.Set("Accept-Encoding", "gzip")
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+		assert.Equal(t, http.StatusOK, responseRecorder.Code)
+		assert.Equal(t, "gzip", responseRecorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, "10", responseRecorder.Header().Get(billableUnitsHeader))
+
+		// a client that does not accept gzip gets the plain body, and is not billed for the replay
+		replay := doRequest(svc, "/api", "abc", `{"content":"`+content+`"}`)
+		assert.Equal(t, http.StatusOK, replay.Code)
+		assert.Equal(t, "true", replay.Header().Get(idempotentReplayHeader))
+		assert.Empty(t, replay.Header().Get("Content-Encoding"))
+		assert.Equal(t, "0", replay.Header().Get(billableUnitsHeader))
+		assert.Equal(t, "application/json", replay.Header().Get("Content-Type"))
+		assert.JSONEq(t, `[{"`+content+`":0.5}]`, replay.Body.String())
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("different_keys", func(t *testing.T) {
+		mockClient, svc := setup(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		assert.Equal(t, http.StatusOK, doRequest(svc, "/api?no_cache=true", "abc", `{"content":"Great."}`).Code)
+		assert.Equal(t, http.StatusOK, doRequest(svc, "/api?no_cache=true", "def", `{"content":"Great."}`).Code)
+		// the same key on another endpoint is unrelated
+		assert.Equal(t, http.StatusOK, doRequest(svc, "/batch", "abc", `{"documents":[{"content":"Fine."}]}`).Code)
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 3)
+	})
+
+	t.Run("failure_not_replayed", func(t *testing.T) {
+		mockClient, svc := setup(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, assert.AnError).Once()
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil).Once()
+
+		assert.Equal(t, http.StatusInternalServerError, doRequest(svc, "/api", "abc", `{"content":"Great."}`).Code)
+		retried := doRequest(svc, "/api", "abc", `{"content":"Great."}`)
+		assert.Equal(t, http.StatusOK, retried.Code)
+		assert.Empty(t, retried.Header().Get(idempotentReplayHeader))
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("concurrent_duplicates", func(t *testing.T) {
+		mockClient, svc := setup(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).
+			After(50*time.Millisecond).
+			Return(apiResponse, nil).Once()
+
+		results := make([]*httptest.ResponseRecorder, 2)
+		var wg sync.WaitGroup
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = doRequest(svc, "/api", "abc", `{"content":"Great."}`)
+			}(i)
+		}
+		wg.Wait()
+
+		for _, result := range results {
+			assert.Equal(t, http.StatusOK, result.Code)
+		}
+		assert.Equal(t, results[0].Body.String(), results[1].Body.String())
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		doRequest(svc, "/api", "abc", `{"content":"Great."}`)
+		result := doRequest(svc, "/api", "abc", `{"content":"Fine."}`)
+		assert.Empty(t, result.Header().Get(idempotentReplayHeader))
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 2)
+	})
+
+	t.Run("key_too_long", func(t *testing.T) {
+		mockClient, svc := setup(t)
+
+		result := doRequest(svc, "/api", strings.Repeat("a", maxIdempotencyKeyLength+1), `{"content":"Great."}`)
+		assert.Equal(t, http.StatusBadRequest, result.Code)
+		mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,171 @@
+package sentiment
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// DefaultLexicon is a small English lexicon of word sentiment scores in the range [-1, 1]
+var DefaultLexicon = map[string]float32{
+	"amazing":       0.9,
+	"awesome":       0.9,
+	"awful":         -0.9,
+	"bad":           -0.6,
+	"best":          0.8,
+	"broken":        -0.6,
+	"disappointing": -0.7,
+	"excellent":     0.9,
+	"fantastic":     0.9,
+	"good":          0.6,
+	"great":         0.8,
+	"happy":         0.7,
+	"hate":          -0.9,
+	"horrible":      -0.9,
+	"like":          0.4,
+	"love":          0.9,
+	"nice":          0.5,
+	"poor":          -0.6,
+	"sad":           -0.6,
+	"terrible":      -0.9,
+	"ugly":          -0.6,
+	"wonderful":     0.9,
+	"worst":         -0.9,
+}
+
+// LexiconAnalyzer is a simple offline Analyzer that scores each sentence by averaging the scores
+// of the words it contains that appear in a lexicon
+type LexiconAnalyzer struct {
+	lexicon map[string]float32
+}
+
+// NewLexiconAnalyzer creates a new LexiconAnalyzer using the given lexicon. If the lexicon is nil,
+// DefaultLexicon is used.
+func NewLexiconAnalyzer(lexicon map[string]float32) *LexiconAnalyzer {
+	if lexicon == nil {
+		lexicon = DefaultLexicon
+	}
+
+	return &LexiconAnalyzer{lexicon: lexicon}
+}
+
+// AnalyzeSentiment implements the Analyzer interface
+func (la *LexiconAnalyzer) AnalyzeSentiment(ctx context.Context, req *languagepb.AnalyzeSentimentRequest) (*languagepb.AnalyzeSentimentResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	content := req.GetDocument().GetContent()
+	resp := &languagepb.AnalyzeSentimentResponse{DocumentSentiment: &languagepb.Sentiment{}}
+
+	var docScore float32
+	for _, sentence := range splitSentences(content) {
+		sentiment := la.scoreText(sentence.text)
+		resp.Sentences = append(resp.Sentences, &languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: sentence.text, BeginOffset: int32(sentence.offset)},
+			Sentiment: sentiment,
+		})
+		docScore += sentiment.Score
+		resp.DocumentSentiment.Magnitude += sentiment.Magnitude
+	}
+
+	if len(resp.Sentences) > 0 {
+		resp.DocumentSentiment.Score = docScore / float32(len(resp.Sentences))
+	}
+
+	return resp, nil
+}
+
+// lexiconWords splits the text into the lowercase words looked up in a lexicon
+func lexiconWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+}
+
+func (la *LexiconAnalyzer) scoreText(text string) *languagepb.Sentiment {
+	var sum, magnitude float32
+	matches := 0
+	for _, word := range lexiconWords(text) {
+		if score, ok := la.lexicon[word]; ok {
+			sum += score
+			if score < 0 {
+				magnitude -= score
+			} else {
+				magnitude += score
+			}
+			matches++
+		}
+	}
+
+	if matches == 0 {
+		return &languagepb.Sentiment{}
+	}
+
+	return &languagepb.Sentiment{Score: sum / float32(matches), Magnitude: magnitude}
+}
+
+// overrideScore blends the score of a sentence with the average override score of the words of the sentence found
+// in the overrides set with WithLexiconOverrides. The score is returned as is if the sentence has no such words.
+func (c *config) overrideScore(text string, score float32) float32 {
+	if c == nil || len(c.lexiconOverrides) == 0 {
+		return score
+	}
+
+	var sum float32
+	matches := 0
+	for _, word := range lexiconWords(text) {
+		if override, ok := c.lexiconOverrides[word]; ok {
+			sum += override
+			matches++
+		}
+	}
+
+	if matches == 0 {
+		return score
+	}
+
+	return clampScore((score + sum/float32(matches)) / 2)
+}
+
+// clampScore limits the score to the native [-1, 1] range of the Google API
+func clampScore(score float32) float32 {
+	switch {
+	case score < nativeScoreMin:
+		return nativeScoreMin
+	case score > nativeScoreMax:
+		return nativeScoreMax
+	default:
+		return score
+	}
+}
+
+type textSegment struct {
+	text   string
+	offset int
+}
+
+// splitSentences splits the text into sentences terminated by '.', '!' or '?'
+func splitSentences(text string) []textSegment {
+	var segments []textSegment
+	start := 0
+	appendSegment := func(end int) {
+		segment := text[start:end]
+		trimmed := strings.TrimSpace(segment)
+		if trimmed != "" {
+			segments = append(segments, textSegment{text: trimmed, offset: start + strings.Index(segment, trimmed)})
+		}
+		start = end
+	}
+
+	for i, r := range text {
+		if r == '.' || r == '!' || r == '?' {
+			appendSegment(i + 1)
+		}
+	}
+	appendSegment(len(text))
+
+	return segments
+}
@@ -0,0 +1,42 @@
+package sentiment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestLexiconAnalyzer(t *testing.T) {
+	analyzer := NewLexiconAnalyzer(map[string]float32{"love": 0.8, "hate": -0.6, "good": 0.4})
+
+	req := &languagepb.AnalyzeSentimentRequest{
+		Document: &languagepb.Document{
+			Source: &languagepb.Document_Content{
+				Content: "I hate this site! But I love the product, it's good. Meh",
+			},
+			Type: languagepb.Document_PLAIN_TEXT,
+		},
+	}
+
+	resp, err := analyzer.AnalyzeSentiment(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Len(t, resp.Sentences, 3)
+
+	assert.Equal(t, "I hate this site!", resp.Sentences[0].Text.Content)
+	assert.Equal(t, int32(0), resp.Sentences[0].Text.BeginOffset)
+	assert.InDelta(t, -0.6, resp.Sentences[0].Sentiment.Score, 0.0001)
+	assert.InDelta(t, 0.6, resp.Sentences[0].Sentiment.Magnitude, 0.0001)
+
+	assert.Equal(t, "But I love the product, it's good.", resp.Sentences[1].Text.Content)
+	assert.Equal(t, int32(18), resp.Sentences[1].Text.BeginOffset)
+	assert.InDelta(t, 0.6, resp.Sentences[1].Sentiment.Score, 0.0001)
+	assert.InDelta(t, 1.2, resp.Sentences[1].Sentiment.Magnitude, 0.0001)
+
+	assert.Equal(t, "Meh", resp.Sentences[2].Text.Content)
+	assert.Equal(t, float32(0), resp.Sentences[2].Sentiment.Score)
+
+	assert.InDelta(t, 0.0, resp.DocumentSentiment.Score, 0.0001)
+	assert.InDelta(t, 1.8, resp.DocumentSentiment.Magnitude, 0.0001)
+}
@@ -0,0 +1,38 @@
+package sentiment
+
+import (
+	"context"
+)
+
+// callLimiter bounds the number of concurrent calls to the Google API made by the whole service, whichever path
+// they come from
+type callLimiter struct {
+	slots chan struct{}
+}
+
+func newCallLimiter(n int) *callLimiter {
+	return &callLimiter{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a free slot or for the context to be done. A nil limiter never blocks.
+func (l *callLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot obtained with acquire
+func (l *callLimiter) release() {
+	if l == nil {
+		return
+	}
+
+	<-l.slots
+}
@@ -0,0 +1,82 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestCallLimiter(t *testing.T) {
+	l := newCallLimiter(1)
+	assert.NoError(t, l.acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, l.acquire(ctx))
+
+	l.release()
+	assert.NoError(t, l.acquire(context.Background()))
+
+	// a nil limiter never blocks
+	var unlimited *callLimiter
+	assert.NoError(t, unlimited.acquire(context.Background()))
+	unlimited.release()
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	const limit = 2
+
+	mockClient, svc := createMocks(t)
+	svc.conf.batchConcurrency = 4
+	svc.limiter = newCallLimiter(limit)
+
+	var inFlight, maxInFlight, calls int32
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		atomic.AddInt32(&calls, 1)
+	}).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		inputs := make([]string, 4)
+		for j := range inputs {
+			inputs[j] = fmt.Sprintf("batch %d document %d", i, j)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, result := range svc.ProcessBatch(context.Background(), inputs, Descending, -1) {
+				assert.NoError(t, result.Err)
+			}
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := svc.ProcessSentiment(context.Background(), fmt.Sprintf("single document %d", i), Descending, -1)
+			assert.NoError(t, err)
+		}(i)
+	}
+
+	wg.Wait()
+	assert.Equal(t, int32(12), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(limit), atomic.LoadInt32(&maxInFlight))
+}
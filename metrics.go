@@ -0,0 +1,66 @@
+package sentiment
+
+import (
+	"time"
+)
+
+// names of the metrics exported to StatsD
+const (
+	statsdAnalysisLatency = "sentiment.analysis.latency"
+	statsdAnalysisError   = "sentiment.analysis.error"
+	statsdCacheHit        = "sentiment.cache.hit"
+	statsdCacheMiss       = "sentiment.cache.miss"
+)
+
+// StatsDClient is the subset of a StatsD client used to export metrics. Clients of most StatsD libraries can be
+// adapted to it with a few lines of code.
+type StatsDClient interface {
+	// Increment adds one to the named counter
+	Increment(name string)
+	// Timing records a duration for the named timer
+	Timing(name string, value time.Duration)
+}
+
+// metricsSink receives the measurements taken at the instrumentation points of the service. Every metrics backend
+// implements it so that they all observe the same events.
+type metricsSink interface {
+	// analysisDone is called once for every analysis, whether it was served from the cache, the Google API or failed
+	analysisDone(elapsed time.Duration, err error)
+	// cacheLookup is called for every analysis that looks for its result in the cache
+	cacheLookup(hit bool)
+}
+
+// metricsSinks passes the measurements on to each of the configured backends. The empty set discards them.
+type metricsSinks []metricsSink
+
+func (sinks metricsSinks) analysisDone(elapsed time.Duration, err error) {
+	for _, sink := range sinks {
+		sink.analysisDone(elapsed, err)
+	}
+}
+
+func (sinks metricsSinks) cacheLookup(hit bool) {
+	for _, sink := range sinks {
+		sink.cacheLookup(hit)
+	}
+}
+
+// statsdSink exports the measurements as StatsD timers and counters
+type statsdSink struct {
+	client StatsDClient
+}
+
+func (s *statsdSink) analysisDone(elapsed time.Duration, err error) {
+	s.client.Timing(statsdAnalysisLatency, elapsed)
+	if err != nil {
+		s.client.Increment(statsdAnalysisError)
+	}
+}
+
+func (s *statsdSink) cacheLookup(hit bool) {
+	if hit {
+		s.client.Increment(statsdCacheHit)
+	} else {
+		s.client.Increment(statsdCacheMiss)
+	}
+}
@@ -0,0 +1,96 @@
+package sentiment
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// fakeStatsD records the metrics sent to it
+type fakeStatsD struct {
+	mu       sync.Mutex
+	counters map[string]int
+	timings  map[string][]time.Duration
+}
+
+func newFakeStatsD() *fakeStatsD {
+	return &fakeStatsD{counters: make(map[string]int), timings: make(map[string][]time.Duration)}
+}
+
+func (f *fakeStatsD) Increment(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counters[name]++
+}
+
+func (f *fakeStatsD) Timing(name string, value time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.timings[name] = append(f.timings[name], value)
+}
+
+func TestStatsDMetrics(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+		},
+	}
+
+	mockClient, svc := createMocks(t)
+	statsd := newFakeStatsD()
+	WithStatsDClient(statsd)(svc.conf)
+	svc.metrics = metricsSinks{&statsdSink{client: svc.conf.statsdClient}}
+	mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Great."), mock.Anything).
+		After(10*time.Millisecond).
+		Return(apiResponse, nil).Once()
+	mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("Broken."), mock.Anything).Return(nil, assert.AnError).Twice()
+
+	// a miss followed by a hit for the same input
+	for i := 0; i < 2; i++ {
+		_, err := svc.ProcessSentiment(context.Background(), "Great.", Descending, -1)
+		assert.NoError(t, err)
+	}
+
+	_, err := svc.ProcessSentiment(context.Background(), "Broken.", Descending, -1)
+	assert.Error(t, err)
+
+	// fresh results skip the cache lookup
+	_, err = svc.ProcessSentiment(WithCacheBypass(context.Background()), "Broken.", Descending, -1)
+	assert.Error(t, err)
+	mockClient.AssertExpectations(t)
+
+	assert.Equal(t, map[string]int{
+		statsdCacheHit:      1,
+		statsdCacheMiss:     2,
+		statsdAnalysisError: 2,
+	}, statsd.counters)
+	assert.Len(t, statsd.timings, 1)
+	latencies := statsd.timings[statsdAnalysisLatency]
+	assert.Len(t, latencies, 4)
+	assert.True(t, latencies[0] >= 10*time.Millisecond)
+}
+
+func TestMetricsSinks(t *testing.T) {
+	first, second := newFakeStatsD(), newFakeStatsD()
+	sinks := metricsSinks{&statsdSink{client: first}, &statsdSink{client: second}}
+	sinks.cacheLookup(true)
+	sinks.analysisDone(time.Second, nil)
+
+	for _, statsd := range []*fakeStatsD{first, second} {
+		assert.Equal(t, map[string]int{statsdCacheHit: 1}, statsd.counters)
+		assert.Equal(t, map[string][]time.Duration{statsdAnalysisLatency: {time.Second}}, statsd.timings)
+	}
+
+	// without any backend the measurements are discarded
+	var none metricsSinks
+	none.cacheLookup(false)
+	none.analysisDone(time.Second, assert.AnError)
+}
@@ -0,0 +1,91 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+const (
+	defaultNegativeThreshold float32 = -0.25
+	defaultPositiveThreshold float32 = 0.25
+
+	groupByPolarity = "polarity"
+)
+
+// Polarity is an enum defining the sentiment class of a score
+type Polarity int
+
+const (
+	// Neutral sentiment
+	Neutral Polarity = iota
+	// Positive sentiment
+	Positive
+	// Negative sentiment
+	Negative
+)
+
+func (p Polarity) String() string {
+	switch p {
+	case Positive:
+		return "positive"
+	case Negative:
+		return "negative"
+	default:
+		return "neutral"
+	}
+}
+
+// GroupedResponse is the output type when results are grouped by polarity
+type GroupedResponse struct {
+	Positive Response `json:"positive"`
+	Neutral  Response `json:"neutral"`
+	Negative Response `json:"negative"`
+}
+
+// classify determines the polarity of the score using the configured thresholds
+func (c *config) classify(score float32) Polarity {
+	switch {
+	case score < c.negativeThreshold:
+		return Negative
+	case score > c.positiveThreshold:
+		return Positive
+	default:
+		return Neutral
+	}
+}
+
+// processAPIResultByPolarity groups the sentences by polarity, applying the sort order and the limit to each group
+func (svc *Service) processAPIResultByPolarity(ctx context.Context, result *languagepb.AnalyzeSentimentResponse, sortOrder SortOrder, limit int) (*GroupedResponse, error) {
+	if err := ctx.Err(); err != nil {
+		svc.logger.Errorw("Context cancelled", "error", err)
+		return nil, err
+	}
+
+	// incomplete sentences are dropped if configured to, and otherwise rejected like in every other output mode
+	result, _ = svc.conf.dropIncompleteSentences(result)
+	for i, sentence := range result.GetSentences() {
+		if sentence.Text == nil || sentence.Sentiment == nil {
+			return nil, fmt.Errorf("malformed sentence at index %d", i)
+		}
+	}
+
+	groups := make(map[Polarity][]*languagepb.Sentence)
+	for _, sentence := range result.GetSentences() {
+		polarity := svc.conf.classify(sentence.Sentiment.Score)
+		groups[polarity] = append(groups[polarity], sentence)
+	}
+
+	grouped := &GroupedResponse{}
+	for polarity, dest := range map[Polarity]*Response{Positive: &grouped.Positive, Neutral: &grouped.Neutral, Negative: &grouped.Negative} {
+		resp, err := reduceSentences(ctx, groups[polarity], sortOrder, limit)
+		if err != nil {
+			return nil, err
+		}
+		svc.conf.formatResponse(resp)
+		*dest = resp
+	}
+
+	return grouped, nil
+}
@@ -0,0 +1,158 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestProcessAPIResultByPolarity(t *testing.T) {
+	apiResult := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "word1"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 3.0, Score: 0.8},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "word2"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: 0.6},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "word3"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 2.2, Score: 0.2},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "word4"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: -0.8},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "word5"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: 0.0},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name              string
+		negativeThreshold float32
+		positiveThreshold float32
+		sortOrder         SortOrder
+		limit             int
+		expectedResponse  *GroupedResponse
+	}{
+		{
+			name:              "default_thresholds_ascending",
+			negativeThreshold: defaultNegativeThreshold,
+			positiveThreshold: defaultPositiveThreshold,
+			sortOrder:         Ascending,
+			limit:             -1,
+			expectedResponse: &GroupedResponse{
+				Positive: Response([]map[string]float32{
+					map[string]float32{"word2": 0.6},
+					map[string]float32{"word1": 0.8},
+				}),
+				Neutral: Response([]map[string]float32{
+					map[string]float32{"word5": 0.0},
+					map[string]float32{"word3": 0.2},
+				}),
+				Negative: Response([]map[string]float32{
+					map[string]float32{"word4": -0.8},
+				}),
+			},
+		},
+		{
+			name:              "default_thresholds_descending_with_limit",
+			negativeThreshold: defaultNegativeThreshold,
+			positiveThreshold: defaultPositiveThreshold,
+			sortOrder:         Descending,
+			limit:             1,
+			expectedResponse: &GroupedResponse{
+				Positive: Response([]map[string]float32{
+					map[string]float32{"word1": 0.8},
+				}),
+				Neutral: Response([]map[string]float32{
+					map[string]float32{"word3": 0.2},
+				}),
+				Negative: Response([]map[string]float32{
+					map[string]float32{"word4": -0.8},
+				}),
+			},
+		},
+		{
+			name:              "custom_thresholds",
+			negativeThreshold: -0.9,
+			positiveThreshold: 0.1,
+			sortOrder:         Descending,
+			limit:             -1,
+			expectedResponse: &GroupedResponse{
+				Positive: Response([]map[string]float32{
+					map[string]float32{"word1": 0.8},
+					map[string]float32{"word2": 0.6},
+					map[string]float32{"word3": 0.2},
+				}),
+				Neutral: Response([]map[string]float32{
+					map[string]float32{"word5": 0.0},
+					map[string]float32{"word4": -0.8},
+				}),
+				Negative: Response([]map[string]float32{}),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &Service{
+				conf:   &config{negativeThreshold: tc.negativeThreshold, positiveThreshold: tc.positiveThreshold},
+				logger: zap.NewNop().Sugar(),
+			}
+			resp, err := svc.processAPIResultByPolarity(context.Background(), apiResult, tc.sortOrder, tc.limit)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedResponse, resp)
+		})
+	}
+}
+
+func TestPolarityHTTPRequest(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	svc.conf.negativeThreshold = defaultNegativeThreshold
+	svc.conf.positiveThreshold = defaultPositiveThreshold
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "word1"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 3.0, Score: 0.8},
+			},
+		},
+	}, nil)
+
+	t.Run("group_by_polarity", func(t *testing.T) {
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?group=polarity", strings.NewReader(`{"content":"word1"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		result := responseRecorder.Result()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+
+		var output map[string]Response
+		assert.NoError(t, json.NewDecoder(result.Body).Decode(&output))
+		assert.Equal(t, map[string]Response{
+			"positive": Response([]map[string]float32{map[string]float32{"word1": 0.8}}),
+			"neutral":  Response([]map[string]float32{}),
+			"negative": Response([]map[string]float32{}),
+		}, output)
+	})
+
+	t.Run("invalid_group", func(t *testing.T) {
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?group=other", strings.NewReader(`{"content":"word1"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
+	})
+}
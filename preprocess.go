@@ -0,0 +1,81 @@
+package sentiment
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// preprocessSocial is the value of the preprocess query parameter that selects SocialMediaPreprocessor
+const preprocessSocial = "social"
+
+var (
+	urlPattern     = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+	mentionPattern = regexp.MustCompile(`(?:^|\s)@\w+`)
+)
+
+// SocialMediaPreprocessor removes URLs, @mentions and excess whitespace which dilute the sentiment of social
+// media posts
+func SocialMediaPreprocessor(text string) string {
+	text = urlPattern.ReplaceAllString(text, " ")
+	text = mentionPattern.ReplaceAllString(text, " ")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// alignRawText maps each sentence of the analysis of a preprocessed input to the span of the original input that it
+// was derived from. This only works for preprocessors that remove text or change whitespace and case, which covers
+// SocialMediaPreprocessor. Sentences that cannot be found in the original input are left out.
+func alignRawText(raw string, sentences []*languagepb.Sentence) map[*languagepb.Sentence]string {
+	spans := make(map[*languagepb.Sentence]string, len(sentences))
+	pos := 0
+	for _, sentence := range sentences {
+		if sentence == nil || sentence.Text == nil {
+			continue
+		}
+
+		start, end, ok := matchSubsequence(raw, pos, sentence.Text.Content)
+		if !ok {
+			continue
+		}
+
+		spans[sentence] = raw[start:end]
+		pos = end
+	}
+
+	return spans
+}
+
+// matchSubsequence finds the shortest span of raw, starting at or after pos, that contains the non-space characters
+// of text in order, ignoring case. Characters of raw that are not in text are assumed to have been removed.
+func matchSubsequence(raw string, pos int, text string) (start, end int, ok bool) {
+	start = -1
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+
+		for {
+			if pos >= len(raw) {
+				return 0, 0, false
+			}
+
+			c, size := utf8.DecodeRuneInString(raw[pos:])
+			pos += size
+			if unicode.ToLower(c) == unicode.ToLower(r) {
+				if start < 0 {
+					start = pos - size
+				}
+				break
+			}
+		}
+	}
+
+	if start < 0 {
+		return 0, 0, false
+	}
+
+	return start, pos, true
+}
@@ -0,0 +1,240 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestSocialMediaPreprocessor(t *testing.T) {
+	testCases := []struct {
+		tweet    string
+		expected string
+	}{
+		{
+			tweet:    "@acme I love the new release! https://t.co/abc123",
+			expected: "I love the new release!",
+		},
+		{
+			tweet:    "Worst  support ever @acme_help @bob.   See www.example.com/complaint",
+			expected: "Worst support ever . See",
+		},
+		{
+			tweet:    "Email me at someone@example.com, thanks\n\nhttp://example.com",
+			expected: "Email me at someone@example.com, thanks",
+		},
+		{
+			tweet:    "No changes needed",
+			expected: "No changes needed",
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, SocialMediaPreprocessor(tc.tweet))
+	}
+}
+
+func requestFor(content string) *languagepb.AnalyzeSentimentRequest {
+	return &languagepb.AnalyzeSentimentRequest{
+		Document: &languagepb.Document{
+			Source: &languagepb.Document_Content{Content: content},
+			Type:   languagepb.Document_PLAIN_TEXT,
+		},
+	}
+}
+
+func TestTextPreprocessor(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love the new release!"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+		},
+	}
+
+	t.Run("configured_preprocessor", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.preprocessor = SocialMediaPreprocessor
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("I love the new release!"), mock.Anything).Return(apiResponse, nil).Once()
+
+		_, err := svc.ProcessSentiment(context.Background(), "@acme I love the new release! https://t.co/abc123", Ascending, -1)
+		assert.NoError(t, err)
+
+		// a different tweet with the same text is served from the cache
+		_, err = svc.ProcessSentiment(context.Background(), "I love the new release! @other", Ascending, -1)
+		assert.NoError(t, err)
+
+		mockClient.AssertExpectations(t)
+		assert.NotNil(t, svc.getCachedResult(cacheKey(context.Background(), "i love the new release!", svc.analysisParams())))
+	})
+
+	t.Run("preprocess_parameter", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("I love the new release!"), mock.Anything).Return(apiResponse, nil).Once()
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?preprocess=social", strings.NewReader(`{"content":"@acme I love the new release! https://t.co/abc123"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+		assert.JSONEq(t, `[{"I love the new release!":0.9}]`, responseRecorder.Body.String())
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("invalid_preprocess_parameter", func(t *testing.T) {
+		_, svc := createMocks(t)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?preprocess=other", strings.NewReader(`{"content":"word1"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
+	})
+}
+
+func TestHTMLStripper(t *testing.T) {
+	document := `<div class="review"><h2>Great &amp; cheap</h2><p>I <em>love</em> the product.</p>` +
+		`<script>track("I hate this")</script><ul><li>Fast</li><li>Quiet</li></ul><br/>Would buy again.</div>`
+	stripped := "Great & cheap I love the product. Fast Quiet Would buy again."
+
+	t.Run("extracts_text", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithHTMLStripper()(svc.conf)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor(stripped), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		_, err := svc.ProcessSentiment(context.Background(), document, Ascending, -1)
+		assert.NoError(t, err)
+
+		// markup that does not change the text shares the cache entry of the stripped text
+		_, err = svc.ProcessSentiment(context.Background(), "<p>"+stripped+"</p>", Ascending, -1)
+		assert.NoError(t, err)
+
+		mockClient.AssertExpectations(t)
+		assert.NotNil(t, svc.getCachedResult(cacheKey(context.Background(), stripped, svc.analysisParams())))
+	})
+
+	t.Run("before_preprocessor", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithHTMLStripper()(svc.conf)
+		WithTextPreprocessor(strings.ToUpper)(svc.conf)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("I LOVE IT."), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		_, err := svc.ProcessSentiment(context.Background(), "<p>I <b>love</b> it.</p>", Ascending, -1)
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("disabled_by_default", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("<p>I love it.</p>"), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		_, err := svc.ProcessSentiment(context.Background(), "<p>I love it.</p>", Ascending, -1)
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+}
+
+func TestAlignRawText(t *testing.T) {
+	sentence := func(text string) *languagepb.Sentence {
+		return &languagepb.Sentence{Text: &languagepb.TextSpan{Content: text}}
+	}
+
+	testCases := []struct {
+		name     string
+		raw      string
+		analyzed []string
+		expected []string
+	}{
+		{
+			name:     "unchanged",
+			raw:      "I love it. I hate it.",
+			analyzed: []string{"I love it.", "I hate it."},
+			expected: []string{"I love it.", "I hate it."},
+		},
+		{
+			name:     "removed_text",
+			raw:      "@acme Visit www.example.com now!  It is GREAT https://t.co/x",
+			analyzed: []string{"Visit now!", "It is GREAT"},
+			expected: []string{"Visit www.example.com now!", "It is GREAT"},
+		},
+		{
+			name:     "case_and_whitespace",
+			raw:      "I   LOVE\nit.",
+			analyzed: []string{"i love it."},
+			expected: []string{"I   LOVE\nit."},
+		},
+		{
+			name:     "not_found",
+			raw:      "I love it. Bye.",
+			analyzed: []string{"I love it.", "Something else.", "Bye."},
+			expected: []string{"I love it.", "", "Bye."},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sentences := make([]*languagepb.Sentence, len(tc.analyzed))
+			for i, text := range tc.analyzed {
+				sentences[i] = sentence(text)
+			}
+
+			spans := alignRawText(tc.raw, sentences)
+			for i, s := range sentences {
+				assert.Equal(t, tc.expected[i], spans[s], tc.analyzed[i])
+			}
+		})
+	}
+}
+
+func TestRawAndNormalizedTextHTTPRequest(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love the new release!"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Worst support ever ."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.8, Score: -0.8},
+			},
+		},
+	}
+	content := `{"content":"@acme I love the new release! https://t.co/abc123 Worst support ever @acme_help."}`
+
+	t.Run("preprocessed", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("I love the new release! Worst support ever ."), mock.Anything).Return(apiResponse, nil).Once()
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?preprocess=social&v=2&order=document", strings.NewReader(content))
+		svc.handleHTTPRequest(responseRecorder, request)
+		assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+
+		var resp EnrichedResponse
+		assert.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&resp))
+		assert.Len(t, resp.Sentences, 2)
+		assert.Equal(t, "I love the new release!", resp.Sentences[0].NormalizedText)
+		assert.Equal(t, "I love the new release!", resp.Sentences[0].RawText)
+		assert.Equal(t, "Worst support ever .", resp.Sentences[1].NormalizedText)
+		assert.Equal(t, "Worst support ever @acme_help.", resp.Sentences[1].RawText)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("not_preprocessed", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil).Once()
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?v=2", strings.NewReader(content))
+		svc.handleHTTPRequest(responseRecorder, request)
+		assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+		assert.NotContains(t, responseRecorder.Body.String(), "raw_text")
+		assert.NotContains(t, responseRecorder.Body.String(), "normalized_text")
+	})
+}
@@ -0,0 +1,133 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// QuotaExceededError is returned when the quota key of a request has used up its quota of Google API calls
+type QuotaExceededError struct {
+	Key        string
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota of %d requests exceeded for key %q", e.Limit, e.Key)
+}
+
+type quotaKeyKey struct{}
+
+// WithQuotaKey returns a context for which the Google API calls count against the quota of the key set with
+// WithPerKeyQuota. The key must come from a trusted source, such as the authenticated identity of the caller, as a
+// caller choosing its own key would get a fresh quota with every new key.
+func WithQuotaKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, quotaKeyKey{}, key)
+}
+
+// quotaKeyFromContext returns the quota key associated with the context, if any
+func quotaKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(quotaKeyKey{}).(string)
+	return key
+}
+
+// quotaKeyHandler associates each HTTP request with its quota key: the value of the header set by a trusted proxy
+// if one is configured, or the address of the client otherwise. Values chosen by clients, such as the tenant, are
+// never used.
+func quotaKeyHandler(header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := ""
+		if header != "" {
+			key = r.Header.Get(header)
+		}
+		if key == "" {
+			key = r.RemoteAddr
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				key = host
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(WithQuotaKey(r.Context(), key)))
+	})
+}
+
+// quotaTracker counts the successful Google API calls made on behalf of each quota key in fixed windows starting at
+// the first call of the window
+type quotaTracker struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	counters  map[string]*quotaCounter
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+type quotaCounter struct {
+	start time.Time
+	count int
+}
+
+func newQuotaTracker(limit int, window time.Duration) *quotaTracker {
+	return &quotaTracker{limit: limit, window: window, counters: make(map[string]*quotaCounter), now: time.Now}
+}
+
+// check returns a QuotaExceededError if the key has no quota left. Calls are only counted once they succeed, so
+// concurrent calls for a key with one call left may all proceed and exceed the quota by the number of calls in flight.
+func (qt *quotaTracker) check(key string) error {
+	if qt == nil {
+		return nil
+	}
+
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+
+	now := qt.now()
+	counter := qt.counter(key, now)
+	if counter.count >= qt.limit {
+		return &QuotaExceededError{Key: key, Limit: qt.limit, RetryAfter: counter.start.Add(qt.window).Sub(now)}
+	}
+
+	return nil
+}
+
+// charge counts a successful call made on behalf of the key
+func (qt *quotaTracker) charge(key string) {
+	if qt == nil {
+		return
+	}
+
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+
+	qt.counter(key, qt.now()).count++
+}
+
+// counter returns the counter of the current window of the key, starting a new window if the last one has ended
+func (qt *quotaTracker) counter(key string, now time.Time) *quotaCounter {
+	qt.prune(now)
+
+	counter, ok := qt.counters[key]
+	if !ok || now.Sub(counter.start) >= qt.window {
+		counter = &quotaCounter{start: now}
+		qt.counters[key] = counter
+	}
+
+	return counter
+}
+
+// prune removes the counters of expired windows at most once per window so that inactive keys do not accumulate
+func (qt *quotaTracker) prune(now time.Time) {
+	if now.Sub(qt.lastPrune) < qt.window {
+		return
+	}
+
+	for key, counter := range qt.counters {
+		if now.Sub(counter.start) >= qt.window {
+			delete(qt.counters, key)
+		}
+	}
+	qt.lastPrune = now
+}
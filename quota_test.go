@@ -0,0 +1,133 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestQuotaTracker(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	qt := newQuotaTracker(2, time.Hour)
+	qt.now = func() time.Time { return now }
+
+	// checking does not count a call
+	assert.NoError(t, qt.check("keyA"))
+	assert.NoError(t, qt.check("keyA"))
+	assert.NoError(t, qt.check("keyA"))
+	qt.charge("keyA")
+	qt.charge("keyA")
+	qt.charge("keyB")
+
+	now = now.Add(15 * time.Minute)
+	err := qt.check("keyA")
+	assert.IsType(t, &QuotaExceededError{}, err)
+	assert.Equal(t, 45*time.Minute, err.(*QuotaExceededError).RetryAfter)
+	assert.NoError(t, qt.check("keyB"))
+
+	// the quota is replenished when the window ends
+	now = now.Add(45 * time.Minute)
+	assert.NoError(t, qt.check("keyA"))
+	assert.Len(t, qt.counters, 1, "expired counters were not pruned")
+
+	var nilTracker *quotaTracker
+	assert.NoError(t, nilTracker.check("keyA"))
+	nilTracker.charge("keyA")
+}
+
+func TestPerKeyQuotaHTTPRequest(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	svc.quota = newQuotaTracker(2, 24*time.Hour)
+	mockClient.On("AnalyzeSentiment", mock.Anything, requestFor("broken"), mock.Anything).Return(nil, assert.AnError)
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+	handler := svc.RESTHandler()
+
+	send := func(remoteAddr, tenant, content string) *http.Response {
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(fmt.Sprintf(`{"content":%q}`, content)))
+		request.RemoteAddr = remoteAddr
+		request.Header.Set(TenantHeader, tenant)
+		handler.ServeHTTP(responseRecorder, request)
+		return responseRecorder.Result()
+	}
+
+	assert.Equal(t, http.StatusOK, send("10.0.0.1:1234", "tenantA", "one").StatusCode)
+
+	// failed calls do not count against the quota
+	assert.Equal(t, http.StatusInternalServerError, send("10.0.0.1:1234", "tenantA", "broken").StatusCode)
+	assert.Equal(t, http.StatusOK, send("10.0.0.1:1234", "tenantA", "two").StatusCode)
+
+	// cache hits do not count against the quota
+	assert.Equal(t, http.StatusOK, send("10.0.0.1:1234", "tenantA", "one").StatusCode)
+	assert.Equal(t, http.StatusOK, send("10.0.0.1:1234", "tenantA", "two").StatusCode)
+
+	resp := send("10.0.0.1:1234", "tenantA", "three")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "86400", resp.Header.Get("Retry-After"))
+
+	// a client cannot get a fresh quota by choosing another tenant or port
+	assert.Equal(t, http.StatusTooManyRequests, send("10.0.0.1:5678", "tenantB", "three").StatusCode)
+
+	// other clients have their own quota
+	assert.Equal(t, http.StatusOK, send("10.0.0.2:1234", "tenantA", "three").StatusCode)
+	mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 4)
+
+	_, err := svc.ProcessSentiment(WithQuotaKey(context.Background(), "10.0.0.1"), "four", Descending, -1)
+	assert.IsType(t, &QuotaExceededError{}, err)
+	_, err = svc.ProcessSentiment(WithQuotaKey(context.Background(), "10.0.0.3"), "four", Descending, -1)
+	assert.NoError(t, err)
+}
+
+func TestQuotaKeyHeader(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	svc.quota = newQuotaTracker(1, 24*time.Hour)
+	WithQuotaKeyHeader("X-Client-ID")(svc.conf)
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+	handler := svc.RESTHandler()
+
+	send := func(clientID, content string) int {
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(fmt.Sprintf(`{"content":%q}`, content)))
+		request.Header.Set("X-Client-ID", clientID)
+		handler.ServeHTTP(responseRecorder, request)
+		return responseRecorder.Result().StatusCode
+	}
+
+	// all the requests come from the same address, but the proxy identifies different clients
+	assert.Equal(t, http.StatusOK, send("clientA", "one"))
+	assert.Equal(t, http.StatusTooManyRequests, send("clientA", "two"))
+	assert.Equal(t, http.StatusOK, send("clientB", "two"))
+}
+
+func TestQuotaCoalescedCalls(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	svc.quota = newQuotaTracker(1, 24*time.Hour)
+	svc.coalescer = newCoalescer(50 * time.Millisecond)
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).
+		Return(&languagepb.AnalyzeSentimentResponse{}, nil).After(10 * time.Millisecond)
+
+	ctx := WithQuotaKey(context.Background(), "client")
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.ProcessSentiment(ctx, "one", Descending, -1)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// the shared call is charged once
+	mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 1)
+	assert.Equal(t, 1, svc.quota.counters["client"].count)
+}
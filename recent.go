@@ -0,0 +1,102 @@
+package sentiment
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// recentEntry describes a completed analysis retained for debugging
+type recentEntry struct {
+	Time      time.Time `json:"time"`
+	Input     string    `json:"input"`
+	Score     float32   `json:"score"`
+	Magnitude float32   `json:"magnitude"`
+	Sentences int       `json:"sentences"`
+	Cached    bool      `json:"cached"`
+	Degraded  bool      `json:"degraded"`
+}
+
+// recentBuffer retains the most recent analyses in a fixed size ring buffer
+type recentBuffer struct {
+	mu      sync.Mutex
+	entries []recentEntry
+	next    int
+	count   int
+}
+
+func newRecentBuffer(size int) *recentBuffer {
+	return &recentBuffer{entries: make([]recentEntry, size)}
+}
+
+// add records an entry, evicting the oldest one if the buffer is full
+func (rb *recentBuffer) add(entry recentEntry) {
+	if rb == nil {
+		return
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.entries[rb.next] = entry
+	rb.next = (rb.next + 1) % len(rb.entries)
+	if rb.count < len(rb.entries) {
+		rb.count++
+	}
+}
+
+// snapshot returns a copy of the retained entries, newest first
+func (rb *recentBuffer) snapshot() []recentEntry {
+	if rb == nil {
+		return nil
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	entries := make([]recentEntry, rb.count)
+	for i := range entries {
+		entries[i] = rb.entries[(rb.next-1-i+len(rb.entries))%len(rb.entries)]
+	}
+	return entries
+}
+
+// recordRecent adds a completed analysis to the recent results buffer, if enabled
+func (svc *Service) recordRecent(input string, result *languagepb.AnalyzeSentimentResponse, cached, degraded bool) {
+	if svc.recent == nil {
+		return
+	}
+
+	svc.recent.add(recentEntry{
+		Time:      time.Now(),
+		Input:     input,
+		Score:     result.GetDocumentSentiment().GetScore(),
+		Magnitude: result.GetDocumentSentiment().GetMagnitude(),
+		Sentences: len(result.GetSentences()),
+		Cached:    cached,
+		Degraded:  degraded,
+	})
+}
+
+// handleRecentRequest returns the most recent analyses. It is only available when both the buffer and an admin
+// token are configured, and requires the token as a bearer token.
+func (svc *Service) handleRecentRequest(w http.ResponseWriter, r *http.Request) {
+	if svc.recent == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !svc.authorizeAdmin(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	svc.writeResponse(w, r, http.StatusOK, svc.recent.snapshot())
+}
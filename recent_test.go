@@ -0,0 +1,125 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestRecentBuffer(t *testing.T) {
+	rb := newRecentBuffer(3)
+	assert.Empty(t, rb.snapshot())
+
+	for i := 0; i < 5; i++ {
+		rb.add(recentEntry{Input: fmt.Sprintf("input%d", i)})
+	}
+
+	entries := rb.snapshot()
+	inputs := make([]string, len(entries))
+	for i, entry := range entries {
+		inputs[i] = entry.Input
+	}
+	assert.Equal(t, []string{"input4", "input3", "input2"}, inputs)
+
+	var nilBuffer *recentBuffer
+	nilBuffer.add(recentEntry{})
+	assert.Nil(t, nilBuffer.snapshot())
+}
+
+func TestRecentBufferConcurrentWrites(t *testing.T) {
+	rb := newRecentBuffer(10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				rb.add(recentEntry{Input: fmt.Sprintf("%d-%d", i, j)})
+				rb.snapshot()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	entries := rb.snapshot()
+	assert.Len(t, entries, 10)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		assert.NotEmpty(t, entry.Input)
+		assert.False(t, seen[entry.Input], "duplicate entry %s", entry.Input)
+		seen[entry.Input] = true
+	}
+}
+
+func TestRecentHTTPRequest(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		DocumentSentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		bufferSize     int
+		adminToken     string
+		method         string
+		authorization  string
+		expectedStatus int
+	}{
+		{name: "disabled", adminToken: "secret", method: http.MethodGet, authorization: "Bearer secret", expectedStatus: http.StatusNotFound},
+		{name: "no_token_configured", bufferSize: 2, method: http.MethodGet, authorization: "Bearer ", expectedStatus: http.StatusNotFound},
+		{name: "missing_token", bufferSize: 2, adminToken: "secret", method: http.MethodGet, expectedStatus: http.StatusUnauthorized},
+		{name: "wrong_token", bufferSize: 2, adminToken: "secret", method: http.MethodGet, authorization: "Bearer wrong", expectedStatus: http.StatusUnauthorized},
+		{name: "bad_method", bufferSize: 2, adminToken: "secret", method: http.MethodPost, authorization: "Bearer secret", expectedStatus: http.StatusMethodNotAllowed},
+		{name: "authorized", bufferSize: 2, adminToken: "secret", method: http.MethodGet, authorization: "Bearer secret", expectedStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.adminToken = tc.adminToken
+			if tc.bufferSize > 0 {
+				svc.recent = newRecentBuffer(tc.bufferSize)
+			}
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			for _, input := range []string{"One.", "Two.", "Three.", "Three."} {
+				_, err := svc.Analyze(context.Background(), input)
+				assert.NoError(t, err)
+			}
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(tc.method, "/recent", nil)
+			if tc.authorization != "" {
+				request.Header.Set("Authorization", tc.authorization)
+			}
+			svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+			assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+			if tc.expectedStatus == http.StatusOK {
+				var entries []recentEntry
+				assert.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &entries))
+				assert.Len(t, entries, 2)
+				assert.Equal(t, "Three.", entries[0].Input)
+				assert.True(t, entries[0].Cached)
+				assert.Equal(t, "Three.", entries[1].Input)
+				assert.False(t, entries[1].Cached)
+				assert.Equal(t, float32(0.5), entries[1].Score)
+				assert.Equal(t, 1, entries[1].Sentences)
+			}
+		})
+	}
+}
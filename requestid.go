@@ -0,0 +1,59 @@
+package sentiment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDHeader is the HTTP header used to identify a request for end-to-end tracing
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDMetadataKey is the gRPC metadata key used to send the request ID to the Google API
+const requestIDMetadataKey = "x-request-id"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context that propagates the given request ID to the Google API calls made with it
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// requestIDFromContext returns the request ID associated with the context, if any
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// outgoingRequestID attaches the request ID of the context, if any, to the outgoing gRPC metadata
+func outgoingRequestID(ctx context.Context) context.Context {
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		return metadata.AppendToOutgoingContext(ctx, requestIDMetadataKey, requestID)
+	}
+	return ctx
+}
+
+// requestIDHandler associates each request with the ID given in the request header, generating one if it is absent,
+// and echoes the ID in the response
+func requestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), requestID)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,95 @@
+package sentiment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// withRequestIDMetadata matches contexts carrying the given request ID in the outgoing gRPC metadata
+func withRequestIDMetadata(requestID string) interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		md, ok := metadata.FromOutgoingContext(ctx)
+		return ok && len(md.Get(requestIDMetadataKey)) == 1 && md.Get(requestIDMetadataKey)[0] == requestID
+	})
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	t.Run("with_request_id", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", withRequestIDMetadata("abc123"), mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+		_, err := svc.ProcessSentiment(WithRequestID(context.Background(), "abc123"), "Great.", Descending, 0)
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("without_request_id", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.MatchedBy(func(ctx context.Context) bool {
+			_, ok := metadata.FromOutgoingContext(ctx)
+			return !ok
+		}), mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+		_, err := svc.ProcessSentiment(context.Background(), "Great.", Descending, 0)
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+}
+
+func TestRequestIDHTTPRequest(t *testing.T) {
+	t.Run("header", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.requestIDs = true
+		mockClient.On("AnalyzeSentiment", withRequestIDMetadata("abc123"), mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"Great."}`))
+		request.Header.Set(RequestIDHeader, "abc123")
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+		assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+		assert.Equal(t, "abc123", responseRecorder.Result().Header.Get(RequestIDHeader))
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.requestIDs = true
+		var requestID string
+		mockClient.On("AnalyzeSentiment", mock.MatchedBy(func(ctx context.Context) bool {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			if ids := md.Get(requestIDMetadataKey); len(ids) == 1 {
+				requestID = ids[0]
+			}
+			return requestID != ""
+		}), mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"Great."}`))
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+		assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+		assert.Len(t, requestID, 32)
+		assert.Equal(t, requestID, responseRecorder.Result().Header.Get(RequestIDHeader))
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"Great."}`))
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+		assert.Empty(t, responseRecorder.Result().Header.Get(RequestIDHeader))
+	})
+}
@@ -0,0 +1,214 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// ResponseVersion is an enum defining the shape of the output returned by the HTTP API
+type ResponseVersion int
+
+const (
+	// ResponseV1 is a Response, mapping the text of each sentence to its score
+	ResponseV1 ResponseVersion = iota + 1
+	// ResponseV2 is an EnrichedResponse, describing each sentence with named fields
+	ResponseV2
+)
+
+const ellipsis = "\u2026"
+
+// EnrichedResponse is the output type of version 2 of the HTTP API
+type EnrichedResponse struct {
+	Sentences []SentenceResult `json:"sentences"`
+}
+
+// SentenceResult describes the sentiment of a single sentence. When the input was preprocessed before the analysis,
+// NormalizedText is the sentence that was analyzed and RawText is the corresponding part of the original input.
+type SentenceResult struct {
+	Text           string  `json:"text"`
+	Score          float32 `json:"score"`
+	Magnitude      float32 `json:"magnitude"`
+	Label          string  `json:"label"`
+	Offset         int32   `json:"offset"`
+	RawText        string  `json:"raw_text,omitempty"`
+	NormalizedText string  `json:"normalized_text,omitempty"`
+}
+
+// sentenceResultFields are the names of the fields of a SentenceResult that a version 2 response can be projected on
+var sentenceResultFields = map[string]bool{
+	"text": true, "score": true, "magnitude": true, "label": true, "offset": true, "raw_text": true, "normalized_text": true,
+}
+
+// parseFieldProjection parses the comma separated list of fields to include in each sentence of a version 2
+// response. An empty list selects all the fields and is returned as nil.
+func parseFieldProjection(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !sentenceResultFields[field] {
+			return nil, fmt.Errorf("unknown field: %q", field)
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// project returns the response with only the given fields of each sentence. The raw and normalized texts are still
+// left out when the input was not preprocessed.
+func (resp *EnrichedResponse) project(fields []string) map[string][]map[string]interface{} {
+	sentences := make([]map[string]interface{}, len(resp.Sentences))
+	for i, s := range resp.Sentences {
+		projected := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			switch field {
+			case "text":
+				projected[field] = s.Text
+			case "score":
+				projected[field] = s.Score
+			case "magnitude":
+				projected[field] = s.Magnitude
+			case "label":
+				projected[field] = s.Label
+			case "offset":
+				projected[field] = s.Offset
+			case "raw_text":
+				if s.RawText != "" {
+					projected[field] = s.RawText
+				}
+			case "normalized_text":
+				if s.NormalizedText != "" {
+					projected[field] = s.NormalizedText
+				}
+			}
+		}
+		sentences[i] = projected
+	}
+
+	return map[string][]map[string]interface{}{"sentences": sentences}
+}
+
+// formatResponse applies the configured score scale and text length limit to a Response in place
+func (c *config) formatResponse(resp Response) {
+	if c == nil || (!c.scoreScaled && c.maxTextLength <= 0) {
+		return
+	}
+
+	for i, sentence := range resp {
+		formatted := make(map[string]float32, len(sentence))
+		for text, score := range sentence {
+			formatted[c.truncateText(text)] = c.rescale(score)
+		}
+		resp[i] = formatted
+	}
+}
+
+// truncateText shortens text exceeding the configured maximum number of characters, replacing the end with an
+// ellipsis. The text is cut on rune boundaries so that multibyte characters are never split.
+func (c *config) truncateText(text string) string {
+	if c == nil || c.maxTextLength <= 0 || utf8.RuneCountInString(text) <= c.maxTextLength {
+		return text
+	}
+
+	runes := []rune(text)
+	return string(runes[:c.maxTextLength-1]) + ellipsis
+}
+
+// parseResponseVersion parses the version query parameter, falling back to the given default when it is absent
+func parseResponseVersion(v string, defaultVersion ResponseVersion) (ResponseVersion, error) {
+	switch v {
+	case "":
+		return defaultVersion, nil
+	case "1":
+		return ResponseV1, nil
+	case "2":
+		return ResponseV2, nil
+	default:
+		return 0, fmt.Errorf("unknown response version: %s", v)
+	}
+}
+
+// versionedMediaTypePrefix and versionedMediaTypeSuffix enclose the response version in media types of the Accept
+// header, such as application/vnd.sentiment.v2+json
+const (
+	versionedMediaTypePrefix = "application/vnd.sentiment.v"
+	versionedMediaTypeSuffix = "+json"
+)
+
+// isVersionedMediaType reports whether the media type selects a response version
+func isVersionedMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, versionedMediaTypePrefix) && strings.HasSuffix(mediaType, versionedMediaTypeSuffix)
+}
+
+// acceptedResponseVersion returns the response version selected by the first versioned media type of the Accept
+// header, or zero if there is none. An error is returned if the version is not known.
+func acceptedResponseVersion(accept string) (ResponseVersion, error) {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil || params["q"] == "0" || !isVersionedMediaType(mediaType) {
+			continue
+		}
+
+		v := strings.TrimSuffix(strings.TrimPrefix(mediaType, versionedMediaTypePrefix), versionedMediaTypeSuffix)
+		if v == "" {
+			return 0, fmt.Errorf("missing response version: %s", mediaType)
+		}
+		return parseResponseVersion(v, 0)
+	}
+
+	return 0, nil
+}
+
+// processAPIResultV2 sorts and limits the sentences like processAPIResult but produces an EnrichedResponse. If rawTexts
+// is not nil, the input was preprocessed and both the raw and the normalized text of each sentence are included.
+func (svc *Service) processAPIResultV2(ctx context.Context, result *languagepb.AnalyzeSentimentResponse, rawTexts map[*languagepb.Sentence]string, sortOrder SortOrder, limit int) (*EnrichedResponse, error) {
+	if err := ctx.Err(); err != nil {
+		svc.logger.Errorw("Context cancelled", "error", err)
+		return nil, err
+	}
+
+	resp := &EnrichedResponse{Sentences: []SentenceResult{}}
+	if result == nil {
+		return resp, nil
+	}
+
+	for i, sentence := range result.Sentences {
+		if sentence.Text == nil || sentence.Sentiment == nil {
+			return nil, fmt.Errorf("malformed sentence at index %d", i)
+		}
+	}
+
+	sentences := selectSentences(result.Sentences, sortOrder, limit)
+	resp.Sentences = make([]SentenceResult, len(sentences))
+	for i, sentence := range sentences {
+		if i > 0 && i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp.Sentences[i] = SentenceResult{
+			Text:      svc.conf.truncateText(sentence.Text.Content),
+			Score:     svc.conf.rescale(sentence.Sentiment.Score),
+			Magnitude: sentence.Sentiment.Magnitude,
+			Label:     svc.conf.classify(sentence.Sentiment.Score).String(),
+			Offset:    sentence.Text.BeginOffset,
+		}
+
+		if rawTexts != nil {
+			resp.Sentences[i].NormalizedText = svc.conf.truncateText(sentence.Text.Content)
+			resp.Sentences[i].RawText = svc.conf.truncateText(rawTexts[sentence])
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,402 @@
+package sentiment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestResponseVersions(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I hate this site.", BeginOffset: 0},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.8, Score: -0.8},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "But I love the product.", BeginOffset: 18},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "It is blue.", BeginOffset: 42},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.1, Score: 0.0},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		target         string
+		accept         string
+		defaultVersion ResponseVersion
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "default",
+			target:         "/api?order=desc",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"But I love the product.":0.9},{"It is blue.":0},{"I hate this site.":-0.8}]`,
+		},
+		{
+			name:           "v1",
+			target:         "/api?order=desc&v=1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"But I love the product.":0.9},{"It is blue.":0},{"I hate this site.":-0.8}]`,
+		},
+		{
+			name:           "v2",
+			target:         "/api?order=desc&v=2&limit=2",
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"sentences":[` +
+				`{"text":"But I love the product.","score":0.9,"magnitude":0.9,"label":"positive","offset":18},` +
+				`{"text":"It is blue.","score":0,"magnitude":0.1,"label":"neutral","offset":42}]}`,
+		},
+		{
+			name:           "v2_ascending",
+			target:         "/api?v=2&limit=1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"sentences":[{"text":"I hate this site.","score":-0.8,"magnitude":0.8,"label":"negative","offset":0}]}`,
+		},
+		{
+			name:           "configured_default_v2",
+			target:         "/api?limit=1",
+			defaultVersion: ResponseV2,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"sentences":[{"text":"I hate this site.","score":-0.8,"magnitude":0.8,"label":"negative","offset":0}]}`,
+		},
+		{
+			name:           "configured_default_v2_explicit_v1",
+			target:         "/api?order=desc&v=1",
+			defaultVersion: ResponseV2,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"But I love the product.":0.9},{"It is blue.":0},{"I hate this site.":-0.8}]`,
+		},
+		{
+			name:           "unknown_version",
+			target:         "/api?v=3",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "v2_grouped",
+			target:         "/api?v=2&group=polarity",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "accept_v2",
+			target:         "/api?limit=1",
+			accept:         "application/vnd.sentiment.v2+json",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"sentences":[{"text":"I hate this site.","score":-0.8,"magnitude":0.8,"label":"negative","offset":0}]}`,
+		},
+		{
+			name:           "accept_overrides_param",
+			target:         "/api?order=desc&v=2",
+			accept:         "application/vnd.sentiment.v1+json",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"But I love the product.":0.9},{"It is blue.":0},{"I hate this site.":-0.8}]`,
+		},
+		{
+			name:           "accept_unversioned_falls_back_to_param",
+			target:         "/api?v=2&limit=1",
+			accept:         "application/json",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"sentences":[{"text":"I hate this site.","score":-0.8,"magnitude":0.8,"label":"negative","offset":0}]}`,
+		},
+		{
+			name:           "accept_unknown_version",
+			target:         "/api",
+			accept:         "application/vnd.sentiment.v3+json",
+			expectedStatus: http.StatusNotAcceptable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.negativeThreshold = defaultNegativeThreshold
+			svc.conf.positiveThreshold = defaultPositiveThreshold
+			if tc.defaultVersion != 0 {
+				svc.conf.responseVersion = tc.defaultVersion
+			}
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"I hate this site. But I love the product. It is blue."}`))
+			if tc.accept != "" {
+				request.Header.Set("Accept", tc.accept)
+			}
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, tc.expectedStatus, result.StatusCode)
+			if tc.expectedStatus == http.StatusOK {
+				assert.Equal(t, contentTypeJSON, result.Header.Get("Content-Type"))
+				assert.JSONEq(t, tc.expectedBody, responseRecorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestAcceptedResponseVersion(t *testing.T) {
+	testCases := []struct {
+		accept        string
+		expected      ResponseVersion
+		expectedError bool
+	}{
+		{accept: "", expected: 0},
+		{accept: "application/json", expected: 0},
+		{accept: "application/vnd.sentiment.v1+json", expected: ResponseV1},
+		{accept: "application/vnd.sentiment.v2+json", expected: ResponseV2},
+		{accept: "Application/Vnd.Sentiment.V2+JSON", expected: ResponseV2},
+		{accept: "text/html, application/vnd.sentiment.v2+json;q=0.9", expected: ResponseV2},
+		{accept: "application/vnd.sentiment.v2+json;q=0, application/vnd.sentiment.v1+json", expected: ResponseV1},
+		{accept: "application/vnd.sentiment.v3+json", expectedError: true},
+		{accept: "application/vnd.sentiment.v+json", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.accept, func(t *testing.T) {
+			version, err := acceptedResponseVersion(tc.accept)
+			if tc.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, version)
+		})
+	}
+}
+
+func TestFieldProjection(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I hate this site.", BeginOffset: 0},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.8, Score: -0.8},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		target         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "all_fields_by_default",
+			target:         "/api?v=2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"sentences":[{"text":"I hate this site.","score":-0.8,"magnitude":0.8,"label":"negative","offset":0}]}`,
+		},
+		{
+			name:           "text_and_score",
+			target:         "/api?v=2&fields=text,score",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"sentences":[{"text":"I hate this site.","score":-0.8}]}`,
+		},
+		{
+			name:           "case_and_whitespace",
+			target:         "/api?v=2&fields=Label,%20offset",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"sentences":[{"label":"negative","offset":0}]}`,
+		},
+		{
+			name:           "raw_text_without_preprocessing",
+			target:         "/api?v=2&fields=score,raw_text",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"sentences":[{"score":-0.8}]}`,
+		},
+		{
+			name:           "raw_text_with_preprocessing",
+			target:         "/api?v=2&fields=raw_text&preprocess=social",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"sentences":[{"raw_text":"I hate this site."}]}`,
+		},
+		{
+			name:           "unknown_field",
+			target:         "/api?v=2&fields=text,sentiment",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "empty_field",
+			target:         "/api?v=2&fields=text,",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "v1",
+			target:         "/api?fields=text",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.negativeThreshold = defaultNegativeThreshold
+			svc.conf.positiveThreshold = defaultPositiveThreshold
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"I hate this site."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+
+			assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+			if tc.expectedStatus == http.StatusOK {
+				assert.JSONEq(t, tc.expectedBody, responseRecorder.Body.String())
+			}
+		})
+	}
+
+	t.Run("etag_depends_on_fields", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		etag := func(target string) string {
+			responseRecorder := httptest.NewRecorder()
+			svc.handleHTTPRequest(responseRecorder, httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"content":"I hate this site."}`)))
+			return responseRecorder.Result().Header.Get("ETag")
+		}
+		assert.NotEqual(t, etag("/api?v=2&fields=text"), etag("/api?v=2&fields=score"))
+	})
+}
+
+func TestIdenticalSentences(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great.", BeginOffset: 0},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.8, Score: 0.8},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great.", BeginOffset: 7},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.7, Score: 0.7},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		target       string
+		expectedBody string
+	}{
+		{
+			name:         "v1",
+			target:       "/api?order=document",
+			expectedBody: `[{"Great.":0.8},{"Great.":0.7}]`,
+		},
+		{
+			name:   "v2",
+			target: "/api?order=document&v=2",
+			expectedBody: `{"sentences":[` +
+				`{"text":"Great.","score":0.8,"magnitude":0.8,"label":"positive","offset":0},` +
+				`{"text":"Great.","score":0.7,"magnitude":0.7,"label":"positive","offset":7}]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.negativeThreshold = defaultNegativeThreshold
+			svc.conf.positiveThreshold = defaultPositiveThreshold
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"Great. Great."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+
+			// each sentence is a separate element so identical texts must not overwrite each other
+			assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+			assert.JSONEq(t, tc.expectedBody, responseRecorder.Body.String())
+		})
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	testCases := []struct {
+		name      string
+		maxLength int
+		text      string
+		expected  string
+	}{
+		{name: "disabled", maxLength: 0, text: "I love the product.", expected: "I love the product."},
+		{name: "short", maxLength: 20, text: "I love the product.", expected: "I love the product."},
+		{name: "exact", maxLength: 19, text: "I love the product.", expected: "I love the product."},
+		{name: "ascii", maxLength: 10, text: "I love the product.", expected: "I love th…"},
+		{name: "emoji", maxLength: 3, text: "😀😃😄😁", expected: "😀😃…"},
+		{name: "mixed", maxLength: 6, text: "Great 👍🏽 product", expected: "Great…"},
+		{name: "japanese", maxLength: 4, text: "素晴らしい製品です", expected: "素晴ら…"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := &config{maxTextLength: tc.maxLength}
+			truncated := conf.truncateText(tc.text)
+			assert.Equal(t, tc.expected, truncated)
+			assert.True(t, utf8.ValidString(truncated))
+		})
+	}
+}
+
+func TestMaxSentenceTextLength(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I love this product 😍😍😍"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Meh."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.1, Score: 0},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		target       string
+		expectedBody string
+	}{
+		{
+			name:         "v1",
+			target:       "/api?order=desc",
+			expectedBody: `[{"I love this product 😍…":0.9},{"Meh.":0}]`,
+		},
+		{
+			name:   "v2",
+			target: "/api?order=desc&v=2",
+			expectedBody: `{"sentences":[` +
+				`{"text":"I love this product 😍…","score":0.9,"magnitude":0.9,"label":"positive","offset":0},` +
+				`{"text":"Meh.","score":0,"magnitude":0.1,"label":"neutral","offset":0}]}`,
+		},
+		{
+			name:         "grouped",
+			target:       "/api?group=polarity",
+			expectedBody: `{"positive":[{"I love this product 😍…":0.9}],"neutral":[{"Meh.":0}],"negative":[]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.negativeThreshold = defaultNegativeThreshold
+			svc.conf.positiveThreshold = defaultPositiveThreshold
+			WithMaxSentenceTextLength(22)(svc.conf)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"I love this product 😍😍😍 Meh."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+
+			assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+			assert.JSONEq(t, tc.expectedBody, responseRecorder.Body.String())
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package sentiment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/allegro/bigcache"
+)
+
+// cachedResponseHeaders are the headers describing a response that are stored alongside its body
+var cachedResponseHeaders = []string{"Content-Type", emptyHeader, skippedHeader, totalCountHeader}
+
+// responseCache is an optional second tier cache of serialized HTTP responses. Identical requests for hot inputs are
+// answered from it without decoding the cached analysis and reducing it again.
+type responseCache struct {
+	cache *bigcache.BigCache
+}
+
+func newResponseCache(ttl time.Duration, maxSizeMB int) (*responseCache, error) {
+	cacheConf := bigcache.DefaultConfig(ttl)
+	cacheConf.HardMaxCacheSize = maxSizeMB
+	cache, err := bigcache.NewBigCache(cacheConf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create response cache: %+v", err)
+	}
+
+	return &responseCache{cache: cache}, nil
+}
+
+// responseCacheKey derives the key of a response from the tenant of the request and the entity tag of the response,
+// which covers the input and every parameter that affects the output
+func responseCacheKey(ctx context.Context, etag string) string {
+	return tenantFromContext(ctx) + "\x00" + etag
+}
+
+// get returns the headers and the body of the cached response for the key
+func (rc *responseCache) get(key string) (map[string]string, []byte, bool) {
+	if rc == nil {
+		return nil, nil, false
+	}
+
+	entry, err := rc.cache.Get(key)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	// entries are the headers encoded as a line of JSON followed by the body
+	i := bytes.IndexByte(entry, '\n')
+	if i < 0 {
+		return nil, nil, false
+	}
+
+	var header map[string]string
+	if err := json.Unmarshal(entry[:i], &header); err != nil {
+		return nil, nil, false
+	}
+
+	return header, entry[i+1:], true
+}
+
+// set stores the body of a response along with the headers describing it
+func (rc *responseCache) set(key string, header http.Header, body []byte) {
+	if rc == nil {
+		return
+	}
+
+	stored := make(map[string]string, len(cachedResponseHeaders))
+	for _, name := range cachedResponseHeaders {
+		if value := header.Get(name); value != "" {
+			stored[name] = value
+		}
+	}
+
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		return
+	}
+
+	entry := make([]byte, 0, len(encoded)+1+len(body))
+	entry = append(append(append(entry, encoded...), '\n'), body...)
+	rc.cache.Set(key, entry)
+}
+
+// reset removes all the cached responses
+func (rc *responseCache) reset() error {
+	if rc == nil {
+		return nil
+	}
+
+	return rc.cache.Reset()
+}
@@ -0,0 +1,162 @@
+package sentiment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestResponseCache(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I hate this site."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: -0.5},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "But I love the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+		},
+	}
+
+	serve := func(svc *Service, target string, header http.Header) *httptest.ResponseRecorder {
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"content":"I hate this site. But I love the product."}`))
+		for name, values := range header {
+			for _, value := range values {
+				request.Header.Add(name, value)
+			}
+		}
+		svc.handleHTTPRequest(responseRecorder, request)
+		return responseRecorder
+	}
+
+	newService := func(t *testing.T) (*mockLanguageClient, *Service) {
+		mockClient, svc := createMocks(t)
+		responses, err := newResponseCache(time.Minute, 1)
+		assert.NoError(t, err)
+		svc.responses = responses
+		return mockClient, svc
+	}
+
+	t.Run("hit", func(t *testing.T) {
+		mockClient, svc := newService(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil).Once()
+
+		first := serve(svc, "/api?order=desc&limit=1", nil)
+		assert.Equal(t, http.StatusOK, first.Code)
+
+		// drop the analysis so that the second response can only come from the response cache
+		assert.NoError(t, svc.cache.Reset())
+
+		second := serve(svc, "/api?order=desc&limit=1", nil)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.JSONEq(t, `[{"But I love the product.":0.9}]`, second.Body.String())
+		for _, name := range []string{"Content-Type", "ETag", totalCountHeader} {
+			assert.Equal(t, first.Header().Get(name), second.Header().Get(name), name)
+		}
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("parameters_and_tenants_separated", func(t *testing.T) {
+		mockClient, svc := newService(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		assert.JSONEq(t, `[{"But I love the product.":0.9}]`, serve(svc, "/api?order=desc&limit=1", nil).Body.String())
+		assert.JSONEq(t, `[{"I hate this site.":-0.5}]`, serve(svc, "/api?order=asc&limit=1", nil).Body.String())
+		assert.JSONEq(t, `[{"I hate this site.":-0.5}]`, serve(svc, "/api?order=desc&limit=1&offset=1", nil).Body.String())
+		assert.Equal(t, contentTypeMsgpack, serve(svc, "/api?order=desc&limit=1", http.Header{"Accept": {contentTypeMsgpack}}).Header().Get("Content-Type"))
+
+		// each tenant has its own analysis and responses
+		serve(svc, "/api?order=desc&limit=1", http.Header{TenantHeader: {"acme"}})
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 2)
+	})
+
+	t.Run("no_cache_refreshes", func(t *testing.T) {
+		mockClient, svc := newService(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		serve(svc, "/api", nil)
+		serve(svc, "/api?no_cache=true", nil)
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 2)
+	})
+
+	t.Run("degraded_not_cached", func(t *testing.T) {
+		mockClient, svc := newService(t)
+		svc.conf.fallbackAnalyzer = NewLexiconAnalyzer(nil)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("unavailable")).Once()
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil).Once()
+
+		assert.Equal(t, "true", serve(svc, "/api", nil).Header().Get(degradedHeader))
+		assert.Empty(t, serve(svc, "/api", nil).Header().Get(degradedHeader))
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("compressed_hit", func(t *testing.T) {
+		mockClient, svc := newService(t)
+		svc.conf.responseCompression = true
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(syntheticResponse(100), nil).Once()
+
+		first := serve(svc, "/api", http.Header{"Accept-Encoding": {"gzip"}})
+		second := serve(svc, "/api", http.Header{"Accept-Encoding": {"gzip"}})
+		plain := serve(svc, "/api", nil)
+		assert.Equal(t, "gzip", first.Header().Get("Content-Encoding"))
+		assert.Equal(t, "gzip", second.Header().Get("Content-Encoding"))
+		assert.Equal(t, first.Body.Bytes(), second.Body.Bytes())
+		assert.Empty(t, plain.Header().Get("Content-Encoding"))
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("purged", func(t *testing.T) {
+		mockClient, svc := newService(t)
+		svc.conf.adminToken = "secret"
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		serve(svc, "/api", nil)
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodDelete, "/cache", nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+		assert.Equal(t, http.StatusNoContent, responseRecorder.Code)
+
+		serve(svc, "/api", nil)
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 2)
+	})
+}
+
+func BenchmarkResponseCache(b *testing.B) {
+	for _, enabled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("response_cache_%t", enabled), func(b *testing.B) {
+			mockClient, svc := createMocks(b)
+			if enabled {
+				responses, err := newResponseCache(time.Minute, 64)
+				if err != nil {
+					b.Fatal(err)
+				}
+				svc.responses = responses
+			}
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(syntheticResponse(1000), nil).Once()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				responseRecorder := httptest.NewRecorder()
+				request := httptest.NewRequest(http.MethodPost, "/api?order=desc&limit=100", strings.NewReader(`{"content":"hot document"}`))
+				svc.handleHTTPRequest(responseRecorder, request)
+				if responseRecorder.Code != http.StatusOK {
+					b.Fatalf("unexpected status %d", responseRecorder.Code)
+				}
+			}
+		})
+	}
+}
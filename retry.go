@@ -0,0 +1,61 @@
+package sentiment
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// retryBackoff is the pause before retrying a failed call to the Google API
+	retryBackoff = 20 * time.Millisecond
+	// minAttemptTime is the least time that must be left of the request timeout for another attempt to be made
+	minAttemptTime = 50 * time.Millisecond
+)
+
+// isRetryable reports whether a failed call to the Google API may succeed if it is made again
+func isRetryable(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted, codes.Internal:
+			return true
+		}
+	}
+
+	return false
+}
+
+// attemptContext limits an attempt to an equal share of the time left before the deadline of the context, so that a
+// slow attempt leaves time for the remaining ones. The last attempt gets whatever time remains.
+func attemptContext(ctx context.Context, attemptsLeft int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || attemptsLeft <= 1 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(attemptsLeft))
+}
+
+// waitForRetry pauses before the next attempt. It returns false without waiting if the time left before the deadline
+// of the context is too short for another attempt.
+func waitForRetry(ctx context.Context) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < retryBackoff+minAttemptTime {
+		return false
+	}
+
+	timer := time.NewTimer(retryBackoff)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,112 @@
+package sentiment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "unavailable", err: status.Error(codes.Unavailable, "unavailable"), expected: true},
+		{name: "deadline_exceeded", err: status.Error(codes.DeadlineExceeded, "deadline exceeded"), expected: true},
+		{name: "context_deadline_exceeded", err: context.DeadlineExceeded, expected: true},
+		{name: "internal", err: status.Error(codes.Internal, "internal"), expected: true},
+		{name: "invalid_argument", err: status.Error(codes.InvalidArgument, "unsupported language")},
+		{name: "unauthenticated", err: status.Error(codes.Unauthenticated, "invalid credentials")},
+		{name: "cancelled", err: context.Canceled},
+		{name: "other", err: errors.New("error")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isRetryable(tc.err))
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+
+	t.Run("succeeds_after_transient_error", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithRetries(2)(svc.conf)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, unavailable).Once()
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		_, err := svc.ProcessSentiment(context.Background(), "I love the product.", Descending, -1)
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("gives_up_after_max_retries", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithRetries(2)(svc.conf)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, unavailable)
+
+		_, err := svc.ProcessSentiment(context.Background(), "I love the product.", Descending, -1)
+		assert.Equal(t, unavailable, err)
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 3)
+	})
+
+	t.Run("permanent_error_not_retried", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithRetries(2)(svc.conf)
+		invalid := status.Error(codes.InvalidArgument, "unsupported language")
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, invalid)
+
+		_, err := svc.ProcessSentiment(context.Background(), "I love the product.", Descending, -1)
+		assert.Equal(t, invalid, err)
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 1)
+	})
+
+	t.Run("not_retried_by_default", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, unavailable)
+
+		_, err := svc.ProcessSentiment(context.Background(), "I love the product.", Descending, -1)
+		assert.Equal(t, unavailable, err)
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 1)
+	})
+
+	t.Run("retries_stop_within_budget", func(t *testing.T) {
+		const budget = 300 * time.Millisecond
+
+		mockClient, svc := createMocks(t)
+		WithRetries(10)(svc.conf)
+		svc.conf.requestTimeout = budget
+
+		var deadlines []time.Duration
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			// like the gRPC client, the call is abandoned when its context expires
+			ctx := args.Get(0).(context.Context)
+			deadline, _ := ctx.Deadline()
+			deadlines = append(deadlines, time.Until(deadline))
+			select {
+			case <-ctx.Done():
+			case <-time.After(60 * time.Millisecond):
+			}
+		}).Return(nil, unavailable)
+
+		start := time.Now()
+		_, err := svc.ProcessSentiment(context.Background(), "I love the product.", Descending, -1)
+		assert.Equal(t, unavailable, err)
+		assert.True(t, time.Since(start) < budget+20*time.Millisecond, "retried for %v", time.Since(start))
+
+		calls := len(deadlines)
+		assert.True(t, calls >= 2 && calls < 11, "%d attempts", calls)
+		// each attempt gets a share of the time left rather than the whole budget
+		assert.True(t, deadlines[0] < budget/2, "first attempt limited to %v", deadlines[0])
+	})
+}
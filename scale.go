@@ -0,0 +1,62 @@
+package sentiment
+
+import (
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+const (
+	nativeScoreMin float32 = -1
+	nativeScoreMax float32 = 1
+)
+
+// rescale maps a score from the native [-1, 1] range of the Google API to the configured output range
+func (c *config) rescale(score float32) float32 {
+	if c == nil || !c.scoreScaled {
+		return score
+	}
+
+	return c.scoreMin + (score-nativeScoreMin)/(nativeScoreMax-nativeScoreMin)*(c.scoreMax-c.scoreMin)
+}
+
+// invertScore is a score transform reversing the sign convention of the Google API
+func invertScore(score, magnitude float32) float32 {
+	return -score
+}
+
+// transformScores returns a copy of the result with the configured lexicon overrides and score transform applied to
+// the score of each sentence, and the transform applied to the score of the document. The result is returned as is
+// when neither is configured.
+func (c *config) transformScores(result *languagepb.AnalyzeSentimentResponse) *languagepb.AnalyzeSentimentResponse {
+	if c == nil || (c.scoreTransform == nil && len(c.lexiconOverrides) == 0) || result == nil {
+		return result
+	}
+
+	transform := c.scoreTransform
+	if transform == nil {
+		transform = func(score, magnitude float32) float32 { return score }
+	}
+
+	// the result may be shared with the cache so it must not be modified
+	transformed := *result
+	if ds := result.DocumentSentiment; ds != nil {
+		transformed.DocumentSentiment = &languagepb.Sentiment{Score: transform(ds.Score, ds.Magnitude), Magnitude: ds.Magnitude}
+	}
+
+	transformed.Sentences = make([]*languagepb.Sentence, len(result.Sentences))
+	for i, sentence := range result.Sentences {
+		if sentence == nil || sentence.Sentiment == nil {
+			// leave malformed results to be rejected by the reduction
+			transformed.Sentences[i] = sentence
+			continue
+		}
+
+		s := *sentence
+		s.Sentiment = &languagepb.Sentiment{
+			Score:     transform(c.overrideScore(sentence.Text.GetContent(), sentence.Sentiment.Score), sentence.Sentiment.Magnitude),
+			Magnitude: sentence.Sentiment.Magnitude,
+		}
+		transformed.Sentences[i] = &s
+	}
+
+	return &transformed
+}
@@ -0,0 +1,264 @@
+package sentiment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestRescale(t *testing.T) {
+	testCases := []struct {
+		name     string
+		conf     *config
+		score    float32
+		expected float32
+	}{
+		{name: "native", conf: &config{}, score: -0.5, expected: -0.5},
+		{name: "percent_min", conf: &config{scoreScaled: true, scoreMin: 0, scoreMax: 100}, score: -1, expected: 0},
+		{name: "percent_mid", conf: &config{scoreScaled: true, scoreMin: 0, scoreMax: 100}, score: 0, expected: 50},
+		{name: "percent_max", conf: &config{scoreScaled: true, scoreMin: 0, scoreMax: 100}, score: 1, expected: 100},
+		{name: "unit", conf: &config{scoreScaled: true, scoreMin: 0, scoreMax: 1}, score: 0.5, expected: 0.75},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.InDelta(t, tc.expected, tc.conf.rescale(tc.score), 0.0001)
+		})
+	}
+}
+
+func TestScoreScaleOutput(t *testing.T) {
+	apiResult := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I hate this site."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: -0.5},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "But I love the product.", BeginOffset: 18},
+				Sentiment: &languagepb.Sentiment{Magnitude: 1, Score: 1},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "It is blue.", BeginOffset: 42},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0, Score: 0},
+			},
+		},
+	}
+
+	_, svc := createMocks(t)
+	svc.conf.negativeThreshold = defaultNegativeThreshold
+	svc.conf.positiveThreshold = defaultPositiveThreshold
+	WithScoreScale(0, 100)(svc.conf)
+
+	t.Run("order_preserved", func(t *testing.T) {
+		for _, sortOrder := range []SortOrder{Ascending, Descending, DocumentOrder} {
+			native, err := ReduceResponse(apiResult, sortOrder, -1)
+			assert.NoError(t, err)
+
+			scaled, err := svc.processAPIResult(context.Background(), apiResult, sortOrder, -1)
+			assert.NoError(t, err)
+			assert.Len(t, scaled, len(native))
+			for i := range native {
+				for text, score := range native[i] {
+					assert.Contains(t, scaled[i], text)
+					assert.InDelta(t, (score+1)*50, scaled[i][text], 0.0001)
+				}
+			}
+		}
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		resp, err := svc.processAPIResultV2(context.Background(), apiResult, nil, Descending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, []SentenceResult{
+			{Text: "But I love the product.", Score: 100, Magnitude: 1, Label: "positive", Offset: 18},
+			{Text: "It is blue.", Score: 50, Magnitude: 0, Label: "neutral", Offset: 42},
+			{Text: "I hate this site.", Score: 25, Magnitude: 0.5, Label: "negative", Offset: 0},
+		}, resp.Sentences)
+	})
+
+	t.Run("grouped", func(t *testing.T) {
+		resp, err := svc.processAPIResultByPolarity(context.Background(), apiResult, Descending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response([]map[string]float32{map[string]float32{"But I love the product.": 100}}), resp.Positive)
+		assert.Equal(t, Response([]map[string]float32{map[string]float32{"It is blue.": 50}}), resp.Neutral)
+		assert.Equal(t, Response([]map[string]float32{map[string]float32{"I hate this site.": 25}}), resp.Negative)
+	})
+
+	t.Run("http_request", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithScoreScale(0, 1)(svc.conf)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResult, nil)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?order=desc", strings.NewReader(`{"content":"I hate this site. But I love the product. It is blue."}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+
+		assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+		assert.JSONEq(t, `[{"But I love the product.":1},{"It is blue.":0.5},{"I hate this site.":0.25}]`, responseRecorder.Body.String())
+	})
+
+	t.Run("invalid_scale", func(t *testing.T) {
+		_, err := NewService(WithScoreScale(100, 0))
+		assert.Error(t, err)
+	})
+}
+
+func TestScoreTransform(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		DocumentSentiment: &languagepb.Sentiment{Magnitude: 2, Score: 0.25},
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Bad."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 1, Score: -0.75},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Fine."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.25, Score: 0.25},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name      string
+		transform func(score, magnitude float32) float32
+		target    string
+		expected  string
+	}{
+		{
+			name:     "identity",
+			target:   "/api?order=asc",
+			expected: `[{"Bad.":-0.75},{"Fine.":0.25},{"Great.":0.5}]`,
+		},
+		{
+			name:      "inverted_asc",
+			transform: invertScore,
+			target:    "/api?order=asc",
+			expected:  `[{"Great.":-0.5},{"Fine.":-0.25},{"Bad.":0.75}]`,
+		},
+		{
+			name:      "inverted_desc_with_offset",
+			transform: invertScore,
+			target:    "/api?order=desc&offset=1",
+			expected:  `[{"Fine.":-0.25},{"Great.":-0.5}]`,
+		},
+		{
+			name:      "magnitude",
+			transform: func(score, magnitude float32) float32 { return magnitude },
+			target:    "/api?order=desc",
+			expected:  `[{"Bad.":1},{"Great.":0.5},{"Fine.":0.25}]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.scoreTransform = tc.transform
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"Great. Bad. Fine."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+
+			assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+			assert.JSONEq(t, tc.expected, responseRecorder.Body.String())
+		})
+	}
+
+	t.Run("process_sentiment", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.scoreTransform = invertScore
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		resp, err := svc.ProcessSentiment(context.Background(), "Great. Bad. Fine.", Descending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response{{"Bad.": 0.75}, {"Fine.": -0.25}, {"Great.": -0.5}}, resp)
+
+		// the cached result retains the scores reported by the API
+		cached := svc.getCachedResult(cacheKey(context.Background(), "Great. Bad. Fine.", svc.analysisParams()))
+		assert.Equal(t, float32(0.5), cached.Sentences[0].Sentiment.Score)
+		assert.Equal(t, float32(0.25), cached.DocumentSentiment.Score)
+	})
+}
+
+func TestLexiconOverrides(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		DocumentSentiment: &languagepb.Sentiment{Magnitude: 2, Score: -0.25},
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "That trick was SICK!"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: -0.5},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "The food made me sick and the service was meh."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.25, Score: -0.25},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Sickness aside, fine."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+		},
+	}
+	content := "That trick was SICK! The food made me sick and the service was meh. Sickness aside, fine."
+
+	setup := func(t *testing.T, opts ...Option) *Service {
+		mockClient, svc := createMocks(t)
+		// the override of sick is clamped to 1
+		WithLexiconOverrides(map[string]float32{"Sick": 3, "meh": -0.5})(svc.conf)
+		for _, opt := range opts {
+			opt(svc.conf)
+		}
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+		return svc
+	}
+
+	t.Run("process_sentiment", func(t *testing.T) {
+		svc := setup(t)
+		resp, err := svc.ProcessSentiment(context.Background(), content, Ascending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response{
+			{"The food made me sick and the service was meh.": 0},
+			{"That trick was SICK!": 0.25},
+			{"Sickness aside, fine.": 0.5},
+		}, resp)
+
+		// the cached result retains the scores reported by the API
+		cached := svc.getCachedResult(cacheKey(context.Background(), content, svc.analysisParams()))
+		assert.Equal(t, float32(-0.5), cached.Sentences[0].Sentiment.Score)
+	})
+
+	t.Run("with_transform", func(t *testing.T) {
+		svc := setup(t, WithInvertScores())
+		resp, err := svc.ProcessSentiment(context.Background(), content, Ascending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response{
+			{"Sickness aside, fine.": -0.5},
+			{"That trick was SICK!": -0.25},
+			{"The food made me sick and the service was meh.": 0},
+		}, resp)
+	})
+
+	t.Run("http_v2", func(t *testing.T) {
+		svc := setup(t)
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?v=2&order=document&fields=text,score", strings.NewReader(`{"content":"`+content+`"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+
+		assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+		assert.JSONEq(t, `{"sentences":[
+			{"text":"That trick was SICK!","score":0.25},
+			{"text":"The food made me sick and the service was meh.","score":0},
+			{"text":"Sickness aside, fine.","score":0.5}
+		]}`, responseRecorder.Body.String())
+	})
+}
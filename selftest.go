@@ -0,0 +1,70 @@
+package sentiment
+
+import (
+	"net/http"
+	"time"
+)
+
+// selfTestInput is the sample analyzed by the self-test endpoint
+const selfTestInput = "The service is working. Nothing is broken."
+
+// selfTestResult describes the outcome of a self-test
+type selfTestResult struct {
+	OK         bool     `json:"ok"`
+	Sentences  Response `json:"sentences,omitempty"`
+	Degraded   bool     `json:"degraded"`
+	DurationMS float64  `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// handleSelfTestRequest analyzes a fixed sample through the full pipeline to verify that the service can reach the
+// Google API. The cache lookup is bypassed so that every self-test makes a remote call. A result produced by the
+// fallback analyzer is reported as a failure.
+func (svc *Service) handleSelfTestRequest(w http.ResponseWriter, r *http.Request) {
+	if !svc.conf.selfTest {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !svc.authorizeAdmin(w, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := WithCacheBypass(r.Context())
+	start := time.Now()
+	result, degraded, err := svc.analyze(ctx, selfTestInput)
+	duration := time.Since(start)
+
+	out := selfTestResult{
+		Degraded:   degraded,
+		DurationMS: float64(duration) / float64(time.Millisecond),
+	}
+
+	if err == nil {
+		complete, _ := svc.conf.dropIncompleteSentences(result)
+		out.Sentences, err = svc.processAPIResult(ctx, svc.conf.transformScores(complete), DocumentOrder, -1)
+	}
+
+	switch {
+	case err != nil:
+		out.Error = err.Error()
+	case degraded:
+		out.Error = "Google API unavailable, result produced by the fallback analyzer"
+	default:
+		out.OK = true
+	}
+
+	status := http.StatusOK
+	if !out.OK {
+		svc.logger.Errorw("Self-test failed", "error", out.Error, "duration", duration)
+		status = http.StatusBadGateway
+	}
+
+	svc.writeResponse(w, r, status, out)
+}
@@ -0,0 +1,131 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestSelfTestHTTPRequest(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			{Text: &languagepb.TextSpan{Content: "The service is working."}, Sentiment: &languagepb.Sentiment{Score: 0.5}},
+			{Text: &languagepb.TextSpan{Content: "Nothing is broken."}, Sentiment: &languagepb.Sentiment{Score: 0.3}},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		selfTest       bool
+		method         string
+		authorization  string
+		apiErr         error
+		fallback       Analyzer
+		expectCall     bool
+		expectedStatus int
+		expectedOK     bool
+	}{
+		{
+			name:           "disabled",
+			method:         http.MethodPost,
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "unauthorized",
+			selfTest:       true,
+			method:         http.MethodPost,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid_method",
+			selfTest:       true,
+			method:         http.MethodGet,
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "success",
+			selfTest:       true,
+			method:         http.MethodPost,
+			authorization:  "Bearer secret",
+			expectCall:     true,
+			expectedStatus: http.StatusOK,
+			expectedOK:     true,
+		},
+		{
+			name:           "api_failure",
+			selfTest:       true,
+			method:         http.MethodPost,
+			authorization:  "Bearer secret",
+			apiErr:         errors.New("permission denied"),
+			expectCall:     true,
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "api_failure_with_fallback",
+			selfTest:       true,
+			method:         http.MethodPost,
+			authorization:  "Bearer secret",
+			apiErr:         errors.New("permission denied"),
+			fallback:       NewLexiconAnalyzer(nil),
+			expectCall:     true,
+			expectedStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.selfTest = tc.selfTest
+			svc.conf.adminToken = "secret"
+			svc.conf.fallbackAnalyzer = tc.fallback
+
+			// a cached result must not satisfy the self-test
+			entry, err := svc.encodeCacheEntry(apiResponse)
+			assert.NoError(t, err)
+			svc.cache.Set(cacheKey(context.Background(), selfTestInput, svc.analysisParams()), entry)
+
+			if tc.apiErr != nil {
+				mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, tc.apiErr)
+			} else {
+				mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+			}
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(tc.method, "/selftest", nil)
+			if tc.authorization != "" {
+				request.Header.Set("Authorization", tc.authorization)
+			}
+			svc.RESTHandler().ServeHTTP(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, tc.expectedStatus, result.StatusCode)
+			if !tc.expectCall {
+				mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+
+			mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 1)
+
+			var out selfTestResult
+			assert.NoError(t, json.NewDecoder(result.Body).Decode(&out))
+			assert.Equal(t, tc.expectedOK, out.OK)
+			assert.Equal(t, tc.fallback != nil, out.Degraded)
+			assert.True(t, out.DurationMS >= 0)
+			if tc.expectedOK {
+				assert.Empty(t, out.Error)
+				assert.Len(t, out.Sentences, 2)
+			} else {
+				assert.NotEmpty(t, out.Error)
+			}
+		})
+	}
+}
@@ -2,34 +2,124 @@ package sentiment
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
-	language "cloud.google.com/go/language/apiv1"
 	"github.com/allegro/bigcache"
-	"github.com/gogo/protobuf/proto"
 	gax "github.com/googleapis/gax-go"
 	"go.uber.org/zap"
 	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Option defines a configuration option that can be set on the sentiment service
 type Option func(c *config)
 
-// WithRequestTimeout sets the timeout for each request to the sentiment service
+// WithPolarityThresholds sets the score thresholds used to classify sentences. Scores below the negative threshold
+// are negative, scores above the positive threshold are positive and everything in between is neutral.
+func WithPolarityThresholds(negative, positive float32) Option {
+	return func(c *config) {
+		c.negativeThreshold = negative
+		c.positiveThreshold = positive
+	}
+}
+
+// WithScoreTransform sets a function computing the score placed in the output from the score and the magnitude of
+// each sentence reported by the Google API. Sorting, windowing, polarity classification and aggregation all operate on
+// the transformed scores. The default is to use the score reported by the API.
+func WithScoreTransform(transform func(score, magnitude float32) float32) Option {
+	return func(c *config) {
+		c.scoreTransform = transform
+	}
+}
+
+// WithLexiconOverrides adjusts the score of every sentence containing any of the given words, matched as whole words
+// regardless of case, for domain-specific terms the Google API misjudges. The adjusted score is the average of the
+// score reported by the API and the average override score of the words found in the sentence, counting repeated
+// words once per occurrence. Override scores are clamped to [-1, 1]. The adjustment is made before any score transform
+// and leaves magnitudes and the document sentiment unchanged.
+func WithLexiconOverrides(overrides map[string]float32) Option {
+	return func(c *config) {
+		c.lexiconOverrides = make(map[string]float32, len(overrides))
+		for word, score := range overrides {
+			c.lexiconOverrides[strings.ToLower(word)] = clampScore(score)
+		}
+	}
+}
+
+// WithInvertScores reverses the sign of the scores so that negative sentiment has positive scores
+func WithInvertScores() Option {
+	return WithScoreTransform(invertScore)
+}
+
+// WithScoreScale linearly rescales the scores in the output from the native [-1, 1] range of the Google API to the
+// range [min, max]. Sorting and polarity classification operate on the unscaled scores, so the thresholds given to
+// WithPolarityThresholds remain in the native range. Magnitudes are unbounded and are not rescaled.
+func WithScoreScale(min, max float32) Option {
+	return func(c *config) {
+		c.scoreScaled = true
+		c.scoreMin = min
+		c.scoreMax = max
+	}
+}
+
+// WithMaxSentenceTextLength truncates the text of each sentence in the output to at most n characters, ending
+// truncated text with an ellipsis. A value of zero or less disables truncation.
+func WithMaxSentenceTextLength(n int) Option {
+	return func(c *config) {
+		c.maxTextLength = n
+	}
+}
+
+// WithMinTokens rejects inputs with fewer than n whitespace delimited tokens, after preprocessing, with an
+// InputTooShortError instead of calling the remote API. A value of zero or less disables the check.
+func WithMinTokens(n int) Option {
+	return func(c *config) {
+		c.minTokens = n
+	}
+}
+
+// WithRequestIDPropagation associates each HTTP request with the ID in its X-Request-ID header, or a generated ID if
+// the header is absent, and sends it to the Google API as gRPC metadata for end-to-end tracing. Library users can
+// propagate an ID by calling WithRequestID on the context instead.
+func WithRequestIDPropagation() Option {
+	return func(c *config) {
+		c.requestIDs = true
+	}
+}
+
+// WithRequestTimeout sets the timeout for each call to the Google API, including any retries. When analyzing a batch,
+// each document of the batch is given this timeout individually.
 func WithRequestTimeout(timeout time.Duration) Option {
 	return func(c *config) {
 		c.requestTimeout = timeout
 	}
 }
 
+// WithHandlerTimeout sets the overall time budget of an HTTP request, including all the Google API calls made to
+// serve it. For batch requests, this should be larger than the request timeout to allow multiple documents to be
+// analyzed. The default of zero means that HTTP requests are not time limited.
+func WithHandlerTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.handlerTimeout = timeout
+	}
+}
+
 // WithCacheMaxSizeMB sets the maximum amount of memory to allocate for the cache
 func WithCacheMaxSizeMB(maxSize int) Option {
 	return func(c *config) {
@@ -37,6 +127,55 @@ func WithCacheMaxSizeMB(maxSize int) Option {
 	}
 }
 
+// WithCacheCompression compresses the entries stored in the cache, trading CPU time for cache capacity
+func WithCacheCompression() Option {
+	return func(c *config) {
+		c.cacheCompression = true
+	}
+}
+
+// WithCacheHasher sets the hash function used to map cache keys to entries. The cache stores the full key of each
+// entry and treats a key that hashes to another entry as a miss, so collisions never return the wrong result.
+func WithCacheHasher(hasher bigcache.Hasher) Option {
+	return func(c *config) {
+		c.cacheHasher = hasher
+	}
+}
+
+// WithCache replaces the in-memory cache of results with the given cache, such as one shared between instances. If
+// the cache implements CacheValidator, NewService fails unless it validates.
+func WithCache(cache Cache) Option {
+	return func(c *config) {
+		c.cache = cache
+	}
+}
+
+// WithCacheOpTimeout limits the time spent waiting for each operation of the cache set with WithCache, so that a
+// remote cache that hangs does not block requests. A lookup that times out is treated as a miss and a store that
+// times out is logged and skipped. Disabled if zero.
+func WithCacheOpTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.cacheOpTimeout = timeout
+	}
+}
+
+// WithResponseCache enables a second tier cache of at most maxSizeMB holding serialized HTTP responses. Repeated
+// requests for the same input with the same parameters are answered from it without reducing the analysis again.
+// Responses are cached for the same duration as analyses.
+func WithResponseCache(maxSizeMB int) Option {
+	return func(c *config) {
+		c.responseCacheMB = maxSizeMB
+	}
+}
+
+// WithCacheOnly serves results exclusively from the cache without ever calling the remote API. Inputs that are not
+// cached fail with ErrCacheMiss. Combined with ImportCache, this allows load testing the service deterministically.
+func WithCacheOnly() Option {
+	return func(c *config) {
+		c.cacheOnly = true
+	}
+}
+
 // WithCacheEntryTTL sets the life time of a cache entry
 func WithCacheEntryTTL(ttl time.Duration) Option {
 	return func(c *config) {
@@ -44,234 +183,1714 @@ func WithCacheEntryTTL(ttl time.Duration) Option {
 	}
 }
 
-type config struct {
-	requestTimeout time.Duration
-	cacheMaxSizeMB int
-	cacheEntryTTL  time.Duration
+// WithFallbackAnalyzer sets an analyzer to use when the remote API call fails
+func WithFallbackAnalyzer(analyzer Analyzer) Option {
+	return func(c *config) {
+		c.fallbackAnalyzer = analyzer
+	}
 }
 
-// SortOrder is an enum defining the sort order of results
-type SortOrder int
+// WithBatchWindow merges identical requests that arrive within the window of each other into a single call to the
+// remote API. Each call is delayed by up to the window to allow requests to be merged. The merged call is bounded by
+// the request timeout rather than the context of any of the requests.
+func WithBatchWindow(window time.Duration) Option {
+	return func(c *config) {
+		c.batchWindow = window
+	}
+}
 
-const (
-	// Ascending order
-	Ascending SortOrder = iota
-	// Descending order
-	Descending
-)
+// WithSlowRequestThreshold logs a warning for every analysis taking longer than the threshold, including the size of
+// the input and whether the result was served from the cache. Disabled if zero.
+func WithSlowRequestThreshold(threshold time.Duration) Option {
+	return func(c *config) {
+		c.slowThreshold = threshold
+	}
+}
 
-// Response is the expected output type from the service
-type Response []map[string]float32
+// WithRetries retries calls to the Google API that fail with a transient error, such as the API being unavailable,
+// up to n times. The attempts share the request timeout set with WithRequestTimeout: each attempt is limited to an equal
+// share of the time left, the last one getting all of it, and no further attempt is made once too little time is left.
+// The last error is returned if every attempt fails. Calls are not retried by default.
+func WithRetries(n int) Option {
+	return func(c *config) {
+		c.maxRetries = n
+	}
+}
 
-type input struct {
-	Content string `json:"content"`
+// WithBatchConcurrency sets the maximum number of documents of a batch that are processed in parallel
+func WithBatchConcurrency(n int) Option {
+	return func(c *config) {
+		c.batchConcurrency = n
+	}
 }
 
-type languageClient interface {
-	AnalyzeSentiment(context.Context, *languagepb.AnalyzeSentimentRequest, ...gax.CallOption) (*languagepb.AnalyzeSentimentResponse, error)
-	Close() error
+// WithBatchFailFast stops processing a batch after a document fails with an error that would affect every document,
+// such as the Google API rejecting the credentials of the service. The documents that have not been processed yet
+// fail with context.Canceled. By default, every document of a batch is processed regardless of the failures.
+func WithBatchFailFast() Option {
+	return func(c *config) {
+		c.batchFailFast = true
+	}
 }
 
-// Service implements the sentiment analysis API extension
-type Service struct {
-	conf   *config
-	client languageClient
-	cache  *bigcache.BigCache
+// WithMaxBatchSize rejects batch HTTP requests with more than n documents or fields before any of them is analyzed.
+// The default of zero means no limit.
+func WithMaxBatchSize(n int) Option {
+	return func(c *config) {
+		c.maxBatchSize = n
+	}
 }
 
-// NewService creates a new sentiment analysis API extension with the given options
-func NewService(opts ...Option) (*Service, error) {
-	conf := &config{
-		requestTimeout: 1 * time.Second,
-		cacheMaxSizeMB: 64,
-		cacheEntryTTL:  10 * time.Minute,
+// WithMaxConcurrentRequests limits the number of calls to the Google API in flight at any time to n, across single
+// requests, batches and every other path that calls the API. Calls over the limit wait for a free slot. The default of
+// zero means no limit.
+func WithMaxConcurrentRequests(n int) Option {
+	return func(c *config) {
+		c.maxConcurrentCalls = n
 	}
+}
 
-	for _, opt := range opts {
-		opt(conf)
+// WithMaxQueryLength rejects single and batch analysis HTTP requests whose raw query string is longer than n bytes
+// with status 400, before any of it is parsed. The default of zero means no limit.
+func WithMaxQueryLength(n int) Option {
+	return func(c *config) {
+		c.maxQueryLength = n
 	}
+}
 
-	client, err := language.NewClient(context.Background())
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Google language client: %+v", err)
+// WithMaxRequestBodyBytes sets the maximum size of the JSON body of an HTTP request. Larger requests are rejected
+// with status 413.
+func WithMaxRequestBodyBytes(n int64) Option {
+	return func(c *config) {
+		c.maxRequestBodyBytes = n
 	}
+}
 
-	cacheConf := bigcache.DefaultConfig(conf.cacheEntryTTL)
-	cacheConf.HardMaxCacheSize = conf.cacheMaxSizeMB
-	cache, err := bigcache.NewBigCache(cacheConf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cache: %+v", err)
+// WithPerKeyQuota limits the number of successful Google API calls made on behalf of each quota key to n per window.
+// HTTP requests are keyed by the address of the client, or by the header set with WithQuotaKeyHeader, and other
+// callers set the key with WithQuotaKey. Results served from the cache and failed calls do not count against the
+// quota, and a call shared by coalesced requests is only counted once. Requests exceeding the quota fail with a
+// QuotaExceededError. Requests without a key share a single quota.
+func WithPerKeyQuota(n int, window time.Duration) Option {
+	return func(c *config) {
+		c.quotaLimit = n
+		c.quotaWindow = window
 	}
+}
 
-	return &Service{
-		conf:   conf,
-		client: client,
-		cache:  cache,
-	}, nil
+// WithQuotaKeyHeader keys the quota of HTTP requests on the given header instead of the address of the client. The
+// header must be set by trusted infrastructure, such as an authenticating API gateway, that overwrites any value
+// sent by clients.
+func WithQuotaKeyHeader(name string) Option {
+	return func(c *config) {
+		c.quotaKeyHeader = name
+	}
 }
 
-// Close terminates the service
-func (svc *Service) Close() error {
-	if svc.client != nil {
-		return svc.client.Close()
+// WithSkipIncompleteSentences omits sentences that the Google API returned without a sentiment from the output
+// instead of failing the request. The number of omitted sentences is reported in the X-Sentiment-Skipped header.
+func WithSkipIncompleteSentences() Option {
+	return func(c *config) {
+		c.skipIncomplete = true
 	}
-	return nil
 }
 
-// RESTHandler implements the http Handler interface to provide sentiment analysis services
-func (svc *Service) RESTHandler() http.Handler {
-	mux := http.NewServeMux()
-	// api handler
-	mux.HandleFunc("/api", svc.handleHTTPRequest)
-	// health handler for Kubernetes liveness check
-	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		if r.Body != nil {
-			defer func() {
-				io.Copy(ioutil.Discard, r.Body)
-				r.Body.Close()
-			}()
+// WithRecentBufferSize retains the last n analyses for debugging. They are served by the /recent endpoint to clients
+// presenting the token set with WithAdminToken.
+func WithRecentBufferSize(n int) Option {
+	return func(c *config) {
+		c.recentBufferSize = n
+	}
+}
+
+// WithAdminToken sets the bearer token required to access administrative endpoints. Administrative endpoints are
+// unavailable if no token is set.
+func WithAdminToken(token string) Option {
+	return func(c *config) {
+		c.adminToken = token
+	}
+}
+
+// WithSelfTest enables the /selftest endpoint, which analyzes a fixed sample through the Google API to verify
+// credentials and connectivity. Each self-test is billed as an API call. It requires the token set with WithAdminToken.
+func WithSelfTest() Option {
+	return func(c *config) {
+		c.selfTest = true
+	}
+}
+
+// WithMethodOverride allows POST requests to specify one of the given methods in the X-HTTP-Method-Override header
+// to be handled as if they had been sent with that method. Only DELETE is allowed if no methods are given.
+func WithMethodOverride(methods ...string) Option {
+	return func(c *config) {
+		if len(methods) == 0 {
+			methods = []string{http.MethodDelete}
 		}
 
-		w.WriteHeader(http.StatusOK)
-	})
+		c.methodOverrides = make(map[string]bool, len(methods))
+		for _, m := range methods {
+			c.methodOverrides[strings.ToUpper(m)] = true
+		}
+	}
+}
 
-	return mux
+// WithResponseCompression gzip compresses large HTTP response bodies for clients that accept it
+func WithResponseCompression() Option {
+	return func(c *config) {
+		c.responseCompression = true
+	}
 }
 
-func (svc *Service) handleHTTPRequest(w http.ResponseWriter, r *http.Request) {
-	if r.Body != nil {
-		defer func() {
-			io.Copy(ioutil.Discard, r.Body)
-			r.Body.Close()
-		}()
+// WithStatsDClient exports metrics of the analyses to StatsD through the client: the latency of each analysis as the
+// timer sentiment.analysis.latency, failed analyses as the counter sentiment.analysis.error and cache lookups as the
+// counters sentiment.cache.hit and sentiment.cache.miss.
+func WithStatsDClient(client StatsDClient) Option {
+	return func(c *config) {
+		c.statsdClient = client
 	}
+}
 
-	if r.Method != http.MethodPost {
-		zap.S().Warnw("Bad request method")
-		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
-		return
+// WithIdempotencyWindow remembers the responses to successful analysis requests carrying an Idempotency-Key header for
+// the window. Requests repeating the key of such a request within the window, on the same endpoint and for the same
+// tenant, are answered with the remembered response without analyzing their content. A repeated request arriving
+// while the first one is still being processed waits for it. Disabled if zero.
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(c *config) {
+		c.idempotencyWindow = window
 	}
+}
 
-	var inp input
-	if err := json.NewDecoder(r.Body).Decode(&inp); err != nil {
-		zap.S().Errorw("Failed to parse request body", "error", err)
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		return
+// WithRawUTF8Output writes the characters <, > and & as is in JSON responses instead of escaping them as \u003c,
+// \u003e and \u0026, which keeps sentence texts readable for clients that do not embed the output in HTML. Other
+// non-ASCII characters are always written as UTF-8.
+func WithRawUTF8Output() Option {
+	return func(c *config) {
+		c.rawUTF8Output = true
 	}
+}
 
-	params := r.URL.Query()
+// WithPutAsPost allows PUT to be used as an alias for POST on the analysis endpoint
+func WithPutAsPost() Option {
+	return func(c *config) {
+		c.allowPut = true
+	}
+}
 
-	sortOrder := Ascending
-	if so := params.Get("order"); so != "" && strings.ToLower(so) == "desc" {
-		sortOrder = Descending
+// WithDebugMode allows clients to request the raw response of the remote API using the debug parameter
+func WithDebugMode() Option {
+	return func(c *config) {
+		c.debugMode = true
 	}
+}
 
-	limit := -1
-	if l := params.Get("limit"); l != "" {
-		limitVal, err := strconv.Atoi(l)
-		if err != nil {
-			zap.S().Warnw("Invalid limit parameter", "limit", l, "error", err)
-		} else {
-			limit = limitVal
+// WithDefaultResponseVersion sets the shape of the output returned when clients do not specify the v parameter.
+// Version 2 names each field and is easier to consume from strongly-typed clients.
+func WithDefaultResponseVersion(version ResponseVersion) Option {
+	return func(c *config) {
+		c.responseVersion = version
+	}
+}
+
+// WithURLFetching allows clients to submit a URL instead of the content to analyze. The service downloads plain
+// text and HTML documents from the URL. If any hosts are given, only URLs pointing to those hosts are fetched. Hosts
+// that are not given are refused if they resolve to loopback, private or link-local addresses.
+func WithURLFetching(allowedHosts ...string) Option {
+	return func(c *config) {
+		c.urlFetching = true
+		c.urlAllowedHosts = make(map[string]bool, len(allowedHosts))
+		for _, host := range allowedHosts {
+			c.urlAllowedHosts[strings.ToLower(host)] = true
 		}
 	}
+}
 
-	resp, err := svc.ProcessSentiment(r.Context(), inp.Content, sortOrder, limit)
-	if err != nil {
-		zap.S().Errorw("Request failed", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
-		return
+// WithStrictParams rejects requests that repeat the order or limit query parameters with 400 Bad Request. By
+// default the first value of a repeated parameter is used and the others are ignored.
+func WithStrictParams() Option {
+	return func(c *config) {
+		c.strictParams = true
 	}
+}
 
-	w.Header().Add("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		zap.S().Errorw("Failed to marshal response", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
-		return
+// WithHTTPFetchClient sets the HTTP client used to download documents when URL fetching is enabled, for example
+// to use a proxy or a different timeout. Redirects to URLs that are not allowed are refused regardless of the client,
+// but the client is responsible for refusing to connect to private addresses. A client with a 5 second timeout which
+// only connects to public addresses, unless the host is allowlisted, is used by default.
+func WithHTTPFetchClient(client *http.Client) Option {
+	return func(c *config) {
+		c.fetchClient = client
 	}
 }
 
-// ProcessSentiment implements the logic of processing a sentiment analysis request
-func (svc *Service) ProcessSentiment(ctx context.Context, input string, sort SortOrder, limit int) (Response, error) {
-	if err := ctx.Err(); err != nil {
-		zap.S().Warnw("Context cancelled", "error", err, "input", input)
-		return nil, err
+// WithRejectEmptyResults responds with 422 Unprocessable Entity instead of an empty result when the remote API
+// finds no sentences in the input
+func WithRejectEmptyResults() Option {
+	return func(c *config) {
+		c.rejectEmpty = true
+	}
+}
+
+// WithEmptyResultStatus sets the status of HTTP responses when the remote API finds no sentences in the input. The
+// default, http.StatusOK, returns an empty result while http.StatusNoContent returns no body. Other statuses are not
+// supported. WithRejectEmptyResults takes precedence.
+func WithEmptyResultStatus(status int) Option {
+	return func(c *config) {
+		c.emptyStatus = status
 	}
+}
 
-	sanitizedInput := strings.ToLower(strings.TrimSpace(input))
+// WithCredentialsJSON sets the service account or refresh token JSON used to authenticate with Google instead of
+// relying on application default credentials
+func WithCredentialsJSON(credentialsJSON []byte) Option {
+	return func(c *config) {
+		c.credentialsJSON = credentialsJSON
+	}
+}
 
-	// if the result is already in the cache, skip the remote API call
-	if cachedResult := svc.getCachedResult(sanitizedInput); cachedResult != nil {
-		return svc.processAPIResult(ctx, cachedResult, sort, limit)
+// WithQuotaProject sets the project that is billed and used for quota when calling the Google API
+func WithQuotaProject(project string) Option {
+	return func(c *config) {
+		c.quotaProject = project
 	}
+}
 
-	// make the remote API call
-	resp, err := svc.client.AnalyzeSentiment(ctx, &languagepb.AnalyzeSentimentRequest{
-		Document: &languagepb.Document{
-			Source: &languagepb.Document_Content{
-				Content: input,
-			},
-			Type: languagepb.Document_PLAIN_TEXT,
-		},
-	})
+// WithAutoChunk enables splitting documents larger than maxChunkBytes into chunks at paragraph or sentence
+// boundaries which are analyzed separately and merged into a single result. If maxChunkBytes is not positive, the
+// document size limit of the Google API is used, and it is raised to utf8.UTFMax so that every rune fits in a chunk.
+// The document level sentiment is omitted from chunked results.
+func WithAutoChunk(maxChunkBytes int) Option {
+	return func(c *config) {
+		c.autoChunk = true
+		c.maxChunkBytes = maxChunkBytes
+		if c.maxChunkBytes <= 0 {
+			c.maxChunkBytes = maxDocumentBytes
+		} else if c.maxChunkBytes < utf8.UTFMax {
+			c.maxChunkBytes = utf8.UTFMax
+		}
+	}
+}
 
-	if err != nil {
-		zap.S().Errorw("Remote API call failure", "error", err, "input", input)
-		return nil, err
+// WithAPIVersion sets the version of the Google language API to use
+func WithAPIVersion(version APIVersion) Option {
+	return func(c *config) {
+		c.apiVersion = version
 	}
+}
 
-	// save the result in the cache
-	if respBytes, err := proto.Marshal(resp); err == nil {
-		svc.cache.Set(sanitizedInput, respBytes)
+// WithDefaultLanguage sets the language of the inputs sent to the Google API, as a BCP 47 language tag such as "en" or
+// "pt-BR". The API detects the language of each input if no default is set. Language hints given with
+// WithLanguageHints take precedence over the default.
+func WithDefaultLanguage(language string) Option {
+	return func(c *config) {
+		c.defaultLanguage = language
 	}
+}
 
-	return svc.processAPIResult(ctx, resp, sort, limit)
+// WithTextPreprocessor sets a function applied to every input before it is analyzed. The preprocessed text is
+// what gets sent to the Google API and cached.
+func WithTextPreprocessor(preprocessor func(string) string) Option {
+	return func(c *config) {
+		c.preprocessor = preprocessor
+	}
 }
 
-func (svc *Service) getCachedResult(key string) *languagepb.AnalyzeSentimentResponse {
-	entry, err := svc.cache.Get(key)
-	if err != nil {
-		return nil
+// WithHTMLStripper treats every input as HTML and extracts its text content locally, leaving out tags, scripts and
+// stylesheets, before any preprocessor. Unlike sending the input to the Google API as an HTML document, the text that
+// gets analyzed and cached is known to the service.
+func WithHTMLStripper() Option {
+	return func(c *config) {
+		c.stripHTML = true
 	}
+}
 
-	var result languagepb.AnalyzeSentimentResponse
-	if err = proto.Unmarshal(entry, &result); err != nil {
-		return nil
+// WithInvalidUTF8Handling sets what happens to inputs that are not valid UTF-8. By default, they are rejected with an
+// InvalidUTF8Error, which the HTTP API reports as a bad request. JSON request bodies never contain invalid UTF-8 as
+// the decoder already replaces invalid bytes, but fetched documents and inputs given to the library directly may.
+func WithInvalidUTF8Handling(handling InvalidUTF8Handling) Option {
+	return func(c *config) {
+		c.invalidUTF8 = handling
+	}
+}
+
+// WithResultHook sets a function through which every version 1 response is passed after it is produced, to enrich,
+// redact or filter the sentences. It applies to ProcessSentiment, batches and the default output of the HTTP API. If
+// the hook fails, the request fails with the error, which the HTTP API reports as an internal error. Responses
+// returned by the hook are stored in the response cache when it is enabled.
+func WithResultHook(hook func(ctx context.Context, resp Response) (Response, error)) Option {
+	return func(c *config) {
+		c.resultHook = hook
 	}
+}
 
-	return &result
+// WithStopwordRemoval removes stopwords from every input before it is analyzed, after any preprocessor. The
+// stopwords of the language set with WithDefaultLanguage or chosen from the language hints are removed, or English
+// stopwords if the language is not known. DefaultStopwords are used unless lists are given for a language, keyed by
+// language code. The stripped text is what gets sent to the Google API and cached.
+func WithStopwordRemoval(lists map[string][]string) Option {
+	return func(c *config) {
+		c.stopwords = newStopwordRemover(lists)
+	}
 }
 
-func (svc *Service) processAPIResult(ctx context.Context, result *languagepb.AnalyzeSentimentResponse, sortOrder SortOrder, limit int) (Response, error) {
-	if err := ctx.Err(); err != nil {
-		zap.S().Errorw("Context cancelled", "error", err)
-		return nil, err
+// WithStatusBody makes the /status liveness endpoint respond with {"status":"ok"} instead of an empty body
+func WithStatusBody() Option {
+	return func(c *config) {
+		c.statusBody = true
 	}
+}
 
-	if result == nil {
-		return nil, nil
+// WithStrictStatusMethods makes the /status liveness endpoint reject methods other than GET and HEAD with 405 Method
+// Not Allowed. By default any method is accepted.
+func WithStrictStatusMethods() Option {
+	return func(c *config) {
+		c.strictStatusMethods = true
 	}
+}
 
-	switch sortOrder {
-	case Ascending:
-		sort.Sort(byScoreAsc(result.Sentences))
-	case Descending:
-		sort.Sort(byScoreDesc(result.Sentences))
+// WithHealthErrorRateThreshold sets the error rate of calls to the Google API, over the most recent windowSize
+// calls, above which the /health endpoint reports the service as unhealthy
+func WithHealthErrorRateThreshold(threshold float64, windowSize int) Option {
+	return func(c *config) {
+		c.healthErrorRateThreshold = threshold
+		c.healthWindowSize = windowSize
 	}
+}
 
-	arraySize := limit
-	if arraySize < 0 {
-		arraySize = len(result.Sentences)
-	} else if len(result.Sentences) < arraySize {
-		arraySize = len(result.Sentences)
+// WithLogger sets the logger used by the service. Logging is disabled by default.
+func WithLogger(logger *zap.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
 	}
+}
 
-	resp := make([]map[string]float32, arraySize)
-	for i := 0; i < arraySize; i++ {
-		resp[i] = map[string]float32{result.Sentences[i].Text.Content: result.Sentences[i].Sentiment.Score}
+// WithAccessLog enables logging of every HTTP request in the given format
+func WithAccessLog(format AccessLogFormat) Option {
+	return func(c *config) {
+		c.accessLog = true
+		c.accessLogFormat = format
 	}
+}
 
-	return Response(resp), nil
+type config struct {
+	requestTimeout    time.Duration
+	maxRetries        int
+	slowThreshold     time.Duration
+	cacheMaxSizeMB    int
+	cacheEntryTTL     time.Duration
+	cacheCompression  bool
+	cacheOnly         bool
+	cacheHasher       bigcache.Hasher
+	cache             Cache
+	cacheOpTimeout    time.Duration
+	responseCacheMB   int
+	fallbackAnalyzer  Analyzer
+	batchConcurrency  int
+	maxBatchSize      int
+	batchFailFast     bool
+	batchWindow       time.Duration
+	accessLog         bool
+	accessLogFormat   AccessLogFormat
+	allowPut          bool
+	methodOverrides   map[string]bool
+	debugMode         bool
+	recentBufferSize  int
+	adminToken        string
+	selfTest          bool
+	statusBody        bool
+	rejectEmpty       bool
+	emptyStatus       int
+	skipIncomplete    bool
+	strictParams      bool
+	maxQueryLength    int
+	requestIDs        bool
+	credentialsJSON   []byte
+	quotaProject      string
+	quotaLimit        int
+	quotaWindow       time.Duration
+	quotaKeyHeader    string
+	handlerTimeout    time.Duration
+	negativeThreshold float32
+	positiveThreshold float32
+	scoreTransform    func(score, magnitude float32) float32
+	lexiconOverrides  map[string]float32
+	scoreScaled       bool
+	scoreMin          float32
+	scoreMax          float32
+	maxTextLength     int
+	minTokens         int
+	logger            *zap.Logger
+	autoChunk         bool
+	maxChunkBytes     int
+	apiVersion        APIVersion
+	defaultLanguage   string
+	preprocessor      func(string) string
+	stripHTML         bool
+	invalidUTF8       InvalidUTF8Handling
+	resultHook        func(context.Context, Response) (Response, error)
+	stopwords         *stopwordRemover
+	responseVersion   ResponseVersion
+	urlFetching       bool
+	fetchClient       *http.Client
+	urlAllowedHosts   map[string]bool
+	rawUTF8Output     bool
+	idempotencyWindow time.Duration
+	statsdClient      StatsDClient
+
+	healthErrorRateThreshold float64
+	healthWindowSize         int
+	maxRequestBodyBytes      int64
+	responseCompression      bool
+	maxConcurrentCalls       int
+	strictStatusMethods      bool
+}
+
+// SortOrder is an enum defining the sort order of results
+type SortOrder int
+
+const (
+	// Ascending order
+	Ascending SortOrder = iota
+	// Descending order
+	Descending
+	// DocumentOrder retains the order of the sentences in the document
+	DocumentOrder
+)
+
+// ctxCheckInterval is the number of sentences processed between checks for context cancellation
+const ctxCheckInterval = 2048
+
+// degradedHeader is set on HTTP responses produced by the fallback analyzer
+const degradedHeader = "X-Sentiment-Degraded"
+
+// emptyHeader is set on HTTP responses when the input was analyzed but contained no sentences
+const emptyHeader = "X-Sentiment-Empty"
+
+// skippedHeader is set on HTTP responses to the number of sentences omitted because they lacked a sentiment
+const skippedHeader = "X-Sentiment-Skipped"
+
+// totalCountHeader is set on HTTP responses listing sentences to the number of sentences, or groups of duplicates,
+// available before the offset and limit were applied
+const totalCountHeader = "X-Total-Count"
+
+// Response is the expected output type from the service
+type Response []map[string]float32
+
+// MarshalJSON implements the json.Marshaler interface, encoding a nil Response as an empty array. HTML characters are
+// left unescaped so that the encoder the Response is passed to decides whether to escape them.
+func (r Response) MarshalJSON() ([]byte, error) {
+	if r == nil {
+		return []byte("[]"), nil
+	}
+	return marshalJSONUnescaped([]map[string]float32(r))
+}
+
+// debugResponse is the output returned in debug mode, including the raw response of the remote API
+type debugResponse struct {
+	Result interface{}     `json:"result"`
+	Raw    json.RawMessage `json:"raw"`
+}
+
+type input struct {
+	Content string `json:"content"`
+	URL     string `json:"url,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	Order   string `json:"order,omitempty"`
+	Limit   *int   `json:"limit,omitempty"`
+	Offset  *int   `json:"offset,omitempty"`
+
+	LanguageHints []string `json:"language_hints,omitempty"`
+}
+
+// mergeParams fills in the order, limit and offset query parameters from the body when they are absent from
+// the query. Query parameters always take precedence over the equivalent fields of the body.
+func (inp *input) mergeParams(params url.Values) {
+	if params.Get("order") == "" && inp.Order != "" {
+		params.Set("order", inp.Order)
+	}
+
+	if params.Get("limit") == "" && inp.Limit != nil {
+		params.Set("limit", strconv.Itoa(*inp.Limit))
+	}
+
+	if params.Get("offset") == "" && inp.Offset != nil {
+		params.Set("offset", strconv.Itoa(*inp.Offset))
+	}
+}
+
+// Analyzer is implemented by sentiment analyzers that can stand in for the remote API
+type Analyzer interface {
+	AnalyzeSentiment(context.Context, *languagepb.AnalyzeSentimentRequest) (*languagepb.AnalyzeSentimentResponse, error)
+}
+
+type languageClient interface {
+	AnalyzeSentiment(context.Context, *languagepb.AnalyzeSentimentRequest, ...gax.CallOption) (*languagepb.AnalyzeSentimentResponse, error)
+	AnnotateText(context.Context, *languagepb.AnnotateTextRequest, ...gax.CallOption) (*languagepb.AnnotateTextResponse, error)
+	Close() error
+}
+
+// Service implements the sentiment analysis API extension
+type Service struct {
+	// zeroSentences is accessed atomically and kept first for 64-bit alignment
+	zeroSentences int64
+
+	conf      *config
+	client    languageClient
+	cache     Cache
+	logger    *zap.SugaredLogger
+	health    *healthTracker
+	coalescer *coalescer
+	quota     *quotaTracker
+	recent    *recentBuffer
+	responses *responseCache
+	limiter   *callLimiter
+	closed    int32
+
+	idempotency *idempotencyStore
+	metrics     metricsSinks
+}
+
+// ErrServiceClosed is returned for requests that need the remote API after the service has been closed
+var ErrServiceClosed = errors.New("service closed")
+
+// NewService creates a new sentiment analysis API extension with the given options
+func NewService(opts ...Option) (*Service, error) {
+	conf := &config{
+		requestTimeout:    1 * time.Second,
+		cacheMaxSizeMB:    64,
+		cacheEntryTTL:     10 * time.Minute,
+		batchConcurrency:  4,
+		negativeThreshold: defaultNegativeThreshold,
+		positiveThreshold: defaultPositiveThreshold,
+		responseVersion:   ResponseV1,
+
+		healthErrorRateThreshold: defaultHealthErrorRateThreshold,
+		healthWindowSize:         defaultHealthWindowSize,
+		maxRequestBodyBytes:      defaultMaxRequestBodyBytes,
+	}
+
+	for _, opt := range opts {
+		opt(conf)
+	}
+
+	if conf.scoreScaled && conf.scoreMin >= conf.scoreMax {
+		return nil, fmt.Errorf("invalid score scale [%v, %v]", conf.scoreMin, conf.scoreMax)
+	}
+
+	if conf.emptyStatus != 0 && conf.emptyStatus != http.StatusOK && conf.emptyStatus != http.StatusNoContent {
+		return nil, fmt.Errorf("unsupported empty result status %d", conf.emptyStatus)
+	}
+
+	if conf.defaultLanguage != "" && !validLanguageTag(conf.defaultLanguage) {
+		return nil, fmt.Errorf("invalid default language %q: expected a BCP 47 language tag such as \"en\" or \"pt-BR\"", conf.defaultLanguage)
+	}
+
+	if conf.logger == nil {
+		conf.logger = zap.NewNop()
+	}
+
+	// validate the cache before connecting to the remote API so that a misconfigured cache fails fast
+	cache := conf.cache
+	if cache == nil {
+		cacheConf := bigcache.DefaultConfig(conf.cacheEntryTTL)
+		cacheConf.HardMaxCacheSize = conf.cacheMaxSizeMB
+		if conf.cacheHasher != nil {
+			cacheConf.Hasher = conf.cacheHasher
+		}
+		bc, err := bigcache.NewBigCache(cacheConf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cache: %+v", err)
+		}
+		cache = bc
+	}
+
+	if validator, ok := cache.(CacheValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, fmt.Errorf("cache validation failed: %+v", err)
+		}
+	}
+
+	// the in-memory cache never blocks for long so only custom caches are bounded
+	if conf.cache != nil && conf.cacheOpTimeout > 0 {
+		cache = &timeoutCache{cache: cache, timeout: conf.cacheOpTimeout, logger: conf.logger.Sugar()}
+	}
+
+	client, err := newLanguageClient(context.Background(), conf)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := &Service{
+		conf:   conf,
+		client: client,
+		cache:  cache,
+		logger: conf.logger.Sugar(),
+		health: newHealthTracker(conf.healthWindowSize, conf.healthErrorRateThreshold),
+	}
+
+	if conf.batchWindow > 0 {
+		svc.coalescer = newCoalescer(conf.batchWindow)
+	}
+
+	if conf.recentBufferSize > 0 {
+		svc.recent = newRecentBuffer(conf.recentBufferSize)
+	}
+
+	if conf.responseCacheMB > 0 {
+		if svc.responses, err = newResponseCache(conf.cacheEntryTTL, conf.responseCacheMB); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.idempotencyWindow > 0 {
+		if svc.idempotency, err = newIdempotencyStore(conf.idempotencyWindow); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.quotaLimit > 0 && conf.quotaWindow > 0 {
+		svc.quota = newQuotaTracker(conf.quotaLimit, conf.quotaWindow)
+	}
+
+	if conf.maxConcurrentCalls > 0 {
+		svc.limiter = newCallLimiter(conf.maxConcurrentCalls)
+	}
+
+	if conf.statsdClient != nil {
+		svc.metrics = append(svc.metrics, &statsdSink{client: conf.statsdClient})
+	}
+
+	return svc, nil
+}
+
+// Close terminates the service. Requests that are still being served after Close can only be answered from the
+// cache and fail with ErrServiceClosed otherwise.
+func (svc *Service) Close() error {
+	if !atomic.CompareAndSwapInt32(&svc.closed, 0, 1) {
+		return nil
+	}
+
+	if svc.client != nil {
+		return svc.client.Close()
+	}
+	return nil
+}
+
+// isClosed reports whether Close has been called
+func (svc *Service) isClosed() bool {
+	return atomic.LoadInt32(&svc.closed) == 1
+}
+
+// RESTHandler implements the http Handler interface to provide sentiment analysis services
+func (svc *Service) RESTHandler() http.Handler {
+	mux := http.NewServeMux()
+	// api handler
+	mux.HandleFunc("/api", svc.idempotent(svc.handleHTTPRequest))
+	mux.HandleFunc("/batch", svc.idempotent(svc.handleBatchRequest))
+	mux.HandleFunc("/batch/sse", svc.handleBatchSSERequest)
+	mux.HandleFunc("/detect", svc.handleDetectRequest)
+	mux.HandleFunc("/annotate", svc.handleAnnotateRequest)
+	mux.HandleFunc("/compare", svc.idempotent(svc.handleCompareRequest))
+	mux.HandleFunc("/csv", svc.handleCSVRequest)
+	mux.HandleFunc("/recent", svc.handleRecentRequest)
+	mux.HandleFunc("/cache", svc.handleCacheRequest)
+	mux.HandleFunc("/cache/keys", svc.handleCacheKeysRequest)
+	mux.HandleFunc("/selftest", svc.handleSelfTestRequest)
+	mux.HandleFunc("/api/", svc.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		// the trailing slash pattern matches the whole subtree so only accept the exact path
+		if r.URL.Path != "/api/" {
+			http.NotFound(w, r)
+			return
+		}
+		svc.handleHTTPRequest(w, r)
+	}))
+	mux.HandleFunc("/version", svc.handleVersionRequest)
+	// health handler reflecting the recent error rate of the Google API
+	mux.HandleFunc("/health", svc.handleHealthRequest)
+	// health handler for Kubernetes liveness check
+	mux.HandleFunc("/status", svc.handleStatusRequest)
+
+	var handler http.Handler = mux
+	if len(svc.conf.methodOverrides) > 0 {
+		handler = methodOverrideHandler(svc.conf.methodOverrides, handler)
+	}
+
+	if svc.conf.handlerTimeout > 0 {
+		handler = timeoutHandler(svc.conf.handlerTimeout, handler)
+	}
+
+	if svc.quota != nil {
+		handler = quotaKeyHandler(svc.conf.quotaKeyHeader, handler)
+	}
+
+	if svc.conf.requestIDs {
+		handler = requestIDHandler(handler)
+	}
+
+	if svc.conf.accessLog {
+		handler = accessLogHandler(svc.conf.logger, svc.conf.accessLogFormat, handler)
+	}
+
+	return handler
+}
+
+// timeoutHandler limits the time available to the wrapped handler by setting a deadline on the request context
+func timeoutHandler(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancelFunc := context.WithTimeout(r.Context(), timeout)
+		defer cancelFunc()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// isAnalysisMethod checks whether the HTTP method is acceptable for requesting an analysis
+func (svc *Service) isAnalysisMethod(method string) bool {
+	return method == http.MethodPost || (method == http.MethodPut && svc.conf.allowPut)
+}
+
+// checkQueryLength writes an error response and returns false if the raw query string of the request is longer than
+// allowed, so that oversized queries are rejected without the cost of parsing them
+func (svc *Service) checkQueryLength(w http.ResponseWriter, r *http.Request) bool {
+	if svc.conf.maxQueryLength <= 0 || len(r.URL.RawQuery) <= svc.conf.maxQueryLength {
+		return true
+	}
+
+	svc.logger.Warnw("Query string too long", "length", len(r.URL.RawQuery), "max", svc.conf.maxQueryLength)
+	http.Error(w, fmt.Sprintf("Query string may not be longer than %d bytes", svc.conf.maxQueryLength), http.StatusBadRequest)
+	return false
+}
+
+// strictParamNames are the query parameters that may appear at most once when strict parameter parsing is enabled
+var strictParamNames = []string{"order", "limit"}
+
+// checkRepeatedParams writes an error response and returns false if strict parameter parsing is enabled and the
+// query repeats a parameter whose value would otherwise be ambiguous
+func (svc *Service) checkRepeatedParams(w http.ResponseWriter, params url.Values) bool {
+	if !svc.conf.strictParams {
+		return true
+	}
+
+	for _, name := range strictParamNames {
+		if len(params[name]) > 1 {
+			svc.logger.Warnw("Repeated query parameter", "param", name, "values", params[name])
+			http.Error(w, fmt.Sprintf("Query parameter %q must not be repeated", name), http.StatusBadRequest)
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseSortAndLimit extracts the sort order and the limit from the query parameters
+func (svc *Service) parseSortAndLimit(params url.Values) (SortOrder, int) {
+	sortOrder := Ascending
+	switch strings.ToLower(params.Get("order")) {
+	case "desc":
+		sortOrder = Descending
+	case "none", "document":
+		sortOrder = DocumentOrder
+	}
+
+	limit := -1
+	if l := params.Get("limit"); l != "" {
+		limitVal, err := strconv.Atoi(l)
+		if err != nil {
+			svc.logger.Warnw("Invalid limit parameter", "limit", l, "error", err)
+		} else {
+			limit = limitVal
+		}
+	}
+
+	return sortOrder, limit
+}
+
+// parseOffset extracts the number of sentences to skip from the query parameters
+func (svc *Service) parseOffset(params url.Values) int {
+	o := params.Get("offset")
+	if o == "" {
+		return 0
+	}
+
+	offset, err := strconv.Atoi(o)
+	if err != nil || offset < 0 {
+		svc.logger.Warnw("Invalid offset parameter", "offset", o, "error", err)
+		return 0
+	}
+
+	return offset
+}
+
+// applyOffset returns a copy of the result with the sentences arranged in the given sort order and the first
+// offset sentences removed. The sort order to use for further processing of the copy is returned alongside it.
+func applyOffset(result *languagepb.AnalyzeSentimentResponse, sortOrder SortOrder, offset int) (*languagepb.AnalyzeSentimentResponse, SortOrder) {
+	if result == nil || offset <= 0 {
+		return result, sortOrder
+	}
+
+	for _, sentence := range result.Sentences {
+		if sentence.Sentiment == nil {
+			// leave malformed results to be rejected by the reduction
+			return result, sortOrder
+		}
+	}
+
+	// the result may be shared with the cache so it must not be modified
+	trimmed := *result
+	trimmed.Sentences = windowResults(selectSentences(result.Sentences, sortOrder, -1), offset, -1)
+	return &trimmed, DocumentOrder
+}
+
+// windowResults returns the sentences remaining after skipping offset sentences and keeping at most limit of the
+// rest. All combinations of offset and limit are valid:
+//
+//	offset < 0          treated as 0
+//	offset >= len       no sentences
+//	limit < 0           no limit
+//	limit == 0          no sentences
+//	offset+limit > len  the sentences from offset to the end
+//
+// The returned slice shares the backing array of the input.
+func windowResults(sentences []*languagepb.Sentence, offset, limit int) []*languagepb.Sentence {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(sentences) {
+		offset = len(sentences)
+	}
+	sentences = sentences[offset:]
+
+	if limit >= 0 && limit < len(sentences) {
+		sentences = sentences[:limit]
+	}
+
+	return sentences
+}
+
+// writeError writes the HTTP error response appropriate for an error returned while processing a request
+func writeError(w http.ResponseWriter, err error) {
+	switch err {
+	case context.DeadlineExceeded:
+		http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+		return
+	case ErrCacheMiss:
+		http.Error(w, "Result not cached", http.StatusNotFound)
+		return
+	case ErrServiceClosed:
+		http.Error(w, "Service is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch e := err.(type) {
+	case *InputTooShortError:
+		http.Error(w, e.Error(), http.StatusUnprocessableEntity)
+		return
+	case *InvalidUTF8Error:
+		http.Error(w, e.Error(), http.StatusBadRequest)
+		return
+	case *QuotaExceededError:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+		http.Error(w, "Quota exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	// the Google API reports its own deadline being exceeded as a gRPC status rather than a context error
+	if status.Code(err) == codes.DeadlineExceeded {
+		http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+		return
+	}
+
+	http.Error(w, "Internal error", http.StatusInternalServerError)
+}
+
+func (svc *Service) handleHTTPRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer func() {
+			io.Copy(ioutil.Discard, r.Body)
+			r.Body.Close()
+		}()
+	}
+
+	if !svc.isAnalysisMethod(r.Method) {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !svc.checkQueryLength(w, r) {
+		return
+	}
+
+	var inp input
+	if !svc.decodeRequestBody(w, r, &inp) {
+		return
+	}
+
+	params := r.URL.Query()
+	if !svc.checkRepeatedParams(w, params) {
+		return
+	}
+	inp.mergeParams(params)
+	sortOrder, limit := svc.parseSortAndLimit(params)
+	offset := svc.parseOffset(params)
+	debug, _ := strconv.ParseBool(params.Get("debug"))
+
+	group := strings.ToLower(params.Get("group"))
+	if group != "" && group != groupByPolarity {
+		svc.logger.Warnw("Invalid group parameter", "group", group)
+		http.Error(w, "Invalid group parameter", http.StatusBadRequest)
+		return
+	}
+
+	// a version selected by the Accept header takes precedence over the version parameter
+	version, err := acceptedResponseVersion(r.Header.Get("Accept"))
+	if err != nil {
+		svc.logger.Warnw("Unsupported response version", "accept", r.Header.Get("Accept"))
+		http.Error(w, "Unsupported response version", http.StatusNotAcceptable)
+		return
+	}
+
+	if version == 0 {
+		version, err = parseResponseVersion(params.Get("v"), svc.conf.responseVersion)
+		if err != nil {
+			svc.logger.Warnw("Invalid version parameter", "version", params.Get("v"))
+			http.Error(w, "Invalid version parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	preprocess := strings.ToLower(params.Get("preprocess"))
+	if preprocess != "" && preprocess != preprocessSocial {
+		svc.logger.Warnw("Invalid preprocess parameter", "preprocess", preprocess)
+		http.Error(w, "Invalid preprocess parameter", http.StatusBadRequest)
+		return
+	}
+
+	if version == ResponseV2 && group != "" {
+		http.Error(w, "Grouping is only supported by version 1 responses", http.StatusBadRequest)
+		return
+	}
+
+	fields, err := parseFieldProjection(params.Get("fields"))
+	if err != nil {
+		svc.logger.Warnw("Invalid fields parameter", "fields", params.Get("fields"))
+		http.Error(w, fmt.Sprintf("Invalid fields parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if fields != nil && version != ResponseV2 {
+		http.Error(w, "Field projection is only supported by version 2 responses", http.StatusBadRequest)
+		return
+	}
+
+	aggregate, _ := strconv.ParseBool(params.Get("aggregate_duplicates"))
+	if aggregate && (version == ResponseV2 || group != "") {
+		http.Error(w, "Aggregation is only supported by ungrouped version 1 responses", http.StatusBadRequest)
+		return
+	}
+
+	scoreAggregate := strings.ToLower(params.Get("aggregate"))
+	if scoreAggregate != "" && scoreAggregate != aggregateWeighted {
+		svc.logger.Warnw("Invalid aggregate parameter", "aggregate", scoreAggregate)
+		http.Error(w, "Invalid aggregate parameter", http.StatusBadRequest)
+		return
+	}
+
+	if scoreAggregate != "" && (version == ResponseV2 || group != "" || aggregate) {
+		http.Error(w, "Score aggregation cannot be combined with other output options", http.StatusBadRequest)
+		return
+	}
+
+	weight := strings.ToLower(params.Get("weight"))
+	if weight != "" && weight != weightLength {
+		svc.logger.Warnw("Invalid weight parameter", "weight", weight)
+		http.Error(w, "Invalid weight parameter", http.StatusBadRequest)
+		return
+	}
+
+	format := strings.ToLower(params.Get("format"))
+	if format != "" && format != formatHistogram && format != formatTrinary {
+		svc.logger.Warnw("Invalid format parameter", "format", format)
+		http.Error(w, "Invalid format parameter", http.StatusBadRequest)
+		return
+	}
+
+	buckets, err := parseHistogramBuckets(params.Get("buckets"))
+	if err != nil {
+		svc.logger.Warnw("Invalid buckets parameter", "buckets", params.Get("buckets"))
+		http.Error(w, fmt.Sprintf("Invalid buckets parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if format != "" && (version == ResponseV2 || group != "" || aggregate || scoreAggregate != "") {
+		http.Error(w, fmt.Sprintf("The %s format cannot be combined with other output options", format), http.StatusBadRequest)
+		return
+	}
+
+	smooth, err := parseSmoothWindow(params.Get("smooth"))
+	if err != nil {
+		svc.logger.Warnw("Invalid smooth parameter", "smooth", params.Get("smooth"))
+		http.Error(w, fmt.Sprintf("Invalid smooth parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if smooth > 0 && (version == ResponseV2 || group != "" || aggregate || scoreAggregate != "" || format != "") {
+		http.Error(w, "Smoothing cannot be combined with other output options", http.StatusBadRequest)
+		return
+	}
+
+	stream, _ := strconv.ParseBool(params.Get("stream"))
+	if stream && (version == ResponseV2 || group != "" || aggregate || scoreAggregate != "" || format != "" || smooth > 0 || (debug && svc.conf.debugMode)) {
+		http.Error(w, "Streaming is only supported by plain version 1 responses", http.StatusBadRequest)
+		return
+	}
+
+	if len(inp.LanguageHints) > maxLanguageHints {
+		http.Error(w, fmt.Sprintf("At most %d language hints are allowed", maxLanguageHints), http.StatusBadRequest)
+		return
+	}
+	for _, hint := range inp.LanguageHints {
+		if strings.TrimSpace(hint) == "" {
+			http.Error(w, "Empty language hint", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ser := negotiateSerializer(r.Header.Get("Accept"))
+
+	if inp.URL != "" {
+		if !svc.fetchContent(w, r, &inp) {
+			return
+		}
+	}
+
+	// results are deterministic for a given input and set of parameters so clients can revalidate
+	// their copy without incurring the cost of an analysis, unless they explicitly ask for a fresh result
+	noCache := requestsNoCache(r, params)
+	etag := computeETag(inp.Content, sortOrder, limit, offset, debug && svc.conf.debugMode, group, version, preprocess, aggregate, scoreAggregate, weight, format, buckets, smooth, fields, ser.contentType, inp.LanguageHints, stream)
+	if !noCache && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	ctx := r.Context()
+	if tenant := requestTenant(r, inp.Tenant); tenant != "" {
+		ctx = WithTenant(ctx, tenant)
+	}
+	if noCache {
+		ctx = WithCacheBypass(ctx)
+	}
+	if len(inp.LanguageHints) > 0 {
+		ctx = WithLanguageHints(ctx, inp.LanguageHints...)
+	}
+	billing := &billingCounter{}
+	ctx = withBillingCounter(ctx, billing)
+
+	// responses produced by the fallback analyzer and streamed responses are never cached
+	responseKey := responseCacheKey(ctx, etag)
+	if !noCache && !stream {
+		if header, body, ok := svc.responses.get(responseKey); ok {
+			for name, value := range header {
+				w.Header().Set(name, value)
+			}
+			w.Header().Add("Vary", "Accept")
+			w.Header().Set("ETag", etag)
+			w.Header().Set(billableUnitsHeader, "0")
+			w.Write(svc.compressResponse(w, r, body))
+			return
+		}
+	}
+
+	content := inp.Content
+	if preprocess == preprocessSocial {
+		content = SocialMediaPreprocessor(content)
+	}
+
+	result, degraded, err := svc.analyze(ctx, content)
+	if err != nil {
+		svc.logger.Errorw("Request failed", "error", err)
+		writeError(w, err)
+		return
+	}
+
+	complete, skipped := svc.conf.dropIncompleteSentences(result)
+	if skipped > 0 {
+		svc.logger.Warnw("Skipped sentences without sentiment", "skipped", skipped)
+	}
+
+	// distinguish inputs that were analyzed but contain nothing meaningful, such as punctuation, from failures
+	empty := len(complete.GetSentences()) == 0
+	if empty && svc.conf.rejectEmpty {
+		http.Error(w, "No sentences found in the input", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if empty && svc.conf.emptyStatus == http.StatusNoContent {
+		if degraded {
+			w.Header().Add(degradedHeader, "true")
+		}
+		w.Header().Add(emptyHeader, "true")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// everything but the raw debug output uses the transformed scores
+	scored := svc.conf.transformScores(complete)
+
+	page := scored
+	var counts map[*languagepb.Sentence]int
+	if aggregate {
+		if page, counts, err = aggregateDuplicates(scored); err != nil {
+			svc.logger.Errorw("Request failed", "error", err)
+			writeError(w, err)
+			return
+		}
+	}
+
+	total := len(page.GetSentences())
+	pageOrder := sortOrder
+	if weight == weightLength {
+		page, pageOrder = applyLengthWeight(page, pageOrder)
+	}
+
+	// the offset is applied in the requested sort order before any grouping
+	page, pageOrder = applyOffset(page, pageOrder, offset)
+
+	var output interface{}
+	switch {
+	case scoreAggregate == aggregateWeighted:
+		// the aggregate score always covers the whole document regardless of the order, limit and offset
+		output, err = svc.processAPIResultWeighted(ctx, scored, weight == weightLength)
+	case smooth > 0:
+		// the moving average follows the whole document in order regardless of the order, limit and offset
+		output, err = svc.processAPIResultSmoothed(ctx, scored, smooth)
+	case format == formatHistogram:
+		// like the aggregate score, the histogram covers the whole document
+		output, err = svc.processAPIResultHistogram(ctx, scored, buckets)
+	case format == formatTrinary:
+		output, err = svc.processAPIResultTrinary(ctx, page, pageOrder, limit)
+	case aggregate:
+		output, err = svc.processAPIResultAggregated(ctx, page, counts, pageOrder, limit)
+	case version == ResponseV2:
+		var rawTexts map[*languagepb.Sentence]string
+		if preprocess != "" || svc.conf.preprocessor != nil || svc.conf.stopwords != nil || svc.conf.stripHTML {
+			rawTexts = alignRawText(inp.Content, scored.GetSentences())
+		}
+		var resp *EnrichedResponse
+		if resp, err = svc.processAPIResultV2(ctx, page, rawTexts, pageOrder, limit); err == nil && fields != nil {
+			output = resp.project(fields)
+		} else {
+			output = resp
+		}
+	case group == groupByPolarity:
+		output, err = svc.processAPIResultByPolarity(ctx, page, pageOrder, limit)
+	default:
+		var resp Response
+		if resp, err = svc.processAPIResult(ctx, page, pageOrder, limit); err == nil {
+			resp, err = svc.applyResultHook(ctx, resp)
+		}
+		output = resp
+	}
+
+	if err != nil {
+		svc.logger.Errorw("Request failed", "error", err)
+		writeError(w, err)
+		return
+	}
+
+	if debug && svc.conf.debugMode {
+		raw, err := marshalRawProto(result)
+		if err != nil {
+			svc.logger.Errorw("Failed to marshal raw result", "error", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		output = debugResponse{Result: output, Raw: raw}
+	}
+
+	var body []byte
+	if stream {
+		w.Header().Set("Content-Type", contentTypeNDJSON)
+	} else {
+		var ok bool
+		if body, ok = svc.marshalResponse(w, r, output); !ok {
+			return
+		}
+	}
+
+	if degraded {
+		w.Header().Add(degradedHeader, "true")
+	} else {
+		w.Header().Set("ETag", etag)
+	}
+	if empty {
+		w.Header().Add(emptyHeader, "true")
+	}
+	if skipped > 0 {
+		w.Header().Add(skippedHeader, strconv.Itoa(skipped))
+	}
+	if scoreAggregate == "" {
+		w.Header().Set(totalCountHeader, strconv.Itoa(total))
+	}
+	w.Header().Set(billableUnitsHeader, billing.String())
+
+	if stream {
+		if err := svc.writeStream(ctx, w, output.(Response)); err != nil {
+			svc.logger.Warnw("Failed to stream response", "error", err)
+		}
+		return
+	}
+
+	if !degraded {
+		svc.responses.set(responseKey, w.Header(), body)
+	}
+	w.Write(svc.compressResponse(w, r, body))
+}
+
+// computeETag derives a strong entity tag from the input and the parameters that affect the output
+func computeETag(input string, params ...interface{}) string {
+	h := sha256.New()
+	for _, p := range params {
+		fmt.Fprintf(h, "%v:", p)
+	}
+	io.WriteString(h, input)
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches checks whether the given If-None-Match header value matches the entity tag
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ProcessSentiment implements the logic of processing a sentiment analysis request
+func (svc *Service) ProcessSentiment(ctx context.Context, input string, sort SortOrder, limit int) (Response, error) {
+	result, _, err := svc.analyze(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	result, skipped := svc.conf.dropIncompleteSentences(result)
+	if skipped > 0 {
+		svc.logger.Warnw("Skipped sentences without sentiment", "skipped", skipped)
+	}
+
+	resp, err := svc.processAPIResult(ctx, svc.conf.transformScores(result), sort, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.applyResultHook(ctx, resp)
+}
+
+// applyResultHook passes the response through the hook set with WithResultHook, if any
+func (svc *Service) applyResultHook(ctx context.Context, resp Response) (Response, error) {
+	if svc.conf.resultHook == nil {
+		return resp, nil
+	}
+
+	resp, err := svc.conf.resultHook(ctx, resp)
+	if err != nil {
+		svc.logger.Errorw("Result hook failed", "error", err)
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Analyze returns the raw sentiment analysis of the input, served from the cache when possible
+func (svc *Service) Analyze(ctx context.Context, input string) (*languagepb.AnalyzeSentimentResponse, error) {
+	result, _, err := svc.analyze(ctx, input)
+	return result, err
+}
+
+// analyze does the work of Analyze and additionally reports whether the result was produced by the
+// fallback analyzer instead of the remote API. Every analysis, including those of ProcessSentiment, batches and the
+// HTTP API, goes through it so that this is where the latency and the errors are measured.
+func (svc *Service) analyze(ctx context.Context, input string) (*languagepb.AnalyzeSentimentResponse, bool, error) {
+	start := time.Now()
+	result, degraded, err := svc.analyzeInput(ctx, input)
+	svc.metrics.analysisDone(time.Since(start), err)
+	return result, degraded, err
+}
+
+// normalizeInput checks that the input is valid UTF-8, replacing invalid bytes if configured to, strips HTML markup
+// and applies the preprocessor, so that every analysis sees the same text for the same input
+func (svc *Service) normalizeInput(input string) (string, error) {
+	input, err := svc.conf.checkUTF8(input)
+	if err != nil {
+		svc.logger.Warnw("Invalid input", "error", err)
+		return "", err
+	}
+
+	if svc.conf.stripHTML {
+		input = extractText(input)
+	}
+
+	if svc.conf.preprocessor != nil {
+		input = svc.conf.preprocessor(input)
+	}
+
+	return input, nil
+}
+
+// analyzeInput implements analyze
+func (svc *Service) analyzeInput(ctx context.Context, input string) (*languagepb.AnalyzeSentimentResponse, bool, error) {
+	if err := ctx.Err(); err != nil {
+		svc.logger.Warnw("Context cancelled", "error", err, "input", input)
+		return nil, false, err
+	}
+
+	start := time.Now()
+	input, err := svc.normalizeInput(input)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := svc.conf.checkMinTokens(input); err != nil {
+		return nil, false, err
+	}
+
+	params := svc.analysisParams()
+	var detection *languagepb.AnalyzeSentimentResponse
+	if hints := languageHintsFromContext(ctx); len(hints) > 0 {
+		language, resp, err := svc.chooseLanguage(ctx, input, hints)
+		if err != nil {
+			return nil, false, err
+		}
+		params.language = language
+		detection = resp
+	}
+
+	filtered := svc.conf.stopwords.remove(input, params.language)
+	if filtered != input {
+		// the detection call analyzed the text before the stop words of the chosen language were removed
+		detection = nil
+	}
+	input = filtered
+	key := cacheKey(ctx, input, params)
+
+	// the call that detected one of the hinted languages already analyzed the input, so it is not made twice
+	if detection != nil {
+		if entry, err := svc.encodeCacheEntry(detection); err == nil {
+			svc.setCachedEntry(key, entry, input)
+		}
+		svc.recordRecent(input, detection, false, false)
+		svc.countZeroSentences(detection)
+		svc.logIfSlow(start, input, false)
+		return detection, false, nil
+	}
+
+	// if the result is already in the cache, skip the remote API call unless a fresh result is requested. The cache
+	// is the only source of results in cache-only mode so it is never bypassed.
+	if !cacheBypassed(ctx) || svc.conf.cacheOnly {
+		cachedResult := svc.getCachedResult(key)
+		svc.metrics.cacheLookup(cachedResult != nil)
+		if cachedResult != nil {
+			svc.recordRecent(input, cachedResult, true, false)
+			svc.countZeroSentences(cachedResult)
+			svc.logIfSlow(start, input, true)
+			return cachedResult, false, nil
+		}
+	}
+
+	if svc.conf.cacheOnly {
+		return nil, false, ErrCacheMiss
+	}
+
+	if svc.client == nil || svc.isClosed() {
+		return nil, false, ErrServiceClosed
+	}
+
+	if err := svc.quota.check(quotaKeyFromContext(ctx)); err != nil {
+		return nil, false, err
+	}
+
+	req := params.request(input)
+
+	// make the remote API call and save the result in the cache. When requests are coalesced, this only happens
+	// once for all of them. A successful result is cached even if the caller has since given up, so that a request
+	// timing out after the API call completed does not cause the same analysis to be billed again.
+	callRemote := func(ctx context.Context) (*languagepb.AnalyzeSentimentResponse, error) {
+		var resp *languagepb.AnalyzeSentimentResponse
+		var err error
+		if svc.conf.autoChunk && len(input) > svc.conf.maxChunkBytes {
+			resp, err = svc.callAPIChunked(ctx, input, params)
+		} else {
+			resp, err = svc.callAPI(ctx, req)
+		}
+
+		if err == nil {
+			if entry, err := svc.encodeCacheEntry(resp); err == nil {
+				svc.setCachedEntry(key, entry, input)
+			}
+		}
+
+		return resp, err
+	}
+
+	var resp *languagepb.AnalyzeSentimentResponse
+	if svc.coalescer != nil {
+		resp, err = svc.coalescer.do(ctx, key, callRemote)
+	} else {
+		resp, err = callRemote(ctx)
+	}
+	svc.logIfSlow(start, input, false)
+
+	// failures caused by the caller going away say nothing about the health of the API
+	if ctx.Err() == nil {
+		svc.health.record(err == nil)
+	}
+
+	if err != nil {
+		svc.logger.Errorw("Remote API call failure", "error", err, "input", input)
+		if svc.conf.fallbackAnalyzer == nil {
+			return nil, false, err
+		}
+
+		// degraded results are not cached so that the next request retries the remote API
+		fallbackResp, fallbackErr := svc.conf.fallbackAnalyzer.AnalyzeSentiment(ctx, req)
+		if fallbackErr != nil {
+			svc.logger.Errorw("Fallback analyzer failure", "error", fallbackErr, "input", input)
+			return nil, false, err
+		}
+
+		svc.recordRecent(input, fallbackResp, false, true)
+		return fallbackResp, true, nil
+	}
+
+	svc.recordRecent(input, resp, false, false)
+	svc.countZeroSentences(resp)
+	return resp, false, nil
+}
+
+// countZeroSentences counts results without any sentences, which come from inputs with nothing to analyze such as
+// punctuation or emojis. Such results are cached like any other.
+func (svc *Service) countZeroSentences(resp *languagepb.AnalyzeSentimentResponse) {
+	if len(resp.GetSentences()) == 0 {
+		atomic.AddInt64(&svc.zeroSentences, 1)
+	}
+}
+
+// logIfSlow warns about an analysis that started at the given time if it took longer than the threshold set with
+// WithSlowRequestThreshold
+func (svc *Service) logIfSlow(start time.Time, input string, cached bool) {
+	if svc.conf.slowThreshold <= 0 {
+		return
+	}
+
+	if elapsed := time.Since(start); elapsed > svc.conf.slowThreshold {
+		svc.logger.Warnw("Slow analysis", "elapsed", elapsed, "input_bytes", len(input), "cached", cached)
+	}
+}
+
+// ZeroSentenceResults returns the number of analyses, cached or not, that produced no sentences since the service
+// was created. A high count points at clients sending inputs with nothing to analyze.
+func (svc *Service) ZeroSentenceResults() int64 {
+	return atomic.LoadInt64(&svc.zeroSentences)
+}
+
+// withCallTimeout limits a call to the Google API to the configured request timeout, or to the time left before the
+// deadline of the caller if that is sooner, so that a call is never given a longer budget than the caller has left.
+// Coalesced calls are not tied to any caller and are only limited by the request timeout.
+func (svc *Service) withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if svc.conf.requestTimeout > 0 {
+		timeoutDeadline := time.Now().Add(svc.conf.requestTimeout)
+		if deadline, ok := ctx.Deadline(); !ok || timeoutDeadline.Before(deadline) {
+			return context.WithDeadline(ctx, timeoutDeadline)
+		}
+	}
+
+	// the deadline of the caller, if any, is sooner and already applies. Deriving a new deadline from it would let the
+	// call time out before the caller notices its own deadline has passed.
+	return context.WithCancel(ctx)
+}
+
+// callAPI calls the remote API, limiting the call to the configured request timeout or the deadline of the caller,
+// whichever is sooner. Calls failing with a transient error are retried if configured to, as long as enough of the
+// timeout is left for another attempt.
+func (svc *Service) callAPI(ctx context.Context, req *languagepb.AnalyzeSentimentRequest) (*languagepb.AnalyzeSentimentResponse, error) {
+	var resp *languagepb.AnalyzeSentimentResponse
+	err := svc.callWithRetries(ctx, billableUnits(req.GetDocument().GetContent()), func(ctx context.Context) error {
+		var err error
+		resp, err = svc.client.AnalyzeSentiment(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// callWithRetries makes a call to the Google API, retrying transient failures while time is left. A successful call
+// is billed the given units and charged against the quota of the caller.
+func (svc *Service) callWithRetries(ctx context.Context, units int, call func(context.Context) error) error {
+	// the time spent waiting for a slot does not count towards the timeout of the call
+	if err := svc.limiter.acquire(ctx); err != nil {
+		return err
+	}
+	defer svc.limiter.release()
+
+	ctx, cancelFunc := svc.withCallTimeout(ctx)
+	defer cancelFunc()
+
+	attempts := svc.conf.maxRetries + 1
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancelAttempt := attemptContext(ctx, attempts-attempt+1)
+		err := call(outgoingRequestID(attemptCtx))
+		cancelAttempt()
+		if err == nil {
+			recordBilling(ctx, units)
+			svc.quota.charge(quotaKeyFromContext(ctx))
+			return nil
+		}
+
+		if attempt == attempts || ctx.Err() != nil || !isRetryable(err) || !waitForRetry(ctx) {
+			return err
+		}
+		svc.logger.Warnw("Retrying remote API call", "error", err, "attempt", attempt+1)
+	}
+}
+
+func (svc *Service) getCachedResult(key string) *languagepb.AnalyzeSentimentResponse {
+	entry, err := svc.cache.Get(key)
+	if err != nil {
+		return nil
+	}
+
+	result, err := decodeCacheEntry(entry)
+	if err != nil {
+		// remove the corrupted entry so that the next request repopulates it instead of failing until it expires
+		svc.logger.Warnw("Removing corrupted cache entry", "error", err)
+		if err := svc.cache.Delete(key); err != nil {
+			svc.logger.Warnw("Failed to remove corrupted cache entry", "error", err)
+		}
+		return nil
+	}
+
+	return result
+}
+
+// setCachedEntry stores the entry for the input in the cache. The cache rejects entries that do not fit in one of its
+// shards, so results for very large inputs are never cached and requests for them are billed every time. This is
+// logged so that the cache size can be adjusted.
+func (svc *Service) setCachedEntry(key string, entry []byte, input string) {
+	if err := svc.cache.Set(key, entry); err != nil {
+		svc.logger.Warnw("Failed to cache result", "error", err, "input_length", len(input), "entry_size", len(entry))
+	}
+}
+
+func (svc *Service) processAPIResult(ctx context.Context, result *languagepb.AnalyzeSentimentResponse, sortOrder SortOrder, limit int) (Response, error) {
+	if err := ctx.Err(); err != nil {
+		svc.logger.Errorw("Context cancelled", "error", err)
+		return nil, err
+	}
+
+	resp, err := reduceResponse(ctx, result, sortOrder, limit)
+	if err != nil {
+		if ctx.Err() != nil {
+			svc.logger.Warnw("Context cancelled while processing result", "error", err)
+		}
+		return nil, err
+	}
+
+	// an empty analysis is serialized as an empty list rather than null
+	if resp == nil {
+		resp = Response{}
+	}
+
+	svc.conf.formatResponse(resp)
+	return resp, nil
+}
+
+// ReduceResponse sorts the sentences of a response from the Google API and reduces them to a Response containing
+// at most limit sentences. A negative limit returns all the sentences.
+func ReduceResponse(result *languagepb.AnalyzeSentimentResponse, sortOrder SortOrder, limit int) (Response, error) {
+	return reduceResponse(context.Background(), result, sortOrder, limit)
+}
+
+// reduceResponse implements ReduceResponse, periodically checking the context to abandon work on large responses
+func reduceResponse(ctx context.Context, result *languagepb.AnalyzeSentimentResponse, sortOrder SortOrder, limit int) (Response, error) {
+	if result == nil {
+		return nil, nil
+	}
+
+	for i, sentence := range result.Sentences {
+		if sentence.Text == nil || sentence.Sentiment == nil {
+			return nil, fmt.Errorf("malformed sentence at index %d", i)
+		}
+	}
+
+	return reduceSentences(ctx, result.Sentences, sortOrder, limit)
+}
+
+// dropIncompleteSentences returns a copy of the result without the sentences lacking a text or a sentiment, along
+// with the number of sentences dropped, if configured to skip them. Otherwise the result is returned as is so that
+// the reduction rejects it.
+func (c *config) dropIncompleteSentences(result *languagepb.AnalyzeSentimentResponse) (*languagepb.AnalyzeSentimentResponse, int) {
+	if c == nil || !c.skipIncomplete || result == nil {
+		return result, 0
+	}
+
+	var complete []*languagepb.Sentence
+	for _, sentence := range result.Sentences {
+		if sentence != nil && sentence.Text != nil && sentence.Sentiment != nil {
+			complete = append(complete, sentence)
+		}
+	}
+
+	skipped := len(result.Sentences) - len(complete)
+	if skipped == 0 {
+		return result, 0
+	}
+
+	// the result may be shared with the cache so it must not be modified
+	trimmed := *result
+	trimmed.Sentences = complete
+	return &trimmed, skipped
+}
+
+// reduceSentences sorts the sentences by score and converts the first limit sentences to a Response
+func reduceSentences(ctx context.Context, input []*languagepb.Sentence, sortOrder SortOrder, limit int) (Response, error) {
+	sentences := selectSentences(input, sortOrder, limit)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	resp := make([]map[string]float32, len(sentences))
+	for i, sentence := range sentences {
+		if i > 0 && i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		resp[i] = map[string]float32{sentence.Text.Content: sentence.Sentiment.Score}
+	}
+
+	return Response(resp), nil
+}
+
+// selectSentences returns the first limit sentences in the given sort order. Sentences with equal scores keep their
+// document order so that the output is the same for every run.
+func selectSentences(input []*languagepb.Sentence, sortOrder SortOrder, limit int) []*languagepb.Sentence {
+	// sort a copy so that the input retains the document order of the sentences
+	sentences := make([]*languagepb.Sentence, len(input))
+	copy(sentences, input)
+
+	switch sortOrder {
+	case Ascending:
+		sort.Stable(byScoreAsc(sentences))
+	case Descending:
+		sort.Stable(byScoreDesc(sentences))
+	}
+
+	return windowResults(sentences, 0, limit)
 }
 
 // Sort interface implementation for sorting entities by ascending order of sentiment score
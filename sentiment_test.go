@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -14,7 +16,12 @@ import (
 	gax "github.com/googleapis/gax-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestProcessAPIResult(t *testing.T) {
@@ -43,7 +50,7 @@ func TestProcessAPIResult(t *testing.T) {
 		},
 	}
 
-	svc := &Service{}
+	svc := &Service{logger: zap.NewNop().Sugar()}
 
 	testCases := []struct {
 		name             string
@@ -102,9 +109,34 @@ func TestProcessAPIResult(t *testing.T) {
 			}),
 		},
 		{
-			name:      "nil_api_result",
-			sortOrder: Descending,
+			name:      "document_order",
+			apiResult: apiResult,
+			sortOrder: DocumentOrder,
+			limit:     -1,
+			expectedResponse: Response([]map[string]float32{
+				map[string]float32{"word1": 0.8},
+				map[string]float32{"word2": 0.8},
+				map[string]float32{"word3": 0.2},
+				map[string]float32{"word4": -0.8},
+				map[string]float32{"word5": 0.0},
+			}),
+		},
+		{
+			name:      "document_order_with_limit",
+			apiResult: apiResult,
+			sortOrder: DocumentOrder,
 			limit:     3,
+			expectedResponse: Response([]map[string]float32{
+				map[string]float32{"word1": 0.8},
+				map[string]float32{"word2": 0.8},
+				map[string]float32{"word3": 0.2},
+			}),
+		},
+		{
+			name:             "nil_api_result",
+			sortOrder:        Descending,
+			limit:            3,
+			expectedResponse: Response{},
 		},
 	}
 
@@ -121,6 +153,187 @@ func TestProcessAPIResult(t *testing.T) {
 	}
 }
 
+func TestSelectSentencesTies(t *testing.T) {
+	// equal scores are interleaved with others so that an unstable sort would be likely to reorder them
+	sentences := make([]*languagepb.Sentence, 50)
+	for i := range sentences {
+		score := float32(0.5)
+		if i%3 == 0 {
+			score = float32(i%5)/10 - 0.2
+		}
+		sentences[i] = &languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: fmt.Sprintf("word%d", i)},
+			Sentiment: &languagepb.Sentiment{Score: score},
+		}
+	}
+
+	indexOf := func(s *languagepb.Sentence) int {
+		var i int
+		fmt.Sscanf(s.Text.Content, "word%d", &i)
+		return i
+	}
+
+	for _, order := range []SortOrder{Ascending, Descending} {
+		selected := selectSentences(sentences, order, -1)
+		assert.Len(t, selected, len(sentences))
+
+		for i := 1; i < len(selected); i++ {
+			prev, cur := selected[i-1], selected[i]
+			if prev.Sentiment.Score == cur.Sentiment.Score {
+				assert.True(t, indexOf(prev) < indexOf(cur), "%s before %s", prev.Text.Content, cur.Text.Content)
+			}
+		}
+	}
+
+	// the input is left in document order
+	for i, s := range sentences {
+		assert.Equal(t, i, indexOf(s))
+	}
+}
+
+func TestWindowResults(t *testing.T) {
+	sentences := make([]*languagepb.Sentence, 5)
+	for i := range sentences {
+		sentences[i] = &languagepb.Sentence{Text: &languagepb.TextSpan{Content: fmt.Sprintf("word%d", i+1)}}
+	}
+
+	testCases := []struct {
+		name     string
+		offset   int
+		limit    int
+		expected []string
+	}{
+		{name: "no_offset_no_limit", offset: 0, limit: -1, expected: []string{"word1", "word2", "word3", "word4", "word5"}},
+		{name: "no_offset_zero_limit", offset: 0, limit: 0, expected: []string{}},
+		{name: "no_offset_limit", offset: 0, limit: 2, expected: []string{"word1", "word2"}},
+		{name: "no_offset_limit_equals_len", offset: 0, limit: 5, expected: []string{"word1", "word2", "word3", "word4", "word5"}},
+		{name: "no_offset_limit_exceeds_len", offset: 0, limit: 100, expected: []string{"word1", "word2", "word3", "word4", "word5"}},
+		{name: "negative_offset_no_limit", offset: -3, limit: -1, expected: []string{"word1", "word2", "word3", "word4", "word5"}},
+		{name: "negative_offset_limit", offset: -3, limit: 2, expected: []string{"word1", "word2"}},
+		{name: "offset_no_limit", offset: 2, limit: -1, expected: []string{"word3", "word4", "word5"}},
+		{name: "offset_zero_limit", offset: 2, limit: 0, expected: []string{}},
+		{name: "offset_limit", offset: 2, limit: 2, expected: []string{"word3", "word4"}},
+		{name: "offset_limit_reaching_end", offset: 2, limit: 3, expected: []string{"word3", "word4", "word5"}},
+		{name: "offset_limit_past_end", offset: 2, limit: 100, expected: []string{"word3", "word4", "word5"}},
+		{name: "last_offset", offset: 4, limit: -1, expected: []string{"word5"}},
+		{name: "offset_equals_len", offset: 5, limit: -1, expected: []string{}},
+		{name: "offset_equals_len_limit", offset: 5, limit: 2, expected: []string{}},
+		{name: "offset_exceeds_len", offset: 100, limit: -1, expected: []string{}},
+		{name: "offset_exceeds_len_limit", offset: 100, limit: 100, expected: []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			window := windowResults(sentences, tc.offset, tc.limit)
+			texts := make([]string, len(window))
+			for i, sentence := range window {
+				texts[i] = sentence.Text.Content
+			}
+			assert.Equal(t, tc.expected, texts)
+		})
+	}
+
+	t.Run("empty_input", func(t *testing.T) {
+		assert.Empty(t, windowResults(nil, 1, 1))
+	})
+}
+
+func syntheticResponse(numSentences int) *languagepb.AnalyzeSentimentResponse {
+	rng := rand.New(rand.NewSource(42))
+	resp := &languagepb.AnalyzeSentimentResponse{Sentences: make([]*languagepb.Sentence, numSentences)}
+	for i := range resp.Sentences {
+		resp.Sentences[i] = &languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: fmt.Sprintf("sentence%d", i)},
+			Sentiment: &languagepb.Sentiment{Magnitude: rng.Float32(), Score: rng.Float32()*2 - 1},
+		}
+	}
+
+	return resp
+}
+
+func TestReduceResponse(t *testing.T) {
+	t.Run("large_response", func(t *testing.T) {
+		apiResult := syntheticResponse(50000)
+
+		for _, sortOrder := range []SortOrder{Ascending, Descending} {
+			resp, err := ReduceResponse(apiResult, sortOrder, 1000)
+			assert.NoError(t, err)
+			assert.Len(t, resp, 1000)
+
+			var prev float32
+			for i, entry := range resp {
+				for _, score := range entry {
+					if i > 0 {
+						if sortOrder == Ascending {
+							assert.True(t, prev <= score)
+						} else {
+							assert.True(t, prev >= score)
+						}
+					}
+					prev = score
+				}
+			}
+		}
+
+		// the input retains the document order
+		assert.Equal(t, "sentence0", apiResult.Sentences[0].Text.Content)
+	})
+
+	t.Run("nil_response", func(t *testing.T) {
+		resp, err := ReduceResponse(nil, Ascending, -1)
+		assert.NoError(t, err)
+		assert.Nil(t, resp)
+	})
+
+	t.Run("malformed_sentence", func(t *testing.T) {
+		apiResult := syntheticResponse(3)
+		apiResult.Sentences[1].Sentiment = nil
+		_, err := ReduceResponse(apiResult, Ascending, -1)
+		assert.Error(t, err)
+	})
+}
+
+// cancelAfterContext reports cancellation after its Err method has been called a number of times
+type cancelAfterContext struct {
+	context.Context
+	remaining int
+	calls     int
+}
+
+func (c *cancelAfterContext) Err() error {
+	c.calls++
+	if c.calls > c.remaining {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestProcessAPIResultCancellation(t *testing.T) {
+	svc := &Service{logger: zap.NewNop().Sugar()}
+	apiResult := syntheticResponse(100000)
+
+	ctx := &cancelAfterContext{Context: context.Background(), remaining: 5}
+	resp, err := svc.processAPIResult(ctx, apiResult, Descending, -1)
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, resp)
+	// the loop must bail out after cancellation rather than process every sentence
+	assert.True(t, ctx.calls < len(apiResult.Sentences)/ctxCheckInterval)
+}
+
+func BenchmarkReduceResponse(b *testing.B) {
+	for _, size := range []int{100, 10000, 100000} {
+		apiResult := syntheticResponse(size)
+		b.Run(fmt.Sprintf("sentences_%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ReduceResponse(apiResult, Descending, 10); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 type mockLanguageClient struct {
 	mock.Mock
 }
@@ -134,18 +347,27 @@ func (m *mockLanguageClient) AnalyzeSentiment(ctx context.Context, req *language
 	return nil, args.Error(1)
 }
 
+func (m *mockLanguageClient) AnnotateText(ctx context.Context, req *languagepb.AnnotateTextRequest, opts ...gax.CallOption) (*languagepb.AnnotateTextResponse, error) {
+	args := m.MethodCalled("AnnotateText", ctx, req, opts)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*languagepb.AnnotateTextResponse), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
 func (m *mockLanguageClient) Close() error {
 	args := m.MethodCalled("Close")
 	return args.Error(0)
 }
 
-func createMocks(t *testing.T) (*mockLanguageClient, *Service) {
+func createMocks(t testing.TB) (*mockLanguageClient, *Service) {
 	mockClient := &mockLanguageClient{}
-	conf := &config{requestTimeout: 1 * time.Second}
+	conf := &config{requestTimeout: 1 * time.Second, logger: zap.NewNop(), responseVersion: ResponseV1}
 	cache, err := bigcache.NewBigCache(bigcache.DefaultConfig(10 * time.Minute))
 	assert.NoError(t, err)
 
-	svc := &Service{conf: conf, client: mockClient, cache: cache}
+	svc := &Service{conf: conf, client: mockClient, cache: cache, logger: conf.logger.Sugar()}
 
 	return mockClient, svc
 }
@@ -211,6 +433,112 @@ func TestServiceCall(t *testing.T) {
 		mockClient.AssertExpectations(t)
 	})
 
+	t.Run("process_sentiment_fallback", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.fallbackAnalyzer = NewLexiconAnalyzer(map[string]float32{"love": 0.9, "hate": -0.9})
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, fmt.Errorf("error"))
+
+		expectedResult := Response([]map[string]float32{
+			map[string]float32{"I love the product.": 0.9},
+			map[string]float32{"I hate this site.": -0.9},
+		})
+
+		result, degraded, err := svc.analyze(context.Background(), "I hate this site. I love the product.")
+		assert.NoError(t, err)
+		assert.True(t, degraded)
+
+		resp, err := svc.processAPIResult(context.Background(), result, Descending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedResult, resp)
+		mockClient.AssertExpectations(t)
+
+		// degraded results must not be cached
+		assert.Nil(t, svc.getCachedResult(cacheKey(context.Background(), "i hate this site. i love the product.", svc.analysisParams())))
+	})
+
+	t.Run("no_sentences", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		result, degraded, err := svc.analyze(context.Background(), "?!")
+		assert.NoError(t, err)
+		assert.False(t, degraded)
+
+		resp, err := svc.processAPIResult(context.Background(), result, Descending, -1)
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		assert.Len(t, resp, 0)
+
+		// empty results are deterministic so they are cached like any other
+		assert.NotNil(t, svc.getCachedResult(cacheKey(context.Background(), "?!", svc.analysisParams())))
+		_, _, err = svc.analyze(context.Background(), "?!")
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("http_request_no_sentences", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"?!"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		result := responseRecorder.Result()
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "true", result.Header.Get(emptyHeader))
+
+		body, err := ioutil.ReadAll(result.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "[]\n", string(body))
+	})
+
+	t.Run("http_request_no_sentences_rejected", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.rejectEmpty = true
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"?!"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		result := responseRecorder.Result()
+
+		assert.Equal(t, http.StatusUnprocessableEntity, result.StatusCode)
+	})
+
+	t.Run("http_request_nil_result", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"?!"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		result := responseRecorder.Result()
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "true", result.Header.Get(emptyHeader))
+		body, err := ioutil.ReadAll(result.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "[]\n", string(body))
+	})
+
+	t.Run("http_request_no_sentences_no_content", func(t *testing.T) {
+		for _, apiResponse := range []*languagepb.AnalyzeSentimentResponse{nil, &languagepb.AnalyzeSentimentResponse{}} {
+			mockClient, svc := createMocks(t)
+			svc.conf.emptyStatus = http.StatusNoContent
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"?!"}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, http.StatusNoContent, result.StatusCode)
+			assert.Equal(t, "true", result.Header.Get(emptyHeader))
+			assert.Empty(t, responseRecorder.Body.String())
+		}
+	})
+
 	t.Run("http_request_default_limit", func(t *testing.T) {
 		mockClient, svc := createMocks(t)
 		mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(expectedResponse, nil)
@@ -259,6 +587,102 @@ func TestServiceCall(t *testing.T) {
 		assert.Equal(t, expectedOutput, output)
 	})
 
+	t.Run("http_request_document_order", func(t *testing.T) {
+		for _, order := range []string{"none", "document"} {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(expectedResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api?limit=4&order="+order, strings.NewReader(`{"content":"word1 word2 word3 word4 word5"}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, http.StatusOK, result.StatusCode)
+
+			var output Response
+			assert.NoError(t, json.NewDecoder(result.Body).Decode(&output))
+
+			expectedOutput := Response([]map[string]float32{
+				map[string]float32{"word1": 0.8},
+				map[string]float32{"word2": 0.8},
+				map[string]float32{"word3": 0.2},
+				map[string]float32{"word4": -0.8},
+			})
+
+			assert.Equal(t, expectedOutput, output)
+		}
+	})
+
+	t.Run("http_request_body_params", func(t *testing.T) {
+		testCases := []struct {
+			name     string
+			query    string
+			body     string
+			expected Response
+		}{
+			{
+				name:  "body_only",
+				query: "",
+				body:  `{"content":"word1 word2 word3 word4 word5","order":"document","limit":2,"offset":1}`,
+				expected: Response([]map[string]float32{
+					map[string]float32{"word2": 0.8},
+					map[string]float32{"word3": 0.2},
+				}),
+			},
+			{
+				name:  "body_offset_with_default_order",
+				query: "",
+				body:  `{"content":"word1 word2 word3 word4 word5","limit":2,"offset":1}`,
+				expected: Response([]map[string]float32{
+					map[string]float32{"word5": 0.0},
+					map[string]float32{"word3": 0.2},
+				}),
+			},
+			{
+				name:  "query_takes_precedence",
+				query: "?order=document&limit=1",
+				body:  `{"content":"word1 word2 word3 word4 word5","order":"desc","limit":3,"offset":2}`,
+				expected: Response([]map[string]float32{
+					map[string]float32{"word3": 0.2},
+				}),
+			},
+			{
+				name:  "query_zero_offset_takes_precedence",
+				query: "?order=document&offset=0",
+				body:  `{"content":"word1 word2 word3 word4 word5","limit":2,"offset":3}`,
+				expected: Response([]map[string]float32{
+					map[string]float32{"word1": 0.8},
+					map[string]float32{"word2": 0.8},
+				}),
+			},
+			{
+				name:     "offset_past_end",
+				query:    "?offset=10",
+				body:     `{"content":"word1 word2 word3 word4 word5"}`,
+				expected: Response([]map[string]float32{}),
+			},
+		}
+
+		for _, tc := range testCases {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				mockClient, svc := createMocks(t)
+				mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(expectedResponse, nil)
+
+				responseRecorder := httptest.NewRecorder()
+				request := httptest.NewRequest(http.MethodPost, "/api"+tc.query, strings.NewReader(tc.body))
+				svc.handleHTTPRequest(responseRecorder, request)
+				result := responseRecorder.Result()
+
+				assert.Equal(t, http.StatusOK, result.StatusCode)
+
+				var output Response
+				assert.NoError(t, json.NewDecoder(result.Body).Decode(&output))
+				assert.Equal(t, tc.expected, output)
+			})
+		}
+	})
+
 	t.Run("http_request_invalid_limit", func(t *testing.T) {
 		mockClient, svc := createMocks(t)
 		mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(expectedResponse, nil)
@@ -296,6 +720,101 @@ func TestServiceCall(t *testing.T) {
 		assert.Equal(t, http.StatusMethodNotAllowed, result.StatusCode)
 	})
 
+	t.Run("http_request_routing", func(t *testing.T) {
+		testCases := []struct {
+			name           string
+			method         string
+			target         string
+			allowPut       bool
+			expectedStatus int
+		}{
+			{name: "post", method: http.MethodPost, target: "/api", expectedStatus: http.StatusOK},
+			{name: "post_trailing_slash", method: http.MethodPost, target: "/api/?limit=1", expectedStatus: http.StatusOK},
+			{name: "subpath", method: http.MethodPost, target: "/api/other", expectedStatus: http.StatusNotFound},
+			{name: "put_disabled", method: http.MethodPut, target: "/api", expectedStatus: http.StatusMethodNotAllowed},
+			{name: "put_enabled", method: http.MethodPut, target: "/api", allowPut: true, expectedStatus: http.StatusOK},
+			{name: "put_enabled_trailing_slash", method: http.MethodPut, target: "/api/", allowPut: true, expectedStatus: http.StatusOK},
+			{name: "get", method: http.MethodGet, target: "/api/", allowPut: true, expectedStatus: http.StatusMethodNotAllowed},
+			{name: "delete", method: http.MethodDelete, target: "/api", allowPut: true, expectedStatus: http.StatusMethodNotAllowed},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				mockClient, svc := createMocks(t)
+				svc.conf.allowPut = tc.allowPut
+				mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(expectedResponse, nil)
+
+				responseRecorder := httptest.NewRecorder()
+				request := httptest.NewRequest(tc.method, tc.target, strings.NewReader(`{"content":"word1 word2 word3 word4 word5"}`))
+				svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+				assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+			})
+		}
+	})
+
+	t.Run("http_request_timeout", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.requestTimeout = 20 * time.Millisecond
+		mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(nil, context.DeadlineExceeded).Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		})
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"word1 word2 word3 word4 word5"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+
+		assert.Equal(t, http.StatusGatewayTimeout, responseRecorder.Result().StatusCode)
+	})
+
+	t.Run("http_api_deadline_exceeded", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(nil, status.Error(codes.DeadlineExceeded, "deadline exceeded"))
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"word1 word2 word3 word4 word5"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+
+		assert.Equal(t, http.StatusGatewayTimeout, responseRecorder.Result().StatusCode)
+	})
+
+	t.Run("caller_deadline_shorter_than_timeout", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.requestTimeout = 10 * time.Second
+
+		var callDeadline time.Time
+		mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(expectedResponse, nil).Run(func(args mock.Arguments) {
+			callDeadline, _ = args.Get(0).(context.Context).Deadline()
+		})
+
+		ctx, cancelFunc := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancelFunc()
+		callerDeadline, _ := ctx.Deadline()
+
+		_, err := svc.ProcessSentiment(ctx, "word1 word2 word3 word4 word5", Descending, -1)
+		assert.NoError(t, err)
+		assert.False(t, callDeadline.IsZero())
+		assert.False(t, callDeadline.After(callerDeadline))
+	})
+
+	t.Run("timeout_shorter_than_caller_deadline", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.requestTimeout = 50 * time.Millisecond
+
+		var callDeadline time.Time
+		mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(expectedResponse, nil).Run(func(args mock.Arguments) {
+			callDeadline, _ = args.Get(0).(context.Context).Deadline()
+		})
+
+		ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelFunc()
+
+		_, err := svc.ProcessSentiment(ctx, "word1 word2 word3 word4 word5", Descending, -1)
+		assert.NoError(t, err)
+		assert.False(t, callDeadline.IsZero())
+		assert.True(t, time.Until(callDeadline) <= 50*time.Millisecond)
+	})
+
 	t.Run("http_request_remote_failure", func(t *testing.T) {
 		mockClient, svc := createMocks(t)
 		mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(nil, fmt.Errorf("error"))
@@ -307,4 +826,575 @@ func TestServiceCall(t *testing.T) {
 
 		assert.Equal(t, http.StatusInternalServerError, result.StatusCode)
 	})
+
+	t.Run("http_request_etag", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(expectedResponse, nil).Once()
+
+		doRequest := func(target string, ifNoneMatch string) *http.Response {
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"content":"word1 word2 word3 word4 word5"}`))
+			if ifNoneMatch != "" {
+				request.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			svc.handleHTTPRequest(responseRecorder, request)
+			return responseRecorder.Result()
+		}
+
+		first := doRequest("/api?order=desc&limit=3", "")
+		assert.Equal(t, http.StatusOK, first.StatusCode)
+		etag := first.Header.Get("ETag")
+		assert.NotEmpty(t, etag)
+
+		second := doRequest("/api?order=desc&limit=3", "")
+		assert.Equal(t, http.StatusOK, second.StatusCode)
+		assert.Equal(t, etag, second.Header.Get("ETag"))
+
+		other := doRequest("/api?order=desc&limit=2", "")
+		assert.Equal(t, http.StatusOK, other.StatusCode)
+		assert.NotEqual(t, etag, other.Header.Get("ETag"))
+
+		notModified := doRequest("/api?order=desc&limit=3", `"other", `+etag)
+		assert.Equal(t, http.StatusNotModified, notModified.StatusCode)
+		assert.Equal(t, etag, notModified.Header.Get("ETag"))
+
+		mismatch := doRequest("/api?order=desc&limit=3", `"other"`)
+		assert.Equal(t, http.StatusOK, mismatch.StatusCode)
+
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("http_request_debug", func(t *testing.T) {
+		debugResponse := &languagepb.AnalyzeSentimentResponse{
+			DocumentSentiment: &languagepb.Sentiment{Magnitude: 9.0, Score: 0.2},
+			Language:          "en",
+			Sentences:         expectedResponse.Sentences,
+		}
+
+		doRequest := func(debugMode bool) map[string]interface{} {
+			mockClient, svc := createMocks(t)
+			svc.conf.debugMode = debugMode
+			mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(debugResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api?limit=1&debug=true", strings.NewReader(`{"content":"word1 word2 word3 word4 word5"}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+			assert.Equal(t, http.StatusOK, result.StatusCode)
+
+			var output interface{}
+			assert.NoError(t, json.NewDecoder(result.Body).Decode(&output))
+			if m, ok := output.(map[string]interface{}); ok {
+				return m
+			}
+			return nil
+		}
+
+		// debug parameter is ignored unless debug mode is enabled
+		assert.Nil(t, doRequest(false))
+
+		output := doRequest(true)
+		assert.Equal(t, []interface{}{map[string]interface{}{"word4": -0.8}}, output["result"])
+
+		raw := output["raw"].(map[string]interface{})
+		assert.Equal(t, "en", raw["language"])
+		assert.Equal(t, map[string]interface{}{"magnitude": 9.0, "score": 0.2}, raw["documentSentiment"])
+		assert.Len(t, raw["sentences"], 5)
+	})
+
+	t.Run("injected_logger", func(t *testing.T) {
+		core, logs := observer.New(zapcore.DebugLevel)
+		mockClient, svc := createMocks(t)
+		svc.logger = zap.New(core).Sugar()
+		mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(nil, fmt.Errorf("error"))
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"word1 word2 word3 word4 word5"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+
+		assert.Equal(t, 1, logs.FilterMessage("Remote API call failure").Len())
+		assert.Equal(t, 1, logs.FilterMessage("Request failed").Len())
+	})
+
+	t.Run("http_request_fallback", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.fallbackAnalyzer = NewLexiconAnalyzer(nil)
+		mockClient.On("AnalyzeSentiment", mock.Anything, expectedRequest, mock.Anything).Return(nil, fmt.Errorf("error"))
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"word1 word2 word3 word4 word5"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		result := responseRecorder.Result()
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "true", result.Header.Get(degradedHeader))
+		assert.Empty(t, result.Header.Get("ETag"))
+
+		var output Response
+		assert.NoError(t, json.NewDecoder(result.Body).Decode(&output))
+		assert.Equal(t, Response([]map[string]float32{map[string]float32{"word1 word2 word3 word4 word5": 0.0}}), output)
+	})
+}
+
+func TestProcessSentimentAfterClose(t *testing.T) {
+	t.Run("closed", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("Close").Return(nil)
+		assert.NoError(t, svc.Close())
+		assert.NoError(t, svc.Close())
+
+		assert.NotPanics(t, func() {
+			_, err := svc.ProcessSentiment(context.Background(), "Great.", Descending, 0)
+			assert.Equal(t, ErrServiceClosed, err)
+		})
+		mockClient.AssertNumberOfCalls(t, "Close", 1)
+		mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("nil_client", func(t *testing.T) {
+		_, svc := createMocks(t)
+		svc.client = nil
+
+		assert.NotPanics(t, func() {
+			_, err := svc.ProcessSentiment(context.Background(), "Great.", Descending, 0)
+			assert.Equal(t, ErrServiceClosed, err)
+		})
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+		mockClient.On("Close").Return(nil)
+
+		_, err := svc.ProcessSentiment(context.Background(), "Great.", Descending, 0)
+		assert.NoError(t, err)
+		assert.NoError(t, svc.Close())
+
+		_, err = svc.ProcessSentiment(context.Background(), "Great.", Descending, 0)
+		assert.NoError(t, err)
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 1)
+	})
+
+	t.Run("http", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("Close").Return(nil)
+		assert.NoError(t, svc.Close())
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"Great."}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+
+		assert.Equal(t, http.StatusServiceUnavailable, responseRecorder.Result().StatusCode)
+	})
+}
+
+func TestIncompleteSentences(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+			&languagepb.Sentence{
+				Text: &languagepb.TextSpan{Content: "Unscored."},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Bad."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: -0.5},
+			},
+			&languagepb.Sentence{
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: -0.5},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		target          string
+		skipIncomplete  bool
+		expectedStatus  int
+		expectedBody    string
+		expectedSkipped string
+	}{
+		{
+			name:           "rejected",
+			target:         "/api?order=asc",
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:            "skipped",
+			target:          "/api?order=asc",
+			skipIncomplete:  true,
+			expectedStatus:  http.StatusOK,
+			expectedBody:    `[{"Bad.":-0.5},{"Great.":0.5}]`,
+			expectedSkipped: "2",
+		},
+		{
+			name:           "rejected_grouped",
+			target:         "/api?group=polarity",
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:            "skipped_grouped",
+			target:          "/api?group=polarity",
+			skipIncomplete:  true,
+			expectedStatus:  http.StatusOK,
+			expectedBody:    `{"positive":[{"Great.":0.5}],"neutral":[],"negative":[{"Bad.":-0.5}]}`,
+			expectedSkipped: "2",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.skipIncomplete = tc.skipIncomplete
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"Great. Unscored. Bad."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, tc.expectedStatus, result.StatusCode)
+			if tc.expectedStatus == http.StatusOK {
+				assert.JSONEq(t, tc.expectedBody, responseRecorder.Body.String())
+				assert.Equal(t, tc.expectedSkipped, result.Header.Get(skippedHeader))
+			}
+		})
+	}
+
+	t.Run("process_sentiment", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.skipIncomplete = true
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		resp, err := svc.ProcessSentiment(context.Background(), "Great. Unscored. Bad.", Descending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response{{"Great.": 0.5}, {"Bad.": -0.5}}, resp)
+		assert.Len(t, apiResponse.Sentences, 4, "API response was modified")
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		conf := &config{skipIncomplete: true}
+		complete := syntheticResponse(3)
+		result, skipped := conf.dropIncompleteSentences(complete)
+		assert.Equal(t, 0, skipped)
+		assert.True(t, result == complete)
+	})
+}
+
+func TestTotalCountHeader(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Bad."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: -0.5},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.7, Score: 0.7},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		target        string
+		expectedTotal string
+	}{
+		{name: "no_limit", target: "/api", expectedTotal: "3"},
+		{name: "limit", target: "/api?limit=1", expectedTotal: "3"},
+		{name: "offset", target: "/api?offset=2&limit=5", expectedTotal: "3"},
+		{name: "offset_past_end", target: "/api?offset=10", expectedTotal: "3"},
+		{name: "v2", target: "/api?v=2&limit=1", expectedTotal: "3"},
+		{name: "grouped", target: "/api?group=polarity&limit=1", expectedTotal: "3"},
+		{name: "duplicates_aggregated", target: "/api?aggregate_duplicates=true&limit=1", expectedTotal: "2"},
+		{name: "score_aggregate", target: "/api?aggregate=weighted", expectedTotal: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"Great. Bad. Great."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, http.StatusOK, result.StatusCode)
+			assert.Equal(t, tc.expectedTotal, result.Header.Get(totalCountHeader))
+		})
+	}
+}
+
+func TestRepeatedParams(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Good."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.4, Score: 0.4},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Bad."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: -0.5},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Fine."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.1, Score: 0.1},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		strict         bool
+		target         string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{name: "lenient_limit", target: "/api?limit=3&limit=5", expectedStatus: http.StatusOK, expectedCount: 3},
+		{name: "lenient_order", target: "/api?order=desc&order=asc", expectedStatus: http.StatusOK, expectedCount: 4},
+		{name: "strict_limit", strict: true, target: "/api?limit=3&limit=5", expectedStatus: http.StatusBadRequest},
+		{name: "strict_same_value", strict: true, target: "/api?limit=3&limit=3", expectedStatus: http.StatusBadRequest},
+		{name: "strict_order", strict: true, target: "/api?order=desc&order=asc", expectedStatus: http.StatusBadRequest},
+		{name: "strict_single", strict: true, target: "/api?limit=3&order=desc", expectedStatus: http.StatusOK, expectedCount: 3},
+		{name: "strict_batch", strict: true, target: "/batch?limit=3&limit=5", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.strictParams = tc.strict
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			body := `{"content":"Great. Good. Bad. Fine."}`
+			if strings.HasPrefix(tc.target, "/batch") {
+				body = `{"documents":[{"content":"Great. Good. Bad. Fine."}]}`
+			}
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(body))
+			svc.RESTHandler().ServeHTTP(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, tc.expectedStatus, result.StatusCode)
+			if tc.expectedStatus == http.StatusOK {
+				var resp Response
+				assert.NoError(t, json.NewDecoder(result.Body).Decode(&resp))
+				assert.Len(t, resp, tc.expectedCount)
+			}
+		})
+	}
+}
+
+func TestMaxQueryLength(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+		},
+	}
+
+	oversized := "/api?limit=1" + strings.Repeat("&limit=1", 100)
+	testCases := []struct {
+		name           string
+		maxLength      int
+		target         string
+		expectedStatus int
+	}{
+		{name: "unlimited", target: oversized, expectedStatus: http.StatusOK},
+		{name: "within_limit", maxLength: 7, target: "/api?limit=1", expectedStatus: http.StatusOK},
+		{name: "oversized", maxLength: 64, target: oversized, expectedStatus: http.StatusBadRequest},
+		{name: "oversized_batch", maxLength: 64, target: "/batch?limit=1" + strings.Repeat("&limit=1", 100), expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.maxQueryLength = tc.maxLength
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			body := `{"content":"Great."}`
+			if strings.HasPrefix(tc.target, "/batch") {
+				body = `{"documents":[{"content":"Great."}]}`
+			}
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(body))
+			svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+			assert.Equal(t, tc.expectedStatus, responseRecorder.Result().StatusCode)
+			if tc.expectedStatus != http.StatusOK {
+				mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestResultHook(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Call me on 555-0100."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.2, Score: 0.1},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+		},
+	}
+
+	redact := func(ctx context.Context, resp Response) (Response, error) {
+		redacted := make(Response, len(resp))
+		for i, entry := range resp {
+			redacted[i] = make(map[string]float32, len(entry))
+			for text, score := range entry {
+				if strings.Contains(text, "555") {
+					text = "[redacted]"
+				}
+				redacted[i][text] = score
+			}
+		}
+		return redacted, nil
+	}
+
+	t.Run("process_sentiment", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithResultHook(redact)(svc.conf)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		resp, err := svc.ProcessSentiment(context.Background(), "Call me on 555-0100. Great.", Descending, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, Response{{"Great.": 0.5}, {"[redacted]": 0.1}}, resp)
+	})
+
+	t.Run("http", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithResultHook(redact)(svc.conf)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"Call me on 555-0100. Great."}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		result := responseRecorder.Result()
+
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		var resp Response
+		assert.NoError(t, json.NewDecoder(result.Body).Decode(&resp))
+		assert.Equal(t, Response{{"[redacted]": 0.1}, {"Great.": 0.5}}, resp)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithResultHook(func(ctx context.Context, resp Response) (Response, error) {
+			return nil, assert.AnError
+		})(svc.conf)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		_, err := svc.ProcessSentiment(context.Background(), "Great.", Descending, -1)
+		assert.Equal(t, assert.AnError, err)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"Great."}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		assert.Equal(t, http.StatusInternalServerError, responseRecorder.Result().StatusCode)
+	})
+}
+
+func TestEmptyAnalysis(t *testing.T) {
+	testCases := []struct {
+		name      string
+		apiResult *languagepb.AnalyzeSentimentResponse
+	}{
+		{name: "nil_result"},
+		{name: "no_sentences", apiResult: &languagepb.AnalyzeSentimentResponse{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(tc.apiResult, nil)
+
+			resp, err := svc.ProcessSentiment(context.Background(), "Hmm", Descending, -1)
+			assert.NoError(t, err)
+			assert.NotNil(t, resp)
+			assert.Empty(t, resp)
+
+			for _, target := range []string{"/api", "/api?order=asc&limit=2", "/api?no_cache=true"} {
+				responseRecorder := httptest.NewRecorder()
+				request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"content":"Hmm"}`))
+				svc.handleHTTPRequest(responseRecorder, request)
+				result := responseRecorder.Result()
+
+				assert.Equal(t, http.StatusOK, result.StatusCode, target)
+				body, _ := ioutil.ReadAll(result.Body)
+				assert.Equal(t, "[]", strings.TrimSpace(string(body)), target)
+			}
+		})
+	}
+}
+
+func TestSlowRequestThreshold(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	mockClient, svc := createMocks(t)
+	svc.logger = zap.New(core).Sugar()
+	WithSlowRequestThreshold(20 * time.Millisecond)(svc.conf)
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).
+		After(50*time.Millisecond).
+		Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+	_, err := svc.ProcessSentiment(context.Background(), "Great.", Descending, -1)
+	assert.NoError(t, err)
+
+	slow := logs.FilterMessage("Slow analysis").All()
+	if assert.Len(t, slow, 1) {
+		fields := slow[0].ContextMap()
+		assert.Equal(t, int64(len("Great.")), fields["input_bytes"])
+		assert.Equal(t, false, fields["cached"])
+		assert.True(t, fields["elapsed"].(time.Duration) >= 50*time.Millisecond)
+	}
+
+	// the cached result is served well within the threshold
+	_, err = svc.ProcessSentiment(context.Background(), "Great.", Descending, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, logs.FilterMessage("Slow analysis").Len())
+	mockClient.AssertExpectations(t)
+}
+
+func TestResponseMarshalJSON(t *testing.T) {
+	testCases := []struct {
+		name     string
+		resp     Response
+		expected string
+	}{
+		{name: "nil", resp: nil, expected: `[]`},
+		{name: "empty", resp: Response{}, expected: `[]`},
+		{name: "sentences", resp: Response{{"Great.": 0.5}}, expected: `[{"Great.":0.5}]`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			direct, err := json.Marshal(tc.resp)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(direct))
+
+			// the HTTP handler encodes outputs as interface values
+			var output interface{} = tc.resp
+			boxed, err := json.Marshal(output)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(boxed))
+		})
+	}
 }
@@ -0,0 +1,44 @@
+package sentiment
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// SentimentResponse is the Protobuf form of a Response, returned when clients accept application/x-protobuf.
+// It corresponds to the following schema:
+//
+//	message SentimentResponse {
+//	  repeated SentenceScore sentences = 1;
+//	}
+//
+//	message SentenceScore {
+//	  string text = 1;
+//	  float score = 2;
+//	}
+type SentimentResponse struct {
+	Sentences []*SentenceScore `protobuf:"bytes,1,rep,name=sentences" json:"sentences,omitempty"`
+}
+
+// Reset implements the proto.Message interface
+func (m *SentimentResponse) Reset() { *m = SentimentResponse{} }
+
+// String implements the proto.Message interface
+func (m *SentimentResponse) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage implements the proto.Message interface
+func (*SentimentResponse) ProtoMessage() {}
+
+// SentenceScore is the score of a single sentence in a SentimentResponse
+type SentenceScore struct {
+	Text  string  `protobuf:"bytes,1,opt,name=text" json:"text,omitempty"`
+	Score float32 `protobuf:"fixed32,2,opt,name=score" json:"score,omitempty"`
+}
+
+// Reset implements the proto.Message interface
+func (m *SentenceScore) Reset() { *m = SentenceScore{} }
+
+// String implements the proto.Message interface
+func (m *SentenceScore) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage implements the proto.Message interface
+func (*SentenceScore) ProtoMessage() {}
@@ -0,0 +1,318 @@
+package sentiment
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeMsgpack  = "application/msgpack"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+// errUnsupportedOutput is returned when the negotiated format cannot represent the requested output
+var errUnsupportedOutput = errors.New("output not supported by format")
+
+// serializer encodes the output of the HTTP API in a particular format
+type serializer struct {
+	contentType string
+	marshal     func(v interface{}) ([]byte, error)
+}
+
+var serializers = []*serializer{
+	{contentType: contentTypeJSON, marshal: marshalJSON},
+	{contentType: contentTypeMsgpack, marshal: marshalMsgpack},
+	{contentType: contentTypeProtobuf, marshal: marshalProtobuf},
+}
+
+// negotiateSerializer selects the serializer for the first media type of the Accept header that is supported,
+// defaulting to JSON
+func negotiateSerializer(accept string) *serializer {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+
+		// versioned media types select the shape of the output, which is always JSON
+		if isVersionedMediaType(mediaType) {
+			return serializers[0]
+		}
+
+		for _, s := range serializers {
+			if mediaType == s.contentType {
+				return s
+			}
+		}
+
+		if mediaType == "*/*" || mediaType == "application/*" {
+			break
+		}
+	}
+
+	return serializers[0]
+}
+
+// minCompressBytes is the size below which response bodies are not worth compressing
+const minCompressBytes = 1024
+
+// encodeResponse serializes the output in the format negotiated from the Accept header of the request, compressing
+// it if enabled and accepted by the client, and sets the corresponding headers. If the output cannot be serialized,
+// an error response is written and false is returned.
+func (svc *Service) encodeResponse(w http.ResponseWriter, r *http.Request, output interface{}) ([]byte, bool) {
+	body, ok := svc.marshalResponse(w, r, output)
+	if !ok {
+		return nil, false
+	}
+
+	return svc.compressResponse(w, r, body), true
+}
+
+// marshalResponse serializes the output like encodeResponse without compressing it
+func (svc *Service) marshalResponse(w http.ResponseWriter, r *http.Request, output interface{}) ([]byte, bool) {
+	ser := negotiateSerializer(r.Header.Get("Accept"))
+	marshal := ser.marshal
+	if ser.contentType == contentTypeJSON && svc.conf.rawUTF8Output {
+		marshal = marshalRawUTF8JSON
+	}
+
+	body, err := marshal(output)
+	if err == errUnsupportedOutput {
+		http.Error(w, "Output cannot be represented in "+ser.contentType, http.StatusNotAcceptable)
+		return nil, false
+	} else if err != nil {
+		svc.logger.Errorw("Failed to marshal response", "error", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", ser.contentType)
+	w.Header().Add("Vary", "Accept")
+	return body, true
+}
+
+// compressResponse gzip compresses the serialized body if compression is enabled, the body is large enough and the
+// client accepts it, and sets the corresponding headers
+func (svc *Service) compressResponse(w http.ResponseWriter, r *http.Request, body []byte) []byte {
+	if !svc.conf.responseCompression {
+		return body
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(body) < minCompressBytes || !acceptsGzip(r) {
+		return body
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(body); err != nil || gz.Close() != nil {
+		return body
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	return buf.Bytes()
+}
+
+// writeResponse serializes the output as described for encodeResponse and writes it with the given status
+func (svc *Service) writeResponse(w http.ResponseWriter, r *http.Request, status int, output interface{}) {
+	body, ok := svc.encodeResponse(w, r, output)
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of the request allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, err := mime.ParseMediaType(strings.TrimSpace(coding))
+		if err == nil && (name == "gzip" || name == "*") && params["q"] != "0" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// marshalRawProto encodes a message of the Google API using the canonical proto3 JSON mapping, in which enums are
+// represented by their names and oneof fields by the field that is set. encoding/json must not be used for messages
+// as it gets both wrong. The encoder of the golang/protobuf runtime the messages were generated with is used.
+func marshalRawProto(msg proto.Message) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	if err := new(jsonpb.Marshaler).Marshal(&buf, msg); err != nil {
+		return nil, err
+	}
+
+	return json.RawMessage(buf.Bytes()), nil
+}
+
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// marshalRawUTF8JSON encodes the output as JSON like marshalJSON without escaping the characters <, > and &
+func marshalRawUTF8JSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// marshalJSONUnescaped encodes the value like json.Marshal without escaping the characters <, > and &
+func marshalJSONUnescaped(v interface{}) ([]byte, error) {
+	body, err := marshalRawUTF8JSON(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(body, []byte("\n")), nil
+}
+
+// marshalProtobuf encodes the output as a SentimentResponse message. Only version 1 responses are supported.
+func marshalProtobuf(v interface{}) ([]byte, error) {
+	resp, ok := v.(Response)
+	if !ok {
+		return nil, errUnsupportedOutput
+	}
+
+	msg := &SentimentResponse{Sentences: make([]*SentenceScore, 0, len(resp))}
+	for _, sentence := range resp {
+		for text, score := range sentence {
+			msg.Sentences = append(msg.Sentences, &SentenceScore{Text: text, Score: score})
+		}
+	}
+
+	return proto.Marshal(msg)
+}
+
+// marshalMsgpack encodes the output as MessagePack. The output is first converted to its JSON data model so that
+// every output type is encoded with the same field names as in JSON.
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(jsonBytes))
+	dec.UseNumber()
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeMsgpack(&buf, generic); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeMsgpack(buf *bytes.Buffer, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if val {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+
+		f, err := val.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		writeMsgpackHeader(buf, len(val), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		buf.WriteString(val)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(val), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, elem := range val {
+			if err := writeMsgpack(buf, elem); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		// sort the keys so that the encoding is deterministic
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		writeMsgpackHeader(buf, len(val), 0x80, 16, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			writeMsgpack(buf, k)
+			if err := writeMsgpack(buf, val[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported msgpack type %T", v)
+	}
+
+	return nil
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(0xe0 | (i + 32)))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// writeMsgpackHeader writes the type and length prefix of a string, array or map, using the fixed-size form when
+// the length is below fixMax and the 8, 16 or 32 bit length forms otherwise. A zero code means the form is not
+// available for the type.
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fixCode byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n < fixMax:
+		buf.WriteByte(fixCode | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(code8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
@@ -0,0 +1,472 @@
+package sentiment
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestNegotiateSerializer(t *testing.T) {
+	testCases := []struct {
+		accept   string
+		expected string
+	}{
+		{accept: "", expected: contentTypeJSON},
+		{accept: "*/*", expected: contentTypeJSON},
+		{accept: "application/json", expected: contentTypeJSON},
+		{accept: "application/msgpack", expected: contentTypeMsgpack},
+		{accept: "application/x-protobuf", expected: contentTypeProtobuf},
+		{accept: "text/html, application/x-protobuf;q=0.9", expected: contentTypeProtobuf},
+		{accept: "application/msgpack;q=0, application/json", expected: contentTypeJSON},
+		{accept: "*/*, application/msgpack", expected: contentTypeJSON},
+		{accept: "text/html", expected: contentTypeJSON},
+		{accept: "application/vnd.sentiment.v2+json, application/msgpack", expected: contentTypeJSON},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.accept, func(t *testing.T) {
+			assert.Equal(t, tc.expected, negotiateSerializer(tc.accept).contentType)
+		})
+	}
+}
+
+func TestSerializeHTTPRequest(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Great.", BeginOffset: 0},
+				Sentiment: &languagepb.Sentiment{Magnitude: 1, Score: 1},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Bad.", BeginOffset: 7},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: -0.5},
+			},
+		},
+	}
+	expected := Response{{"Bad.": -0.5}, {"Great.": 1}}
+
+	testCases := []struct {
+		name   string
+		accept string
+		decode func(t *testing.T, body []byte) Response
+	}{
+		{
+			name:   "json",
+			accept: contentTypeJSON,
+			decode: func(t *testing.T, body []byte) Response {
+				var resp Response
+				assert.NoError(t, json.Unmarshal(body, &resp))
+				return resp
+			},
+		},
+		{
+			name:   "msgpack",
+			accept: contentTypeMsgpack,
+			decode: func(t *testing.T, body []byte) Response {
+				v, err := readMsgpack(bytes.NewReader(body))
+				assert.NoError(t, err)
+
+				var resp Response
+				for _, elem := range v.([]interface{}) {
+					sentence := make(map[string]float32)
+					for k, score := range elem.(map[string]interface{}) {
+						switch s := score.(type) {
+						case int64:
+							sentence[k] = float32(s)
+						case float64:
+							sentence[k] = float32(s)
+						}
+					}
+					resp = append(resp, sentence)
+				}
+				return resp
+			},
+		},
+		{
+			name:   "protobuf",
+			accept: contentTypeProtobuf,
+			decode: func(t *testing.T, body []byte) Response {
+				msg := &SentimentResponse{}
+				assert.NoError(t, proto.Unmarshal(body, msg))
+
+				var resp Response
+				for _, s := range msg.Sentences {
+					resp = append(resp, map[string]float32{s.Text: s.Score})
+				}
+				return resp
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api?order=asc", strings.NewReader(`{"content":"Great. Bad."}`))
+			request.Header.Set("Accept", tc.accept)
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, http.StatusOK, result.StatusCode)
+			assert.Equal(t, tc.accept, result.Header.Get("Content-Type"))
+			assert.Equal(t, expected, tc.decode(t, responseRecorder.Body.Bytes()))
+		})
+	}
+}
+
+func TestSerializeUnsupportedOutput(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+	responseRecorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api?v=2", strings.NewReader(`{"content":"Great."}`))
+	request.Header.Set("Accept", contentTypeProtobuf)
+	svc.handleHTTPRequest(responseRecorder, request)
+
+	assert.Equal(t, http.StatusNotAcceptable, responseRecorder.Result().StatusCode)
+}
+
+func TestMarshalRawProto(t *testing.T) {
+	testCases := []struct {
+		name     string
+		msg      proto.Message
+		expected string
+	}{
+		{
+			name: "oneof_and_enums",
+			msg: &languagepb.AnalyzeSentimentRequest{
+				Document: &languagepb.Document{
+					Source: &languagepb.Document_Content{Content: "I love Paris."},
+					Type:   languagepb.Document_PLAIN_TEXT,
+				},
+				EncodingType: languagepb.EncodingType_UTF8,
+			},
+			expected: `{"document":{"type":"PLAIN_TEXT","content":"I love Paris."},"encodingType":"UTF8"}`,
+		},
+		{
+			name: "nested_enums",
+			msg: &languagepb.AnnotateTextResponse{
+				Entities: []*languagepb.Entity{{Name: "Paris", Type: languagepb.Entity_LOCATION, Salience: 1}},
+				Tokens: []*languagepb.Token{{
+					Text:           &languagepb.TextSpan{Content: "love", BeginOffset: 2},
+					PartOfSpeech:   &languagepb.PartOfSpeech{Tag: languagepb.PartOfSpeech_VERB},
+					DependencyEdge: &languagepb.DependencyEdge{Label: languagepb.DependencyEdge_ROOT, HeadTokenIndex: 1},
+				}},
+				Language: "en",
+			},
+			expected: `{"tokens":[{"text":{"content":"love","beginOffset":2},"partOfSpeech":{"tag":"VERB"},"dependencyEdge":{"headTokenIndex":1,"label":"ROOT"}}],` +
+				`"entities":[{"name":"Paris","type":"LOCATION","salience":1}],"language":"en"}`,
+		},
+		{
+			name: "sentiment_response",
+			msg: &languagepb.AnalyzeSentimentResponse{
+				DocumentSentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+				Language:          "en",
+				Sentences: []*languagepb.Sentence{{
+					Text:      &languagepb.TextSpan{Content: "Great."},
+					Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+				}},
+			},
+			expected: `{"documentSentiment":{"magnitude":0.5,"score":0.5},"language":"en","sentences":[{"text":{"content":"Great."},"sentiment":{"magnitude":0.5,"score":0.5}}]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := marshalRawProto(tc.msg)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(raw))
+		})
+	}
+}
+
+func TestMarshalMsgpack(t *testing.T) {
+	input := map[string]interface{}{
+		"text":   strings.Repeat("a", 40),
+		"count":  -100,
+		"small":  3,
+		"score":  0.25,
+		"nested": []interface{}{true, false, nil},
+	}
+
+	body, err := marshalMsgpack(input)
+	assert.NoError(t, err)
+
+	v, err := readMsgpack(bytes.NewReader(body))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"text":   strings.Repeat("a", 40),
+		"count":  int64(-100),
+		"small":  int64(3),
+		"score":  0.25,
+		"nested": []interface{}{true, false, nil},
+	}, v)
+}
+
+// readMsgpack decodes the subset of MessagePack produced by marshalMsgpack
+func readMsgpack(r *bytes.Reader) (interface{}, error) {
+	code, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	readLen := func(size int) (int, error) {
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, err
+		}
+		switch size {
+		case 1:
+			return int(buf[0]), nil
+		case 2:
+			return int(binary.BigEndian.Uint16(buf)), nil
+		default:
+			return int(binary.BigEndian.Uint32(buf)), nil
+		}
+	}
+
+	var n int
+	switch {
+	case code == 0xc0:
+		return nil, nil
+	case code == 0xc2:
+		return false, nil
+	case code == 0xc3:
+		return true, nil
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code == 0xd3:
+		var i int64
+		err := binary.Read(r, binary.BigEndian, &i)
+		return i, err
+	case code == 0xcb:
+		var bits uint64
+		err := binary.Read(r, binary.BigEndian, &bits)
+		return math.Float64frombits(bits), err
+	case code&0xe0 == 0xa0, code == 0xd9, code == 0xda, code == 0xdb:
+		switch code {
+		case 0xd9:
+			n, err = readLen(1)
+		case 0xda:
+			n, err = readLen(2)
+		case 0xdb:
+			n, err = readLen(4)
+		default:
+			n = int(code & 0x1f)
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		_, err = io.ReadFull(r, buf)
+		return string(buf), err
+	case code&0xf0 == 0x90, code == 0xdc, code == 0xdd:
+		switch code {
+		case 0xdc:
+			n, err = readLen(2)
+		case 0xdd:
+			n, err = readLen(4)
+		default:
+			n = int(code & 0x0f)
+		}
+		if err != nil {
+			return nil, err
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = readMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case code&0xf0 == 0x80, code == 0xde, code == 0xdf:
+		switch code {
+		case 0xde:
+			n, err = readLen(2)
+		case 0xdf:
+			n, err = readLen(4)
+		default:
+			n = int(code & 0x0f)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := readMsgpack(r)
+			if err != nil {
+				return nil, err
+			}
+			if m[k.(string)], err = readMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported msgpack code %#x", code)
+	}
+}
+
+func TestResponseContentType(t *testing.T) {
+	endpoints := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{name: "api", method: http.MethodPost, target: "/api", body: `{"content":"Great."}`},
+		{name: "batch", method: http.MethodPost, target: "/batch", body: `{"documents":[{"content":"Great."}]}`},
+		{name: "detect", method: http.MethodPost, target: "/detect", body: `{"content":"Great."}`},
+		{name: "version", method: http.MethodGet, target: "/version"},
+		{name: "health", method: http.MethodGet, target: "/health"},
+	}
+
+	for _, endpoint := range endpoints {
+		for _, accept := range []string{"", contentTypeJSON, contentTypeMsgpack} {
+			t.Run(endpoint.name+"_"+accept, func(t *testing.T) {
+				mockClient, svc := createMocks(t)
+				svc.health = newHealthTracker(10, 0.5)
+				mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{Language: "en"}, nil)
+
+				responseRecorder := httptest.NewRecorder()
+				request := httptest.NewRequest(endpoint.method, endpoint.target, strings.NewReader(endpoint.body))
+				if accept != "" {
+					request.Header.Set("Accept", accept)
+				}
+				svc.RESTHandler().ServeHTTP(responseRecorder, request)
+				result := responseRecorder.Result()
+
+				expected := accept
+				if expected == "" {
+					expected = contentTypeJSON
+				}
+				assert.Equal(t, http.StatusOK, result.StatusCode)
+				assert.Equal(t, expected, result.Header.Get("Content-Type"))
+				assert.Contains(t, result.Header["Vary"], "Accept")
+			})
+		}
+	}
+}
+
+func TestResponseCompression(t *testing.T) {
+	apiResponse := syntheticResponse(100)
+
+	testCases := []struct {
+		name           string
+		compression    bool
+		acceptEncoding string
+		content        string
+		expectGzip     bool
+	}{
+		{name: "disabled", acceptEncoding: "gzip", expectGzip: false},
+		{name: "not_accepted", compression: true, acceptEncoding: "identity", expectGzip: false},
+		{name: "refused", compression: true, acceptEncoding: "gzip;q=0", expectGzip: false},
+		{name: "accepted", compression: true, acceptEncoding: "deflate, gzip", expectGzip: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.responseCompression = tc.compression
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api?order=desc", strings.NewReader(`{"content":"many sentences"}`))
+			request.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, http.StatusOK, result.StatusCode)
+			assert.Equal(t, contentTypeJSON, result.Header.Get("Content-Type"))
+
+			body := io.Reader(responseRecorder.Body)
+			if tc.expectGzip {
+				assert.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+				gz, err := gzip.NewReader(body)
+				assert.NoError(t, err)
+				body = gz
+			} else {
+				assert.Empty(t, result.Header.Get("Content-Encoding"))
+			}
+
+			var resp Response
+			assert.NoError(t, json.NewDecoder(body).Decode(&resp))
+			assert.Len(t, resp, 100)
+		})
+	}
+}
+
+func TestRawUTF8Output(t *testing.T) {
+	text := "Café <3 & naïve > 日本語."
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: text},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		raw      bool
+		target   string
+		expected string
+	}{
+		{
+			name:     "escaped",
+			target:   "/api",
+			expected: `[{"Café \u003c3 \u0026 naïve \u003e 日本語.":0.5}]` + "\n",
+		},
+		{
+			name:     "raw",
+			raw:      true,
+			target:   "/api",
+			expected: `[{"Café <3 & naïve > 日本語.":0.5}]` + "\n",
+		},
+		{
+			name:     "raw_v2",
+			raw:      true,
+			target:   "/api?v=2&fields=text",
+			expected: `{"sentences":[{"text":"Café <3 & naïve > 日本語."}]}` + "\n",
+		},
+		{
+			name:     "raw_stream",
+			raw:      true,
+			target:   "/api?stream=true",
+			expected: `{"Café <3 & naïve > 日本語.":0.5}` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.rawUTF8Output = tc.raw
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"`+text+`"}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+
+			assert.Equal(t, http.StatusOK, responseRecorder.Code)
+			assert.Equal(t, tc.expected, responseRecorder.Body.String())
+		})
+	}
+}
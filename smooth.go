@@ -0,0 +1,82 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// SmoothedResponse is the output type when the sentence scores are reduced to a moving average over a window of
+// consecutive sentences in document order
+type SmoothedResponse struct {
+	// Window is the number of sentences averaged for each score, which is less than requested for short documents
+	Window int       `json:"window"`
+	Scores []float32 `json:"scores"`
+}
+
+// parseSmoothWindow validates the number of sentences to average requested with the smooth parameter. Zero means
+// that no smoothing was requested.
+func parseSmoothWindow(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	window, err := strconv.Atoi(value)
+	if err != nil || window < 1 {
+		return 0, fmt.Errorf("smooth must be a positive integer")
+	}
+
+	return window, nil
+}
+
+// smoothScores computes the mean score of every run of window consecutive sentences, so that n sentences yield
+// n-window+1 scores. A window larger than the number of sentences is reduced to cover all of them.
+func smoothScores(sentences []*languagepb.Sentence, window int) SmoothedResponse {
+	if window > len(sentences) {
+		window = len(sentences)
+	}
+
+	smoothed := SmoothedResponse{Window: window, Scores: []float32{}}
+	if window == 0 {
+		return smoothed
+	}
+
+	// a running sum keeps this linear in the number of sentences regardless of the window
+	var sum float64
+	for i, sentence := range sentences {
+		sum += float64(sentence.Sentiment.Score)
+		if i >= window {
+			sum -= float64(sentences[i-window].Sentiment.Score)
+		}
+		if i >= window-1 {
+			smoothed.Scores = append(smoothed.Scores, float32(sum/float64(window)))
+		}
+	}
+
+	return smoothed
+}
+
+// processAPIResultSmoothed reduces all the sentences of the result, in document order, to the moving average of their
+// scores over the given window
+func (svc *Service) processAPIResultSmoothed(ctx context.Context, result *languagepb.AnalyzeSentimentResponse, window int) (*SmoothedResponse, error) {
+	if err := ctx.Err(); err != nil {
+		svc.logger.Errorw("Context cancelled", "error", err)
+		return nil, err
+	}
+
+	for i, sentence := range result.GetSentences() {
+		if sentence.Text == nil || sentence.Sentiment == nil {
+			return nil, fmt.Errorf("malformed sentence at index %d", i)
+		}
+	}
+
+	// the sentences of a result from the Google API are in document order
+	smoothed := smoothScores(result.GetSentences(), window)
+	for i, score := range smoothed.Scores {
+		smoothed.Scores[i] = svc.conf.rescale(score)
+	}
+
+	return &smoothed, nil
+}
@@ -0,0 +1,120 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+var smoothFixture = &languagepb.AnalyzeSentimentResponse{
+	Sentences: []*languagepb.Sentence{
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word1"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: 0.5},
+		},
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word2"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: 1.0},
+		},
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word3"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: 0.0},
+		},
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word4"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: -0.5},
+		},
+		&languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "word5"},
+			Sentiment: &languagepb.Sentiment{Magnitude: 1.0, Score: -1.0},
+		},
+	},
+}
+
+func TestSmoothScores(t *testing.T) {
+	testCases := []struct {
+		name     string
+		window   int
+		expected SmoothedResponse
+	}{
+		{name: "window_of_one", window: 1, expected: SmoothedResponse{Window: 1, Scores: []float32{0.5, 1.0, 0.0, -0.5, -1.0}}},
+		{name: "window_of_two", window: 2, expected: SmoothedResponse{Window: 2, Scores: []float32{0.75, 0.5, -0.25, -0.75}}},
+		{name: "window_of_four", window: 4, expected: SmoothedResponse{Window: 4, Scores: []float32{0.25, -0.125}}},
+		{name: "whole_document", window: 5, expected: SmoothedResponse{Window: 5, Scores: []float32{0}}},
+		{name: "window_too_large", window: 50, expected: SmoothedResponse{Window: 5, Scores: []float32{0}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, smoothScores(smoothFixture.Sentences, tc.window))
+		})
+	}
+
+	t.Run("no_sentences", func(t *testing.T) {
+		assert.Equal(t, SmoothedResponse{Window: 0, Scores: []float32{}}, smoothScores(nil, 3))
+	})
+}
+
+func TestProcessAPIResultSmoothed(t *testing.T) {
+	t.Run("scaled", func(t *testing.T) {
+		svc := &Service{conf: &config{scoreScaled: true, scoreMin: 0, scoreMax: 10}, logger: zap.NewNop().Sugar()}
+		smoothed, err := svc.processAPIResultSmoothed(context.Background(), smoothFixture, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, &SmoothedResponse{Window: 2, Scores: []float32{8.75, 7.5, 3.75, 1.25}}, smoothed)
+	})
+
+	t.Run("malformed_sentence", func(t *testing.T) {
+		svc := &Service{logger: zap.NewNop().Sugar()}
+		_, err := svc.processAPIResultSmoothed(context.Background(), &languagepb.AnalyzeSentimentResponse{
+			Sentences: []*languagepb.Sentence{{Text: &languagepb.TextSpan{Content: "word1"}}},
+		}, 2)
+		assert.Error(t, err)
+	})
+}
+
+func TestSmoothHTTPRequest(t *testing.T) {
+	testCases := []struct {
+		name           string
+		target         string
+		expectedStatus int
+		expected       SmoothedResponse
+	}{
+		{name: "window", target: "/api?smooth=2", expectedStatus: http.StatusOK, expected: SmoothedResponse{Window: 2, Scores: []float32{0.75, 0.5, -0.25, -0.75}}},
+		{name: "ignores_order", target: "/api?smooth=2&order=desc&limit=1&offset=1", expectedStatus: http.StatusOK, expected: SmoothedResponse{Window: 2, Scores: []float32{0.75, 0.5, -0.25, -0.75}}},
+		{name: "window_too_large", target: "/api?smooth=100", expectedStatus: http.StatusOK, expected: SmoothedResponse{Window: 5, Scores: []float32{0}}},
+		{name: "zero_window", target: "/api?smooth=0", expectedStatus: http.StatusBadRequest},
+		{name: "invalid_window", target: "/api?smooth=three", expectedStatus: http.StatusBadRequest},
+		{name: "with_format", target: "/api?smooth=2&format=trinary", expectedStatus: http.StatusBadRequest},
+		{name: "with_v2", target: "/api?smooth=2&v=2", expectedStatus: http.StatusBadRequest},
+		{name: "with_stream", target: "/api?smooth=2&stream=true", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(smoothFixture, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"word1. word2. word3. word4. word5."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, tc.expectedStatus, result.StatusCode)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var smoothed SmoothedResponse
+			assert.NoError(t, json.NewDecoder(result.Body).Decode(&smoothed))
+			assert.Equal(t, tc.expected, smoothed)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+type batchProgress struct {
+	Completed int `json:"completed"`
+	Total     int `json:"total"`
+}
+
+type batchStreamError struct {
+	Error string `json:"error"`
+}
+
+// handleBatchSSERequest processes a batch like handleBatchRequest but streams the progress to the client as
+// Server-Sent Events, followed by a result event containing the output of the batch
+func (svc *Service) handleBatchSSERequest(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		defer func() {
+			io.Copy(ioutil.Discard, r.Body)
+			r.Body.Close()
+		}()
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		svc.logger.Errorw("Streaming is not supported by the response writer")
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	req, ok := svc.parseBatchRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// the batch is abandoned if the client goes away or the stream can no longer be written to
+	ctx, cancelFunc := context.WithCancel(req.ctx)
+	defer cancelFunc()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	writeEvent := func(event string, data interface{}) bool {
+		if err := writeSSEEvent(w, event, data); err != nil {
+			svc.logger.Warnw("Failed to write event", "event", event, "error", err)
+			cancelFunc()
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	total := len(req.inputs)
+	progress := make(chan struct{}, total)
+	done := make(chan []BatchResult, 1)
+	go func() {
+		done <- svc.processBatch(ctx, req.inputs, req.sortOrder, req.limit, func() { progress <- struct{}{} })
+	}()
+
+	completed := 0
+	for {
+		select {
+		case <-progress:
+			completed++
+			if !writeEvent("progress", batchProgress{Completed: completed, Total: total}) {
+				<-done
+				return
+			}
+		case results := <-done:
+			// report any progress that raced with the completion of the batch
+			for len(progress) > 0 {
+				<-progress
+				completed++
+				if !writeEvent("progress", batchProgress{Completed: completed, Total: total}) {
+					return
+				}
+			}
+
+			if err := ctx.Err(); err != nil {
+				svc.logger.Errorw("Batch request failed", "error", err)
+				writeEvent("error", batchStreamError{Error: err.Error()})
+				return
+			}
+
+			writeEvent("result", req.output(results))
+			return
+		}
+	}
+}
+
+// writeSSEEvent writes a single Server-Sent Event with the data encoded as JSON
+func writeSSEEvent(w io.Writer, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
@@ -0,0 +1,150 @@
+package sentiment
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// readSSEEvent reads the next event from a Server-Sent Events stream
+func readSSEEvent(reader *bufio.Reader) (string, string, error) {
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			return event, data, nil
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestBatchSSERequest(t *testing.T) {
+	t.Run("progress_and_result", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.batchConcurrency = 2
+
+		isFailing := mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+			return req.GetDocument().GetContent() == "failing"
+		})
+		mockClient.On("AnalyzeSentiment", mock.Anything, isFailing, mock.Anything).Return(nil, fmt.Errorf("error"))
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{
+			Sentences: []*languagepb.Sentence{
+				&languagepb.Sentence{
+					Text:      &languagepb.TextSpan{Content: "I love the product."},
+					Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+				},
+			},
+		}, nil)
+
+		server := httptest.NewServer(svc.RESTHandler())
+		defer server.Close()
+
+		body := `{"documents":[{"content":"doc 1"},{"content":"failing"},{"content":"doc 3"},{"content":"doc 4"}]}`
+		resp, err := http.Post(server.URL+"/batch/sse", "application/json", strings.NewReader(body))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		reader := bufio.NewReader(resp.Body)
+		for i := 1; i <= 4; i++ {
+			event, data, err := readSSEEvent(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, "progress", event)
+
+			var progress batchProgress
+			assert.NoError(t, json.Unmarshal([]byte(data), &progress))
+			assert.Equal(t, batchProgress{Completed: i, Total: 4}, progress)
+		}
+
+		event, data, err := readSSEEvent(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, "result", event)
+
+		var output batchDocumentsOutput
+		assert.NoError(t, json.Unmarshal([]byte(data), &output))
+		assert.Len(t, output.Results, 4)
+		assert.Equal(t, 3, output.Succeeded)
+		assert.Equal(t, 1, output.Failed)
+		assert.Equal(t, "Failed to analyze document", output.Results[1].Error)
+		for _, i := range []int{0, 2, 3} {
+			assert.Equal(t, i, output.Results[i].Index)
+			assert.Empty(t, output.Results[i].Error)
+			assert.Equal(t, Response([]map[string]float32{map[string]float32{"I love the product.": 0.9}}), output.Results[i].Result)
+		}
+	})
+
+	t.Run("client_disconnect_cancels_batch", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		svc.conf.batchConcurrency = 1
+		svc.conf.requestTimeout = 0
+
+		isBlocking := mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+			return req.GetDocument().GetContent() == "blocking"
+		})
+		cancelled := make(chan struct{})
+		mockClient.On("AnalyzeSentiment", mock.Anything, isBlocking, mock.Anything).Return(nil, context.Canceled).Run(func(args mock.Arguments) {
+			select {
+			case <-args.Get(0).(context.Context).Done():
+				close(cancelled)
+			case <-time.After(5 * time.Second):
+			}
+		})
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+		server := httptest.NewServer(svc.RESTHandler())
+		defer server.Close()
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		body := `{"documents":[{"content":"doc 1"},{"content":"blocking"},{"content":"doc 3"}]}`
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/batch/sse", strings.NewReader(body))
+		assert.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		event, _, err := readSSEEvent(bufio.NewReader(resp.Body))
+		assert.NoError(t, err)
+		assert.Equal(t, "progress", event)
+
+		cancelFunc()
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("batch was not cancelled after the client disconnected")
+		}
+	})
+
+	t.Run("invalid_method", func(t *testing.T) {
+		_, svc := createMocks(t)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/batch/sse", nil)
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, responseRecorder.Result().StatusCode)
+	})
+}
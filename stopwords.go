@@ -0,0 +1,94 @@
+package sentiment
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DefaultStopwords are the stopwords removed by WithStopwordRemoval, keyed by primary language subtag
+var DefaultStopwords = map[string][]string{
+	"en": {
+		"a", "an", "and", "are", "as", "at", "be", "been", "by", "for", "from", "had", "has", "have", "he", "her",
+		"his", "i", "in", "is", "it", "it's", "its", "me", "my", "of", "on", "or", "our", "she", "so", "that", "the",
+		"their", "them", "then", "there", "these", "they", "this", "those", "to", "us", "was", "we", "were", "which",
+		"who", "will", "with", "you", "your",
+	},
+}
+
+// stopwordLanguage is the language whose stopwords are removed when the language of the input is not known
+const stopwordLanguage = "en"
+
+// stopwordRemover removes the stopwords of the language of a text
+type stopwordRemover struct {
+	stopwords map[string]map[string]bool
+}
+
+// newStopwordRemover creates a stopwordRemover using DefaultStopwords, with the lists given for a language replacing
+// the default list of that language
+func newStopwordRemover(lists map[string][]string) *stopwordRemover {
+	sr := &stopwordRemover{stopwords: make(map[string]map[string]bool)}
+	add := func(language string, words []string) {
+		set := make(map[string]bool, len(words))
+		for _, word := range words {
+			set[strings.ToLower(word)] = true
+		}
+		sr.stopwords[primaryLanguage(language)] = set
+	}
+
+	for language, words := range DefaultStopwords {
+		add(language, words)
+	}
+
+	for language, words := range lists {
+		add(language, words)
+	}
+
+	return sr
+}
+
+// remove strips the stopwords of the language from the text and collapses the remaining whitespace. Punctuation is
+// kept so that the sentences of the text are still recognised. English stopwords are removed if the language is not
+// known and the text is returned unchanged if there is no list for the language.
+func (sr *stopwordRemover) remove(text string, language string) string {
+	if sr == nil {
+		return text
+	}
+
+	if language == "" {
+		language = stopwordLanguage
+	}
+
+	stopwords := sr.stopwords[primaryLanguage(language)]
+	if len(stopwords) == 0 {
+		return text
+	}
+
+	var b strings.Builder
+	start := -1
+	endWord := func(end int) {
+		if word := text[start:end]; !stopwords[strings.ToLower(word)] {
+			b.WriteString(word)
+		}
+		start = -1
+	}
+
+	for i, r := range text {
+		if unicode.IsLetter(r) || r == '\'' {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			endWord(i)
+		}
+		b.WriteRune(r)
+	}
+
+	if start >= 0 {
+		endWord(len(text))
+	}
+
+	return strings.Join(strings.Fields(b.String()), " ")
+}
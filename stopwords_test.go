@@ -0,0 +1,83 @@
+package sentiment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestStopwordRemover(t *testing.T) {
+	sr := newStopwordRemover(map[string][]string{"fr": {"le", "la", "est"}})
+
+	testCases := []struct {
+		name     string
+		text     string
+		language string
+		expected string
+	}{
+		{name: "english", text: "The product is great. I love it!", language: "en", expected: "product great. love !"},
+		{name: "region_subtag", text: "This was AWESOME", language: "en-GB", expected: "AWESOME"},
+		{name: "unknown_language_defaults_to_english", text: "It is the best", expected: "best"},
+		{name: "custom_list", text: "Le produit est excellent.", language: "fr", expected: "produit excellent."},
+		{name: "no_list", text: "Das ist  gut", language: "de", expected: "Das ist  gut"},
+		{name: "apostrophes", text: "It's a don't-miss show", language: "en", expected: "don't-miss show"},
+		{name: "only_stopwords", text: "It is.", language: "en", expected: "."},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, sr.remove(tc.text, tc.language))
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		var sr *stopwordRemover
+		assert.Equal(t, "The product is great.", sr.remove("The product is great.", "en"))
+	})
+}
+
+func TestStopwordRemoval(t *testing.T) {
+	withContent := func(content string) interface{} {
+		return mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+			return req.GetDocument().GetContent() == content
+		})
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithStopwordRemoval(nil)(svc.conf)
+		mockClient.On("AnalyzeSentiment", mock.Anything, withContent("product great."), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		_, err := svc.Analyze(context.Background(), "The product is great.")
+		assert.NoError(t, err)
+
+		// inputs differing only by stopwords share the cache entry of the stripped text
+		_, err = svc.Analyze(context.Background(), "This product was great.")
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+		assert.NotNil(t, svc.getCachedResult(cacheKey(context.Background(), "product great.", svc.analysisParams())))
+	})
+
+	t.Run("default_language", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithStopwordRemoval(map[string][]string{"fr": {"le", "est"}})(svc.conf)
+		svc.conf.defaultLanguage = "fr"
+		mockClient.On("AnalyzeSentiment", mock.Anything, withContent("produit excellent."), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		_, err := svc.Analyze(context.Background(), "Le produit est excellent.")
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("disabled_by_default", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, withContent("The product is great."), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		_, err := svc.Analyze(context.Background(), "The product is great.")
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,40 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	contentTypeNDJSON = "application/x-ndjson"
+	// streamFlushInterval is the number of sentences written between flushes of a streamed response
+	streamFlushInterval = 100
+)
+
+// writeStream writes each sentence of the response as a line of JSON, flushing periodically so that clients can
+// process large results incrementally. Writing stops early if the context is cancelled.
+func (svc *Service) writeStream(ctx context.Context, w http.ResponseWriter, resp Response) error {
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(!svc.conf.rawUTF8Output)
+	for i, sentence := range resp {
+		if i > 0 && i%streamFlushInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if err := enc.Encode(sentence); err != nil {
+			return err
+		}
+	}
+
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return nil
+}
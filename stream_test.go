@@ -0,0 +1,79 @@
+package sentiment
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStreamHTTPRequest(t *testing.T) {
+	apiResponse := syntheticResponse(250)
+
+	mockClient, svc := createMocks(t)
+	mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+	server := httptest.NewServer(svc.RESTHandler())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api?order=desc&stream=true", "application/json", strings.NewReader(`{"content":"many sentences"}`))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, contentTypeNDJSON, resp.Header.Get("Content-Type"))
+
+	var streamed Response
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var sentence map[string]float32
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &sentence))
+		streamed = append(streamed, sentence)
+	}
+	assert.NoError(t, scanner.Err())
+
+	expected, err := svc.ProcessSentiment(context.Background(), "many sentences", Descending, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, streamed)
+}
+
+func TestStreamInvalidCombinations(t *testing.T) {
+	for _, target := range []string{
+		"/api?stream=true&v=2",
+		"/api?stream=true&group=polarity",
+		"/api?stream=true&aggregate_duplicates=true",
+		"/api?stream=true&aggregate=weighted",
+		"/api?stream=true&debug=true",
+	} {
+		t.Run(target, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.debugMode = true
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"content":"Great."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+
+			assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
+			mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestWriteStreamCancellation(t *testing.T) {
+	_, svc := createMocks(t)
+	resp, err := ReduceResponse(syntheticResponse(250), Descending, -1)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	responseRecorder := httptest.NewRecorder()
+	assert.Equal(t, context.Canceled, svc.writeStream(ctx, responseRecorder, resp))
+	assert.Equal(t, streamFlushInterval, strings.Count(responseRecorder.Body.String(), "\n"))
+}
@@ -0,0 +1,31 @@
+package sentiment
+
+import (
+	"context"
+	"net/http"
+)
+
+// TenantHeader is the HTTP header used to identify the tenant a request belongs to
+const TenantHeader = "X-Tenant-ID"
+
+type tenantKey struct{}
+
+// WithTenant returns a context that isolates the cached results of requests made with it to the given tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFromContext returns the tenant associated with the context, if any
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// requestTenant determines the tenant of an HTTP request. The header takes precedence over the body because it
+// is usually set by trusted infrastructure such as an API gateway.
+func requestTenant(r *http.Request, bodyTenant string) string {
+	if tenant := r.Header.Get(TenantHeader); tenant != "" {
+		return tenant
+	}
+	return bodyTenant
+}
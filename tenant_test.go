@@ -0,0 +1,65 @@
+package sentiment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestTenantCacheIsolation(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "word1"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 3.0, Score: 0.8},
+			},
+		},
+	}
+
+	t.Run("process_sentiment", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		for _, tenant := range []string{"", "tenantA", "tenantB", "tenantA", "tenantB", ""} {
+			_, err := svc.ProcessSentiment(WithTenant(context.Background(), tenant), "word1", Ascending, -1)
+			assert.NoError(t, err)
+		}
+
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 3)
+		assert.NotNil(t, svc.getCachedResult(cacheKey(WithTenant(context.Background(), "tenantA"), "word1", svc.analysisParams())))
+		assert.NotNil(t, svc.getCachedResult(cacheKey(WithTenant(context.Background(), "tenantB"), "word1", svc.analysisParams())))
+		assert.NotNil(t, svc.getCachedResult(cacheKey(context.Background(), "word1", svc.analysisParams())))
+	})
+
+	t.Run("http_request", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		doRequest := func(headerTenant string, body string) {
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(body))
+			if headerTenant != "" {
+				request.Header.Set(TenantHeader, headerTenant)
+			}
+			svc.handleHTTPRequest(responseRecorder, request)
+			assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+		}
+
+		doRequest("tenantA", `{"content":"word1"}`)
+		doRequest("", `{"content":"word1","tenant":"tenantA"}`)
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 1)
+
+		// header takes precedence over the body
+		doRequest("tenantB", `{"content":"word1","tenant":"tenantA"}`)
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 2)
+
+		doRequest("", `{"content":"word1"}`)
+		mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 3)
+	})
+}
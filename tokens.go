@@ -0,0 +1,30 @@
+package sentiment
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InputTooShortError is returned when the input has fewer tokens than the configured minimum. The remote API is not
+// called for such inputs because the results are unreliable.
+type InputTooShortError struct {
+	Tokens    int
+	MinTokens int
+}
+
+func (e *InputTooShortError) Error() string {
+	return fmt.Sprintf("input has %d tokens, at least %d required", e.Tokens, e.MinTokens)
+}
+
+// checkMinTokens returns an InputTooShortError if the input has fewer whitespace delimited tokens than the minimum
+func (c *config) checkMinTokens(input string) error {
+	if c.minTokens <= 0 {
+		return nil
+	}
+
+	if tokens := len(strings.Fields(input)); tokens < c.minTokens {
+		return &InputTooShortError{Tokens: tokens, MinTokens: c.minTokens}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package sentiment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestMinTokens(t *testing.T) {
+	testCases := []struct {
+		name      string
+		minTokens int
+		input     string
+		tooShort  bool
+	}{
+		{name: "disabled", minTokens: 0, input: "Great."},
+		{name: "below", minTokens: 3, input: "Really great.", tooShort: true},
+		{name: "whitespace_only", minTokens: 1, input: " \t\n ", tooShort: true},
+		{name: "exact", minTokens: 3, input: "Really  great\tproduct."},
+		{name: "above", minTokens: 3, input: "This is a really great product."},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.minTokens = tc.minTokens
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil)
+
+			_, err := svc.Analyze(context.Background(), tc.input)
+			if tc.tooShort {
+				assert.IsType(t, &InputTooShortError{}, err)
+				mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+			} else {
+				assert.NoError(t, err)
+				mockClient.AssertNumberOfCalls(t, "AnalyzeSentiment", 1)
+			}
+		})
+	}
+}
+
+func TestMinTokensHTTPRequest(t *testing.T) {
+	mockClient, svc := createMocks(t)
+	svc.conf.minTokens = 3
+
+	responseRecorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"content":"Great."}`))
+	svc.handleHTTPRequest(responseRecorder, request)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, responseRecorder.Result().StatusCode)
+	mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,64 @@
+package sentiment
+
+import (
+	"context"
+	"fmt"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// formatTrinary is the value of the format parameter requesting the polarity of each sentence as an integer
+const formatTrinary = "trinary"
+
+// TrinaryResponse is the output type when the sentiment of each sentence is reduced to -1, 0 or 1
+type TrinaryResponse []TrinarySentence
+
+// TrinarySentence holds the polarity of a sentence as -1 for negative, 0 for neutral and 1 for positive
+type TrinarySentence struct {
+	Text      string `json:"text"`
+	Sentiment int    `json:"sentiment"`
+}
+
+// trinary maps the polarity to its integer representation
+func (p Polarity) trinary() int {
+	switch p {
+	case Positive:
+		return 1
+	case Negative:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// processAPIResultTrinary sorts and limits the sentences like processAPIResult but reports the polarity of each
+// sentence, as classified by the configured thresholds, instead of its score
+func (svc *Service) processAPIResultTrinary(ctx context.Context, result *languagepb.AnalyzeSentimentResponse, sortOrder SortOrder, limit int) (TrinaryResponse, error) {
+	if err := ctx.Err(); err != nil {
+		svc.logger.Errorw("Context cancelled", "error", err)
+		return nil, err
+	}
+
+	for i, sentence := range result.GetSentences() {
+		if sentence.Text == nil || sentence.Sentiment == nil {
+			return nil, fmt.Errorf("malformed sentence at index %d", i)
+		}
+	}
+
+	sentences := selectSentences(result.GetSentences(), sortOrder, limit)
+	resp := make(TrinaryResponse, len(sentences))
+	for i, sentence := range sentences {
+		if i > 0 && i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp[i] = TrinarySentence{
+			Text:      svc.conf.truncateText(sentence.Text.Content),
+			Sentiment: svc.conf.classify(sentence.Sentiment.Score).trinary(),
+		}
+	}
+
+	return resp, nil
+}
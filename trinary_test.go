@@ -0,0 +1,138 @@
+package sentiment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestProcessAPIResultTrinary(t *testing.T) {
+	scores := []float32{-1, -0.26, -0.25, -0.1, 0, 0.25, 0.26, 1}
+	result := &languagepb.AnalyzeSentimentResponse{}
+	for _, score := range scores {
+		result.Sentences = append(result.Sentences, &languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: "sentence"},
+			Sentiment: &languagepb.Sentiment{Score: score},
+		})
+	}
+
+	t.Run("band_boundaries", func(t *testing.T) {
+		svc := &Service{conf: &config{negativeThreshold: defaultNegativeThreshold, positiveThreshold: defaultPositiveThreshold}, logger: zap.NewNop().Sugar()}
+		resp, err := svc.processAPIResultTrinary(context.Background(), result, DocumentOrder, -1)
+		assert.NoError(t, err)
+
+		// the thresholds themselves are neutral
+		expected := []int{-1, -1, 0, 0, 0, 0, 1, 1}
+		assert.Len(t, resp, len(expected))
+		for i, sentence := range resp {
+			assert.Equal(t, expected[i], sentence.Sentiment, "score %v", scores[i])
+		}
+	})
+
+	t.Run("custom_band", func(t *testing.T) {
+		svc := &Service{conf: &config{negativeThreshold: -0.05, positiveThreshold: 0.5}, logger: zap.NewNop().Sugar()}
+		resp, err := svc.processAPIResultTrinary(context.Background(), result, DocumentOrder, -1)
+		assert.NoError(t, err)
+
+		expected := []int{-1, -1, -1, -1, 0, 0, 0, 1}
+		for i, sentence := range resp {
+			assert.Equal(t, expected[i], sentence.Sentiment, "score %v", scores[i])
+		}
+	})
+
+	t.Run("sorted_and_limited", func(t *testing.T) {
+		svc := &Service{conf: &config{negativeThreshold: defaultNegativeThreshold, positiveThreshold: defaultPositiveThreshold}, logger: zap.NewNop().Sugar()}
+		resp, err := svc.processAPIResultTrinary(context.Background(), result, Descending, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, TrinaryResponse{{Text: "sentence", Sentiment: 1}, {Text: "sentence", Sentiment: 1}}, resp)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		svc := &Service{logger: zap.NewNop().Sugar()}
+		resp, err := svc.processAPIResultTrinary(context.Background(), nil, Descending, -1)
+		assert.NoError(t, err)
+		assert.Empty(t, resp)
+	})
+
+	t.Run("malformed_sentence", func(t *testing.T) {
+		svc := &Service{logger: zap.NewNop().Sugar()}
+		_, err := svc.processAPIResultTrinary(context.Background(), &languagepb.AnalyzeSentimentResponse{
+			Sentences: []*languagepb.Sentence{{Text: &languagepb.TextSpan{Content: "word1"}}},
+		}, Descending, -1)
+		assert.Error(t, err)
+	})
+}
+
+func TestTrinaryHTTPRequest(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "I hate this site."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.8, Score: -0.8},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "But I love the product."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "It is blue."},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.1, Score: 0.1},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		target         string
+		expectedStatus int
+		expected       TrinaryResponse
+	}{
+		{
+			name:           "document_order",
+			target:         "/api?format=trinary&order=document",
+			expectedStatus: http.StatusOK,
+			expected:       TrinaryResponse{{Text: "I hate this site.", Sentiment: -1}, {Text: "But I love the product.", Sentiment: 1}, {Text: "It is blue.", Sentiment: 0}},
+		},
+		{
+			name:           "descending_with_limit",
+			target:         "/api?format=trinary&order=desc&limit=2",
+			expectedStatus: http.StatusOK,
+			expected:       TrinaryResponse{{Text: "But I love the product.", Sentiment: 1}, {Text: "It is blue.", Sentiment: 0}},
+		},
+		{name: "with_v2", target: "/api?format=trinary&v=2", expectedStatus: http.StatusBadRequest},
+		{name: "with_group", target: "/api?format=trinary&group=polarity", expectedStatus: http.StatusBadRequest},
+		{name: "with_stream", target: "/api?format=trinary&stream=true", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			svc.conf.negativeThreshold = defaultNegativeThreshold
+			svc.conf.positiveThreshold = defaultPositiveThreshold
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"I hate this site. But I love the product. It is blue."}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, tc.expectedStatus, result.StatusCode)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp TrinaryResponse
+			assert.NoError(t, json.NewDecoder(result.Body).Decode(&resp))
+			assert.Equal(t, tc.expected, resp)
+			assert.Equal(t, "3", result.Header.Get(totalCountHeader))
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package sentiment
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// InvalidUTF8Handling is an enum defining what happens to inputs that are not valid UTF-8, which the Google API rejects
+type InvalidUTF8Handling int
+
+const (
+	// RejectInvalidUTF8 fails the analysis with an InvalidUTF8Error without calling the Google API
+	RejectInvalidUTF8 InvalidUTF8Handling = iota
+	// SanitizeInvalidUTF8 replaces each run of invalid bytes with the Unicode replacement character and analyzes the
+	// rest of the input
+	SanitizeInvalidUTF8
+)
+
+// InvalidUTF8Error is returned when the input is not valid UTF-8 and invalid inputs are rejected
+type InvalidUTF8Error struct {
+	// Offset is the position in bytes of the first invalid byte
+	Offset int
+}
+
+func (e *InvalidUTF8Error) Error() string {
+	return fmt.Sprintf("input is not valid UTF-8: invalid byte at offset %d", e.Offset)
+}
+
+// checkUTF8 returns the input, sanitized if configured to, or an InvalidUTF8Error if it is not valid UTF-8
+func (c *config) checkUTF8(input string) (string, error) {
+	if utf8.ValidString(input) {
+		return input, nil
+	}
+
+	if c.invalidUTF8 == SanitizeInvalidUTF8 {
+		return sanitizeUTF8(input), nil
+	}
+
+	offset := 0
+	for offset < len(input) {
+		r, size := utf8.DecodeRuneInString(input[offset:])
+		if r == utf8.RuneError && size == 1 {
+			break
+		}
+		offset += size
+	}
+
+	return "", &InvalidUTF8Error{Offset: offset}
+}
+
+// sanitizeUTF8 replaces each run of invalid bytes in the input with the Unicode replacement character
+func sanitizeUTF8(input string) string {
+	var b strings.Builder
+	b.Grow(len(input))
+
+	invalid := false
+	for i := 0; i < len(input); {
+		r, size := utf8.DecodeRuneInString(input[i:])
+		i += size
+
+		if r == utf8.RuneError && size == 1 {
+			if !invalid {
+				b.WriteRune(utf8.RuneError)
+			}
+			invalid = true
+			continue
+		}
+
+		invalid = false
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
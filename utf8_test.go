@@ -0,0 +1,65 @@
+package sentiment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestSanitizeUTF8(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "valid", input: "Ce produit est génial", expected: "Ce produit est génial"},
+		{name: "invalid_byte", input: "I love\xff it", expected: "I love� it"},
+		{name: "run_of_invalid_bytes", input: "I love\xff\xfe\xfd it", expected: "I love� it"},
+		{name: "truncated_sequence", input: "génial\xc3", expected: "génial�"},
+		{name: "separate_runs", input: "\xffa\xff", expected: "�a�"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, sanitizeUTF8(tc.input))
+		})
+	}
+}
+
+func TestInvalidUTF8Handling(t *testing.T) {
+	withContent := func(content string) interface{} {
+		return mock.MatchedBy(func(req *languagepb.AnalyzeSentimentRequest) bool {
+			return req.GetDocument().GetContent() == content
+		})
+	}
+
+	t.Run("rejected_by_default", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+
+		_, err := svc.ProcessSentiment(context.Background(), "I love the product.\xff\xfe", Descending, -1)
+		assert.Equal(t, &InvalidUTF8Error{Offset: 19}, err)
+		mockClient.AssertNotCalled(t, "AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("sanitized", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		WithInvalidUTF8Handling(SanitizeInvalidUTF8)(svc.conf)
+		mockClient.On("AnalyzeSentiment", mock.Anything, withContent("I love the product.�"), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		_, err := svc.ProcessSentiment(context.Background(), "I love the product.\xff\xfe", Descending, -1)
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("valid_input_unchanged", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, withContent("J'adore ce produit ☺"), mock.Anything).Return(&languagepb.AnalyzeSentimentResponse{}, nil).Once()
+
+		_, err := svc.ProcessSentiment(context.Background(), "J'adore ce produit ☺", Descending, -1)
+		assert.NoError(t, err)
+		mockClient.AssertExpectations(t)
+	})
+}
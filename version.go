@@ -0,0 +1,61 @@
+package sentiment
+
+import (
+	"net/http"
+)
+
+// Version is the version of the service. It is overridden at build time using
+// -ldflags "-X github.com/charithe/sentiment.Version=<version>"
+var Version = "dev"
+
+// versionInfo describes the capabilities of this deployment of the service
+type versionInfo struct {
+	Version                string          `json:"version"`
+	APIVersion             string          `json:"api_version"`
+	SortOrders             []string        `json:"sort_orders"`
+	ResponseVersions       []int           `json:"response_versions"`
+	DefaultResponseVersion int             `json:"default_response_version"`
+	Features               map[string]bool `json:"features"`
+}
+
+// versionInfo reports the endpoints and parameters supported by the service with its current configuration
+func (svc *Service) versionInfo() versionInfo {
+	return versionInfo{
+		Version:                Version,
+		APIVersion:             svc.conf.apiVersion.String(),
+		SortOrders:             []string{"asc", "desc", "document"},
+		ResponseVersions:       []int{int(ResponseV1), int(ResponseV2)},
+		DefaultResponseVersion: int(svc.conf.responseVersion),
+		Features: map[string]bool{
+			"batch":                true,
+			"batch_sse":            true,
+			"polarity_grouping":    true,
+			"aggregate_duplicates": true,
+			"annotate":             true,
+			"compare":              true,
+			"csv":                  true,
+			"histogram":            true,
+			"trinary":              true,
+			"smooth":               true,
+			"language_detection":   true,
+			"social_preprocessing": true,
+			"auto_chunk":           svc.conf.autoChunk,
+			"debug":                svc.conf.debugMode,
+			"fallback":             svc.conf.fallbackAnalyzer != nil,
+			"put_as_post":          svc.conf.allowPut,
+			"reject_empty":         svc.conf.rejectEmpty,
+			"self_test":            svc.conf.selfTest,
+			"url_fetching":         svc.conf.urlFetching,
+		},
+	}
+}
+
+func (svc *Service) handleVersionRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		svc.logger.Warnw("Bad request method", "method", r.Method)
+		http.Error(w, "Bad request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	svc.writeResponse(w, r, http.StatusOK, svc.versionInfo())
+}
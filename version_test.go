@@ -0,0 +1,97 @@
+package sentiment
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionEndpoint(t *testing.T) {
+	testCases := []struct {
+		name             string
+		opts             []Option
+		expectedFeatures map[string]bool
+		expectedVersion  int
+	}{
+		{
+			name:             "defaults",
+			expectedVersion:  1,
+			expectedFeatures: map[string]bool{},
+		},
+		{
+			name: "all_options",
+			opts: []Option{
+				WithAutoChunk(0),
+				WithDebugMode(),
+				WithFallbackAnalyzer(NewLexiconAnalyzer(nil)),
+				WithPutAsPost(),
+				WithRejectEmptyResults(),
+				WithSelfTest(),
+				WithURLFetching(),
+				WithDefaultResponseVersion(ResponseV2),
+			},
+			expectedVersion: 2,
+			expectedFeatures: map[string]bool{
+				"auto_chunk":   true,
+				"debug":        true,
+				"fallback":     true,
+				"put_as_post":  true,
+				"reject_empty": true,
+				"self_test":    true,
+				"url_fetching": true,
+			},
+		},
+		{
+			name:             "some_options",
+			opts:             []Option{WithDebugMode(), WithURLFetching("example.com")},
+			expectedVersion:  1,
+			expectedFeatures: map[string]bool{"debug": true, "url_fetching": true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, svc := createMocks(t)
+			for _, opt := range tc.opts {
+				opt(svc.conf)
+			}
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/version", nil)
+			svc.RESTHandler().ServeHTTP(responseRecorder, request)
+			result := responseRecorder.Result()
+
+			assert.Equal(t, http.StatusOK, result.StatusCode)
+
+			var info versionInfo
+			assert.NoError(t, json.NewDecoder(result.Body).Decode(&info))
+			assert.Equal(t, Version, info.Version)
+			assert.Equal(t, "v1", info.APIVersion)
+			assert.Equal(t, []int{1, 2}, info.ResponseVersions)
+			assert.Equal(t, tc.expectedVersion, info.DefaultResponseVersion)
+
+			// features that are always available
+			for _, feature := range []string{"batch", "batch_sse", "polarity_grouping", "aggregate_duplicates", "annotate", "compare", "csv", "histogram", "trinary", "smooth", "social_preprocessing"} {
+				assert.True(t, info.Features[feature], feature)
+			}
+
+			for _, feature := range []string{"auto_chunk", "debug", "fallback", "put_as_post", "reject_empty", "self_test", "url_fetching"} {
+				assert.Contains(t, info.Features, feature)
+				assert.Equal(t, tc.expectedFeatures[feature], info.Features[feature], feature)
+			}
+		})
+	}
+
+	t.Run("invalid_method", func(t *testing.T) {
+		_, svc := createMocks(t)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/version", nil)
+		svc.RESTHandler().ServeHTTP(responseRecorder, request)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, responseRecorder.Result().StatusCode)
+	})
+}
@@ -0,0 +1,50 @@
+package sentiment
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+// weightLength is the value of the weight parameter that weights each sentence by its length, so that short
+// exclamations count for less than long sentences
+const weightLength = "length"
+
+// sentenceLength returns the number of characters, rather than bytes, of the text of the sentence
+func sentenceLength(sentence *languagepb.Sentence) float32 {
+	return float32(utf8.RuneCountInString(strings.TrimSpace(sentence.GetText().GetContent())))
+}
+
+// applyLengthWeight returns a copy of the result with the sentences arranged in the given sort order of their scores
+// multiplied by their lengths. The sort order to use for further processing of the copy is returned alongside it.
+func applyLengthWeight(result *languagepb.AnalyzeSentimentResponse, sortOrder SortOrder) (*languagepb.AnalyzeSentimentResponse, SortOrder) {
+	if result == nil || sortOrder == DocumentOrder {
+		return result, sortOrder
+	}
+
+	for _, sentence := range result.Sentences {
+		if sentence.Sentiment == nil {
+			// leave malformed results to be rejected by the reduction
+			return result, sortOrder
+		}
+	}
+
+	key := func(sentence *languagepb.Sentence) float32 {
+		return sentence.Sentiment.Score * sentenceLength(sentence)
+	}
+
+	// the result may be shared with the cache so it must not be modified
+	sorted := *result
+	sorted.Sentences = make([]*languagepb.Sentence, len(result.Sentences))
+	copy(sorted.Sentences, result.Sentences)
+	sort.SliceStable(sorted.Sentences, func(i, j int) bool {
+		if sortOrder == Descending {
+			return key(sorted.Sentences[i]) > key(sorted.Sentences[j])
+		}
+		return key(sorted.Sentences[i]) < key(sorted.Sentences[j])
+	})
+
+	return &sorted, DocumentOrder
+}
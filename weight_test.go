@@ -0,0 +1,170 @@
+package sentiment
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+)
+
+func TestSentenceLength(t *testing.T) {
+	sentence := func(text string) *languagepb.Sentence {
+		return &languagepb.Sentence{Text: &languagepb.TextSpan{Content: text}}
+	}
+
+	assert.Equal(t, float32(6), sentenceLength(sentence("Great.")))
+	assert.Equal(t, float32(6), sentenceLength(sentence("  Great.\n")))
+	assert.Equal(t, float32(5), sentenceLength(sentence("すばらしい")))
+	assert.Equal(t, float32(0), sentenceLength(&languagepb.Sentence{}))
+}
+
+func TestLengthWeightedScore(t *testing.T) {
+	sentence := func(text string, score, magnitude float32) *languagepb.Sentence {
+		return &languagepb.Sentence{
+			Text:      &languagepb.TextSpan{Content: text},
+			Sentiment: &languagepb.Sentiment{Score: score, Magnitude: magnitude},
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		sentences []*languagepb.Sentence
+		expected  float32
+	}{
+		{
+			// (0.9 * 1 * 4 + -0.3 * 1 * 16) / (1 * 4 + 1 * 16) = -1.2 / 20
+			name:      "weighted",
+			sentences: []*languagepb.Sentence{sentence("Yay!", 0.9, 1), sentence("It was not good.", -0.3, 1)},
+			expected:  -0.06,
+		},
+		{
+			// (0.9 * 4 + -0.3 * 16) / (4 + 16)
+			name:      "zero_magnitudes",
+			sentences: []*languagepb.Sentence{sentence("Yay!", 0.9, 0), sentence("It was not good.", -0.3, 0)},
+			expected:  -0.06,
+		},
+		{
+			// each character counts once regardless of its encoded size: (0.8 * 2 + -0.4 * 4) / (2 + 4)
+			name:      "multibyte",
+			sentences: []*languagepb.Sentence{sentence("最高", 0.8, 0), sentence("Meh.", -0.4, 0)},
+			expected:  0,
+		},
+		{
+			name:      "empty_text",
+			sentences: []*languagepb.Sentence{sentence("", 0.8, 1)},
+			expected:  0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.InDelta(t, tc.expected, weightedScore(tc.sentences, true), 0.0001)
+		})
+	}
+}
+
+func TestLengthWeightHTTPRequest(t *testing.T) {
+	apiResponse := &languagepb.AnalyzeSentimentResponse{
+		Sentences: []*languagepb.Sentence{
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Wow!"},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.9, Score: 0.9},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "The battery lasts for days.", BeginOffset: 5},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.5, Score: 0.5},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "Ugh.", BeginOffset: 33},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.6, Score: -0.6},
+			},
+			&languagepb.Sentence{
+				Text:      &languagepb.TextSpan{Content: "The screen scratches far too easily.", BeginOffset: 38},
+				Sentiment: &languagepb.Sentiment{Magnitude: 0.4, Score: -0.4},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		target        string
+		expectedOrder []string
+		expectedScore *float32
+	}{
+		{
+			name:          "unweighted_order",
+			target:        "/api?order=desc&v=2",
+			expectedOrder: []string{"Wow!", "The battery lasts for days.", "The screen scratches far too easily.", "Ugh."},
+		},
+		{
+			name:          "weighted_desc",
+			target:        "/api?order=desc&v=2&weight=length",
+			expectedOrder: []string{"The battery lasts for days.", "Wow!", "Ugh.", "The screen scratches far too easily."},
+		},
+		{
+			name:          "weighted_asc_with_limit_and_offset",
+			target:        "/api?order=asc&v=2&weight=length&offset=1&limit=2",
+			expectedOrder: []string{"Ugh.", "Wow!"},
+		},
+		{
+			name:          "weighted_document_order",
+			target:        "/api?order=document&v=2&weight=length",
+			expectedOrder: []string{"Wow!", "The battery lasts for days.", "Ugh.", "The screen scratches far too easily."},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient, svc := createMocks(t)
+			mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+			responseRecorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(`{"content":"review"}`))
+			svc.handleHTTPRequest(responseRecorder, request)
+			assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+
+			var resp EnrichedResponse
+			assert.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&resp))
+			var order []string
+			for _, sentence := range resp.Sentences {
+				order = append(order, sentence.Text)
+			}
+			assert.Equal(t, tc.expectedOrder, order)
+		})
+	}
+
+	t.Run("weighted_aggregate", func(t *testing.T) {
+		mockClient, svc := createMocks(t)
+		mockClient.On("AnalyzeSentiment", mock.Anything, mock.Anything, mock.Anything).Return(apiResponse, nil)
+
+		// (0.9 * 0.9 * 4 + 0.5 * 0.5 * 27 + -0.6 * 0.6 * 4 + -0.4 * 0.4 * 36) / (0.9 * 4 + 0.5 * 27 + 0.6 * 4 + 0.4 * 36)
+		var resp ScoreResponse
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?aggregate=weighted&weight=length", strings.NewReader(`{"content":"review"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		assert.Equal(t, http.StatusOK, responseRecorder.Result().StatusCode)
+		assert.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&resp))
+		assert.InDelta(t, 2.79/33.9, resp.Score, 0.0001)
+
+		// without length weighting the short positive exclamation dominates
+		responseRecorder = httptest.NewRecorder()
+		request = httptest.NewRequest(http.MethodPost, "/api?aggregate=weighted", strings.NewReader(`{"content":"review"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		assert.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&resp))
+		assert.InDelta(t, 0.54/2.4, resp.Score, 0.0001)
+	})
+
+	t.Run("invalid_weight", func(t *testing.T) {
+		_, svc := createMocks(t)
+
+		responseRecorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api?weight=magnitude", strings.NewReader(`{"content":"review"}`))
+		svc.handleHTTPRequest(responseRecorder, request)
+		assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
+	})
+}